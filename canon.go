@@ -0,0 +1,138 @@
+package rssrerun
+
+import (
+    "container/list"
+    "errors"
+    neturl "net/url"
+    "path"
+    "strings"
+    "sync"
+
+    "github.com/patrickyeon/rssrerun/util"
+)
+
+//  A `Store` keeps a feed under its canonical url, so that the same feed isn't
+// stored twice just because it was asked for by two different urls. How far to
+// go in working out what's canonical is up to whoever makes the store.
+
+// Canonicalize a url by fetching it and following any redirects.
+func CanonFollowHttp(url string) (string, error) {
+    return util.CanonicalUrl(url)
+}
+
+//  Canonicalize a url without going anywhere: lowercase the scheme and host,
+// drop default ports, fragments, and "." and ".." path segments, and use the
+// standard escaping.
+func CanonSyntactic(url string) (string, error) {
+    u, err := neturl.Parse(strings.TrimSpace(url))
+    if err != nil {
+        return "", err
+    }
+    if !u.IsAbs() {
+        return "", errors.New("not an absolute url: " + url)
+    }
+    u.Scheme = strings.ToLower(u.Scheme)
+    host, port := strings.ToLower(u.Hostname()), u.Port()
+    if ((u.Scheme == "http" && port == "80") ||
+        (u.Scheme == "https" && port == "443")) {
+        port = ""
+    }
+    if strings.Contains(host, ":") {
+        // IPv6
+        host = "[" + host + "]"
+    }
+    if len(port) > 0 {
+        host += ":" + port
+    }
+    u.Host = host
+    if len(u.Path) == 0 {
+        u.Path = "/"
+    } else {
+        trailing := strings.HasSuffix(u.Path, "/")
+        u.Path = path.Clean(u.Path)
+        if trailing && u.Path != "/" {
+            u.Path += "/"
+        }
+    }
+    u.RawPath = ""
+    u.Fragment = ""
+    return u.String(), nil
+}
+
+// Use urls as they are.
+func CanonIdentity(url string) (string, error) {
+    return url, nil
+}
+
+//  Wrap `canon` in a cache of the last `size` urls it canonicalized, so that
+// (eg) `CanonFollowHttp` doesn't fetch every time. Errors aren't cached. Safe
+// to use from any number of goroutines.
+func NewCachingCanon(canon func(string) (string, error),
+                     size int) func(string) (string, error) {
+    cache := newUrlCache(size)
+    return func(url string) (string, error) {
+        if ret, ok := cache.get(url); ok {
+            return ret, nil
+        }
+        ret, err := canon(url)
+        if err == nil {
+            cache.put(url, ret)
+        }
+        return ret, err
+    }
+}
+
+//  A least-recently-used cache of url canonicalizations, that only ever holds
+// `size` of them.
+type urlCache struct {
+    mu sync.Mutex
+    size int
+    // most recently used at the front
+    order *list.List
+    entries map[string]*list.Element
+}
+
+type urlCacheEntry struct {
+    url, canon string
+}
+
+func newUrlCache(size int) *urlCache {
+    return &urlCache{size: size, order: list.New(),
+                     entries: make(map[string]*list.Element)}
+}
+
+func (c *urlCache) get(url string) (string, bool) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    el, ok := c.entries[url]
+    if !ok {
+        return "", false
+    }
+    c.order.MoveToFront(el)
+    return el.Value.(urlCacheEntry).canon, true
+}
+
+func (c *urlCache) put(url, canon string) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if c.size <= 0 {
+        return
+    }
+    if el, ok := c.entries[url]; ok {
+        el.Value = urlCacheEntry{url, canon}
+        c.order.MoveToFront(el)
+        return
+    }
+    c.entries[url] = c.order.PushFront(urlCacheEntry{url, canon})
+    for c.order.Len() > c.size {
+        oldest := c.order.Back()
+        c.order.Remove(oldest)
+        delete(c.entries, oldest.Value.(urlCacheEntry).url)
+    }
+}
+
+func (c *urlCache) count() int {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    return c.order.Len()
+}
@@ -0,0 +1,141 @@
+package rssrerun
+
+import (
+    "errors"
+    "strconv"
+    "sync"
+    "testing"
+)
+
+func TestCanonSyntactic(t *testing.T) {
+    cases := []struct {
+        url, want string
+    }{
+        {"http://example.com", "http://example.com/"},
+        {"HTTP://Example.COM:80/feed.xml", "http://example.com/feed.xml"},
+        {"https://example.com:443/a/./b/../c/", "https://example.com/a/c/"},
+        {"https://example.com:8443/feed#top", "https://example.com:8443/feed"},
+        {"http://[::1]:80/rss", "http://[::1]/rss"},
+        {"http://example.com/a%20feed?x=1&y=2",
+         "http://example.com/a%20feed?x=1&y=2"},
+        {"  http://example.com/feed  ", "http://example.com/feed"},
+    }
+    for _, c := range cases {
+        got, err := CanonSyntactic(c.url)
+        if err != nil {
+            t.Errorf("%q: %v", c.url, err)
+        } else if got != c.want {
+            t.Errorf("%q should be %q, got %q", c.url, c.want, got)
+        }
+    }
+    for _, bad := range []string{"example.com/feed", "http://exa mple.com/",
+                                 "/feed.xml"} {
+        if _, err := CanonSyntactic(bad); err == nil {
+            t.Errorf("%q shouldn't canonicalize", bad)
+        }
+    }
+}
+
+func TestCachingCanon(t *testing.T) {
+    calls := 0
+    counting := func(url string) (string, error) {
+        calls++
+        if url == "bad" {
+            return "", errors.New("bad url")
+        }
+        return url + "/", nil
+    }
+    canon := NewCachingCanon(counting, 2)
+    for _, url := range []string{"a", "b", "a", "a", "b"} {
+        if got, _ := canon(url); got != url + "/" {
+            t.Errorf("%s came back as %s", url, got)
+        }
+    }
+    if calls != 2 {
+        t.Errorf("should only canonicalize each url once, did %d", calls)
+    }
+    // "c" pushes out "a", which was used longest ago
+    canon("c")
+    canon("b")
+    canon("a")
+    if calls != 4 {
+        t.Errorf("expected 4 canonicalizations, did %d", calls)
+    }
+    // errors are always tried again
+    for i := 0; i < 2; i++ {
+        if _, err := canon("bad"); err == nil {
+            t.Error("should have been an error")
+        }
+    }
+    if calls != 6 {
+        t.Errorf("expected 6 canonicalizations, did %d", calls)
+    }
+}
+
+func TestUrlCacheBounded(t *testing.T) {
+    c := newUrlCache(10)
+    for i := 0; i < 100; i++ {
+        url := strconv.Itoa(i)
+        c.put(url, url)
+    }
+    if c.count() != 10 {
+        t.Errorf("cache should hold 10, holds %d", c.count())
+    }
+    if _, ok := c.get("89"); ok {
+        t.Error("89 should have been evicted")
+    }
+    if got, ok := c.get("90"); !ok || got != "90" {
+        t.Error("90 should still be cached")
+    }
+
+    none := newUrlCache(0)
+    none.put("a", "a")
+    if _, ok := none.get("a"); ok || none.count() != 0 {
+        t.Error("zero-size cache shouldn't hold anything")
+    }
+}
+
+func TestCachingCanonConcurrent(t *testing.T) {
+    canon := NewCachingCanon(CanonSyntactic, 16)
+    var wg sync.WaitGroup
+    for g := 0; g < 8; g++ {
+        wg.Add(1)
+        go func(g int) {
+            defer wg.Done()
+            for i := 0; i < 200; i++ {
+                url := "HTTP://example.com/" + strconv.Itoa((g * i) % 40)
+                want := "http://example.com/" + strconv.Itoa((g * i) % 40)
+                if got, err := canon(url); err != nil || got != want {
+                    t.Errorf("%s came back as %s (%v)", url, got, err)
+                    return
+                }
+            }
+        }(g)
+    }
+    wg.Wait()
+}
+
+func TestJSONStoreOptions(t *testing.T) {
+    emptyStore()
+    keys := map[string]bool{}
+    s := NewJSONStoreWith(TDir + "/store/", JSONStoreOptions{
+        Canon: CanonSyntactic,
+        CanonCacheSize: -1,
+        Key: func(url string) string {
+            keys[url] = true
+            return "feed" + strconv.Itoa(len(url))
+        },
+    })
+    if _, err := s.CreateIndex("HTTP://Example.com:80/feed"); err != nil {
+        t.Fatal(err)
+    }
+    if !keys["http://example.com/feed"] {
+        t.Errorf("key should be made from the canonical url, got %v", keys)
+    }
+    if !s.Contains("http://example.com/./feed#latest") {
+        t.Error("should find the feed by an equivalent url")
+    }
+    if list := s.List(); len(list) != 1 || list[0] != "http://example.com/feed" {
+        t.Errorf("expected just the canonical url, got %v", list)
+    }
+}
@@ -0,0 +1,165 @@
+package rssrerun
+
+import (
+    "errors"
+    "strings"
+
+    "github.com/jbowtie/gokogiri"
+    "github.com/jbowtie/gokogiri/xml"
+)
+
+//  Changes to make to the feed itself (as opposed to its items) so that a rerun
+// doesn't pass for the live feed in a podcast app. The zero `ChannelRewrite`
+// leaves the feed as it is.
+type ChannelRewrite struct {
+    // put on the end of the title, eg " (Rerun)"
+    TitleSuffix string
+    //  where the rerun itself lives, to replace the feed's own `atom:link
+    // rel="self"` (or add one, if it doesn't have one)
+    SelfLink string
+    // put at the start of the description, eg to say what the schedule is
+    DescriptionNote string
+    //  tags to take out of the channel. A "prefix:name" tag goes by the
+    // namespace that prefix has in the feed (or else, the usual one for
+    // "itunes", "podcast", "atom" or "dc"), and a plain "name" is taken out
+    // whatever namespace it's in. In a JSON Feed, these are top-level keys.
+    RemoveTags []string
+}
+
+//  What a rerun takes out by default: anything that would send podcast apps
+// over to the live feed, or tell them the feed is finished or hidden.
+// "expired" is JSON Feed's `itunes:complete`.
+var DefaultRemovedTags = []string{"new-feed-url", "itunes:block",
+                                  "itunes:complete", "expired"}
+
+// the namespaces we know the usual prefix for
+var knownPrefixes = map[string]string{
+    "itunes": ItunesNamespace,
+    "podcast": PodcastNamespace,
+    "atom": atomNamespace,
+    "dc": dcNamespace,
+    "rerun": RerunNamespace,
+}
+
+//  Apply `rw` to the feed wrapper `wrap` (see `Feed.Wrapper()`), which can be
+// RSS, Atom, RSS 1.0 or a JSON Feed.
+func RewriteChannel(wrap []byte, rw ChannelRewrite) ([]byte, error) {
+    if looksLikeJSON(wrap) {
+        return rewriteJSONChannel(wrap, rw)
+    }
+    doc, err := gokogiri.ParseXml(wrap)
+    if err != nil {
+        return nil, err
+    }
+    channel, err := channelNode(doc)
+    if err != nil {
+        return nil, err
+    }
+    // the channel's own tags are in its namespace, whatever other tags are
+    ownXpath := func(tag string) string {
+        return ("*[local-name()='" + tag + "' and namespace-uri()='" +
+                channel.Namespace() + "']")
+    }
+
+    if len(rw.TitleSuffix) > 0 {
+        title, err := getChild(channel, ownXpath("title"))
+        if err != nil {
+            return nil, err
+        }
+        if err = title.SetContent(title.Content() + rw.TitleSuffix); err != nil {
+            return nil, err
+        }
+    }
+
+    if len(rw.SelfLink) > 0 {
+        link, err := getChild(channel, atomXpath("link") + "[@rel='self']")
+        if err != nil {
+            link = doc.CreateElementNode("link")
+            if err = channel.AddChild(link); err != nil {
+                return nil, err
+            }
+            link.SetNamespace("atom", atomNamespace)
+            link.SetAttr("rel", "self")
+        }
+        link.SetAttr("href", rw.SelfLink)
+    }
+
+    if len(rw.DescriptionNote) > 0 {
+        desc := "description"
+        if channel.Name() == "feed" {
+            desc = "subtitle"
+        }
+        if err = prependContent(channel, desc, rw.DescriptionNote); err != nil {
+            return nil, err
+        }
+        // plenty of podcast apps show this instead
+        if summary, err := getChild(channel, itunesXpath("summary")); err == nil {
+            summary.SetContent(rw.DescriptionNote + summary.Content())
+        }
+    }
+
+    for _, tag := range rw.RemoveTags {
+        search := xpath(tag)
+        if parts := strings.SplitN(tag, ":", 2); len(parts) == 2 {
+            ns := ""
+            for _, decl := range doc.Root().DeclaredNamespaces() {
+                if decl.Prefix == parts[0] {
+                    ns = decl.Uri
+                }
+            }
+            if len(ns) == 0 {
+                ns = knownPrefixes[parts[0]]
+            }
+            if len(ns) == 0 {
+                // not declared, so it can't be in the feed
+                continue
+            }
+            search = ("*[local-name()='" + parts[1] + "' and namespace-uri()='" +
+                      ns + "']")
+        }
+        found, err := channel.Search(search)
+        if err != nil {
+            return nil, err
+        }
+        for _, node := range found {
+            node.Unlink()
+        }
+    }
+    return doc.ToBuffer(nil), nil
+}
+
+// the tag that holds the feed's own title, description and so on
+func channelNode(doc xml.Document) (xml.Node, error) {
+    root := doc.Root()
+    switch root.Name() {
+    case "feed":
+        return root, nil
+    case "rss", "RDF":
+        channels, err := root.Search(xpath("channel"))
+        if err != nil {
+            return nil, err
+        }
+        if len(channels) > 0 {
+            return channels[0], nil
+        }
+    }
+    return nil, errors.New("no channel in <" + root.Name() + ">")
+}
+
+func rewriteJSONChannel(wrap []byte, rw ChannelRewrite) ([]byte, error) {
+    top := make(map[string]interface{})
+    if err := decodeJSON(wrap, &top); err != nil {
+        return nil, err
+    }
+    if len(rw.TitleSuffix) > 0 {
+        top["title"] = jsonString(top, "title") + rw.TitleSuffix
+    }
+    setIfAny(top, "feed_url", rw.SelfLink)
+    if len(rw.DescriptionNote) > 0 {
+        top["description"] = rw.DescriptionNote + jsonString(top, "description")
+    }
+    for _, key := range rw.RemoveTags {
+        delete(top, key)
+    }
+    return encodeJSON(top)
+}
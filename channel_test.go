@@ -0,0 +1,131 @@
+package rssrerun
+
+import (
+    "strings"
+    "testing"
+
+    "github.com/patrickyeon/rssrerun/testhelp"
+)
+
+var rerunRewrite = ChannelRewrite{" (Rerun)", "http://rerun.example/feed",
+                                  "Every Monday. ", DefaultRemovedTags}
+
+func TestRewriteRssChannel(t *testing.T) {
+    text := string(testhelp.CreateAndPopulateRSS(2, testhelp.StartDate()).Bytes())
+    open := "<rss version=\"2.0\"><channel>"
+    text = (text[:len(open)] +
+            "<itunes:summary" + itunesNs + ">Foo and bar.</itunes:summary>" +
+            "<itunes:block" + itunesNs + ">Yes</itunes:block>" +
+            "<itunes:complete" + itunesNs + ">Yes</itunes:complete>" +
+            "<itunes:new-feed-url" + itunesNs + ">http://elsewhere.example/" +
+            "</itunes:new-feed-url>" +
+            "<atom:link xmlns:atom=\"" + atomNamespace + "\" rel=\"self\" " +
+            "href=\"http://example.com/feed\"/>" + text[len(open):])
+    feed, err := NewFeed([]byte(text), nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    wrap, err := RewriteChannel(feed.Wrapper(), rerunRewrite)
+    if err != nil {
+        t.Fatal(err)
+    }
+    rerun, err := NewFeed(wrap, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    info := rerun.Info()
+    if info.Title != "foo (Rerun)" {
+        t.Errorf("wrong title: %s", info.Title)
+    }
+    if info.Description != "Every Monday. Foobity foo bar." {
+        t.Errorf("wrong description: %s", info.Description)
+    }
+    out := string(wrap)
+    for _, gone := range []string{"itunes:block", "itunes:complete",
+                                  "new-feed-url", "http://example.com/feed"} {
+        if strings.Contains(out, gone) {
+            t.Errorf("%s should be gone from %s", gone, out)
+        }
+    }
+    for _, kept := range []string{"Every Monday. Foo and bar.",
+                                  "href=\"http://rerun.example/feed\""} {
+        if !strings.Contains(out, kept) {
+            t.Errorf("expected %s in %s", kept, out)
+        }
+    }
+    // the items still go in
+    full := string(rerun.BytesWithItems(feed.Items(0, 2)))
+    if !strings.Contains(full, "post number 2") {
+        t.Errorf("items didn't go in the rewritten wrapper: %s", full)
+    }
+
+    // with no self link to replace, it gets one
+    plain := testhelp.CreateAndPopulateRSS(0, testhelp.StartDate()).Bytes()
+    if wrap, err = RewriteChannel(plain, rerunRewrite); err != nil {
+        t.Fatal(err)
+    }
+    if !strings.Contains(string(wrap), "href=\"http://rerun.example/feed\"") {
+        t.Errorf("no self link added: %s", wrap)
+    }
+
+    // and the zero rewrite leaves it alone
+    if wrap, err = RewriteChannel(plain, ChannelRewrite{}); err != nil {
+        t.Fatal(err)
+    }
+    rerun, err = NewFeed(wrap, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if info := rerun.Info(); info.Title != "foo" ||
+                             info.Description != "Foobity foo bar." {
+        t.Errorf("zero rewrite changed the feed: %v", info)
+    }
+}
+
+func TestRewriteAtomChannel(t *testing.T) {
+    atom := testhelp.CreateAndPopulateATOM(1, testhelp.StartDate()).Bytes()
+    wrap, err := RewriteChannel(atom, rerunRewrite)
+    if err != nil {
+        t.Fatal(err)
+    }
+    rerun, err := NewFeed(wrap, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    info := rerun.Info()
+    if !strings.HasSuffix(info.Title, " (Rerun)") {
+        t.Errorf("wrong title: %s", info.Title)
+    }
+    if info.Description != "Every Monday. " {
+        t.Errorf("wrong subtitle: %s", info.Description)
+    }
+    if !strings.Contains(string(wrap), "href=\"http://rerun.example/feed\"") {
+        t.Errorf("no self link added: %s", wrap)
+    }
+}
+
+func TestRewriteJSONChannel(t *testing.T) {
+    text := string(testhelp.CreateAndPopulateJSON(1, testhelp.StartDate()).Bytes())
+    text = strings.Replace(text, "\"title\": \"foo\",",
+                           "\"title\": \"foo\", \"expired\": true, " +
+                           "\"feed_url\": \"http://example.com/feed.json\",", 1)
+    wrap, err := RewriteChannel([]byte(text), rerunRewrite)
+    if err != nil {
+        t.Fatal(err)
+    }
+    rerun, err := NewFeed(wrap, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if info := rerun.Info(); info.Title != "foo (Rerun)" ||
+                             info.Description != "Every Monday. Foobity foo bar." {
+        t.Errorf("wrong title or description: %v", info)
+    }
+    out := string(wrap)
+    if strings.Contains(out, "expired") {
+        t.Errorf("expired should be gone: %s", out)
+    }
+    if !strings.Contains(out, "http://rerun.example/feed") {
+        t.Errorf("feed_url not replaced: %s", out)
+    }
+}
@@ -5,29 +5,31 @@ import (
     "flag"
     "fmt"
     "html/template"
+    "io"
     "math/rand"
     "net"
     "net/http"
     neturl "net/url"
     "os"
+    "regexp"
     "strconv"
     "strings"
     "time"
 
     log "github.com/sirupsen/logrus"
     "github.com/rifflock/lfshook"
-    "github.com/jbowtie/gokogiri"
 
     "github.com/patrickyeon/rssrerun"
 )
 
+var templateDir = "public/"
 var templateSources = []string{"about.html", "build.html", "preview.html"}
 var templates  = make(map[string]*template.Template)
 var weekdays = []time.Weekday{time.Sunday, time.Monday, time.Tuesday,
                               time.Wednesday, time.Thursday, time.Friday,
                               time.Saturday}
 var dayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
-var store = rssrerun.NewJSONStore("data/stores/podcasts/")
+var store rssrerun.Store = rssrerun.NewJSONStore("data/stores/podcasts/")
 
 var CautionNoFetcher = `No auto-builder known.
 The server did not auto-detect a method to build up the entire history of the
@@ -55,28 +57,36 @@ const (
     gradeAdminGood = "admin-good"
 )
 
+// how many items /api/feed serves by default, and at most
+const (
+    defaultWindow = 5
+    maxWindow = 100
+)
+
 var LogFile string
 var LogVerbose bool
 var LogQuiet bool
 var BlackholeEnabled bool
 var WatchDelay int
+var Ephemeral bool
+var Snapshot string
 
 func templateWatcher() {
     timestamps := make(map[string]time.Time)
     for _, fn := range templateSources {
-        info, _ := os.Stat("public/" + fn)
+        info, _ := os.Stat(templateDir + fn)
         timestamps[fn] = info.ModTime()
     }
 
     for true {
         time.Sleep(time.Duration(WatchDelay) * time.Second)
         for _, fn := range templateSources {
-            info, err := os.Stat("public/" + fn)
+            info, err := os.Stat(templateDir + fn)
             if err != nil {
                 continue
             }
             if info.ModTime().After(timestamps[fn]) {
-                attempt, err := template.ParseFiles("public/" + fn)
+                attempt, err := template.ParseFiles(templateDir + fn)
                 if err != nil {
                     fmt.Printf("Error parsing %s: %s\n", fn, err)
                 } else {
@@ -236,9 +246,39 @@ func previewHandler(w http.ResponseWriter, r *http.Request) httpError {
         }
     }
 
+    loc, at, err := releaseParams(req)
+    if err != nil {
+        return errHandler(w, httpErr(http.StatusBadRequest, err))
+    }
     startdate := time.Now().AddDate(0, 0, -7)
-    ds := rssrerun.NewDateSource(startdate, sched)
-    nItems := ds.DatesInRange(startdate, time.Now())
+    ds := rssrerun.NewLocalDateSource(startdate, rssrerun.Weekdays(sched),
+                                      loc, at)
+    ds.Burst, err = countParam(req, "burst", 0)
+    if err != nil {
+        return errHandler(w, httpErr(http.StatusBadRequest, err))
+    }
+    ds.ByEpisode, err = orderParam(req)
+    if err != nil {
+        return errHandler(w, httpErr(http.StatusBadRequest, err))
+    }
+    ds.Filter, err = filterParams(req)
+    if err != nil {
+        return errHandler(w, httpErr(http.StatusBadRequest, err))
+    }
+    // the same pauses and ending as the feed it links to
+    if req["pause"] != nil {
+        ds.Blackouts, err = rssrerun.ParseBlackouts(req["pause"][0], loc)
+        if err != nil {
+            return errHandler(w, httpErr(http.StatusBadRequest, err))
+        }
+    }
+    if req["end"] != nil {
+        ds.End, err = rssrerun.ParseEndPolicy(req["end"][0])
+        if err != nil {
+            return errHandler(w, httpErr(http.StatusBadRequest, err))
+        }
+    }
+    nItems := ds.DatesInRange(ds.StartDate, time.Now())
     if nItems == 0 {
         return errHandler(w, httpMsg(http.StatusBadRequest,
                                      "Need at least one day in your schedule."))
@@ -253,28 +293,27 @@ func previewHandler(w http.ResponseWriter, r *http.Request) httpError {
         return errHandler(w, httpMsg(http.StatusNotFound,
                                      "We don't have that feed yet. Try another?"))
     }
-    items, err := store.Get(url, 0, nItems)
+    // work out which items get rerun, and when
+    stored, err := store.FeedFor(url, ds)
     if err != nil {
         return errHandler(w, httpErr(http.StatusInternalServerError, err))
     }
-    // fake out the pubdates on those items
-    oldDates := make([]time.Time, len(items))
-    for i, it := range(items) {
-        nd, _ := ds.NextDate()
-        oldDates[i], _ = it.PubDate()
-        it.SetPubDate(nd)
+    items, err := stored.ShiftedAt(nItems, time.Now())
+    if err != nil {
+        return errHandler(w, httpErr(http.StatusInternalServerError, err))
     }
 
     type lnk struct {
         Title, Link, NewDate, OldDate string
     }
-    ret := make([]lnk, nItems)
+    ret := make([]lnk, len(items))
     for i, it := range items {
-        date, _ := it.PubDate()
         guid, _ := it.Guid()
-        ret[nItems - i - 1] = lnk{it.Render().Title, guid,
-                                  date.Format("Mon Jan 2 2006"),
-                                  oldDates[i].Format("Mon Jan 2 2006")}
+        newDate, _ := it.PubDate()
+        oldDate, _ := it.OriginalPubDate()
+        ret[i] = lnk{it.Render().Title, guid,
+                     newDate.Format("Mon Jan 2 2006 15:04"),
+                     oldDate.Format("Mon Jan 2 2006")}
     }
 
     type prevDat struct {
@@ -295,8 +334,19 @@ func previewHandler(w http.ResponseWriter, r *http.Request) httpError {
         warning = CautionQualityIssue
     }
     link := ("/api/feed?url=" + neturl.PathEscape(url) +
-             "&start=" + startdate.Format("20060102"))
+             "&start=" + ds.StartDate.Format("20060102"))
     link += "&sched=" + intsched
+    if req["tz"] != nil {
+        link += "&tz=" + neturl.QueryEscape(loc.String())
+    }
+    for _, param := range []string{"at", "burst", "window", "end", "pause",
+                                   "note", "order", "skip", "skiptitle",
+                                   "skipdesc", "minlen", "maxlen", "needenc",
+                                   "suffix", "strip", "format"} {
+        if req[param] != nil {
+            link += "&" + param + "=" + neturl.QueryEscape(req[param][0])
+        }
+    }
     dat := prevDat{"Your Podcast", url, strings.Join(txtsched, "/"), link,
                    warning, ret}
     return templateOrErr(w, "preview.html", dat)
@@ -320,6 +370,15 @@ func buildHandler(w http.ResponseWriter, r *http.Request) httpError {
                 target += "&" + day + "="
             }
         }
+        for _, param := range []string{"tz", "at", "burst", "window", "end",
+                                       "pause", "note", "order", "skip",
+                                       "skiptitle", "skipdesc", "minlen",
+                                       "maxlen", "needenc", "suffix", "strip",
+                                       "format"} {
+            if req[param] != nil {
+                target += "&" + param + "=" + neturl.QueryEscape(req[param][0])
+            }
+        }
 
         w.Header().Add("Location", target)
         w.WriteHeader(http.StatusFound)
@@ -403,6 +462,107 @@ func buildApiHandler(w http.ResponseWriter, r *http.Request) httpError {
     })
 }
 
+//  Reruns can come out at a time of day (`at`, as HH:MM) in a time zone (`tz`,
+// as in "America/Vancouver"). If not given, it's midnight UTC.
+func releaseParams(req neturl.Values) (*time.Location, time.Duration, error) {
+    loc := time.UTC
+    if req["tz"] != nil {
+        var err error
+        loc, err = time.LoadLocation(req["tz"][0])
+        if err != nil {
+            return nil, 0, errors.New("unknown time zone: " + req["tz"][0])
+        }
+    }
+    var at time.Duration
+    if req["at"] != nil {
+        t, err := time.Parse("15:04", req["at"][0])
+        if err != nil {
+            return nil, 0, errors.New("invalid time of day (need HH:MM): " +
+                                      req["at"][0])
+        }
+        at = time.Duration(t.Hour()) * time.Hour +
+             time.Duration(t.Minute()) * time.Minute
+    }
+    return loc, at, nil
+}
+
+//  Read a non-negative whole number out of the query parameter `name`, or `def`
+// if it's not there.
+func countParam(req neturl.Values, name string, def int) (int, error) {
+    if req[name] == nil {
+        return def, nil
+    }
+    n, err := strconv.Atoi(req[name][0])
+    if err != nil || n < 0 {
+        return 0, errors.New("invalid " + name + ": " + req[name][0])
+    }
+    return n, nil
+}
+
+//  Read how to order the rerun out of the query parameter "order": "feed" (the
+// default) or "episode" (see `DateSource.ByEpisode`).
+func orderParam(req neturl.Values) (bool, error) {
+    if req["order"] == nil {
+        return false, nil
+    }
+    switch req["order"][0] {
+    case "feed":
+        return false, nil
+    case "episode":
+        return true, nil
+    }
+    return false, errors.New("unknown order " + req["order"][0])
+}
+
+//  Read which items to leave out of the rerun: "skip" is a comma-separated list
+// of episode types, "skiptitle" and "skipdesc" are regexps, "minlen" and
+// "maxlen" are durations (eg, "5m"), and "needenc" leaves out anything without
+// an enclosure. No filter params at all means no filter.
+func filterParams(req neturl.Values) (*rssrerun.Filter, error) {
+    f := new(rssrerun.Filter)
+    set := false
+    if req["skip"] != nil {
+        set = true
+        f.SkipTypes = strings.Split(req["skip"][0], ",")
+    }
+    for _, re := range []struct {
+        name string
+        dest **regexp.Regexp
+    }{{"skiptitle", &f.SkipTitle}, {"skipdesc", &f.SkipDescription}} {
+        if req[re.name] == nil {
+            continue
+        }
+        set = true
+        var err error
+        *re.dest, err = regexp.Compile(req[re.name][0])
+        if err != nil {
+            return nil, errors.New("invalid " + re.name + ": " + err.Error())
+        }
+    }
+    for _, d := range []struct {
+        name string
+        dest *time.Duration
+    }{{"minlen", &f.MinDuration}, {"maxlen", &f.MaxDuration}} {
+        if req[d.name] == nil {
+            continue
+        }
+        set = true
+        var err error
+        *d.dest, err = time.ParseDuration(req[d.name][0])
+        if err != nil || *d.dest < 0 {
+            return nil, errors.New("invalid " + d.name + ": " + req[d.name][0])
+        }
+    }
+    if req["needenc"] != nil {
+        set = true
+        f.NeedEnclosure = true
+    }
+    if !set {
+        return nil, nil
+    }
+    return f, nil
+}
+
 func renderToMap(item rssrerun.RenderItem) map[string]string {
     return map[string]string {
         "pubdate": item.PubDate,
@@ -411,6 +571,8 @@ func renderToMap(item rssrerun.RenderItem) map[string]string {
         "guid": item.Guid,
         "url": item.Url,
         "enclosure": item.Enclosure,
+        "season": item.Season,
+        "episode": item.Episode,
     }
 }
 
@@ -426,94 +588,181 @@ func feedApiHandler(w http.ResponseWriter, r *http.Request) httpError {
                                      url + " is not in the store"))
     }
 
-    start, err := time.Parse("20060102", req["start"][0])
+    loc, at, err := releaseParams(req)
+    if err != nil {
+        return errHandler(w, httpErr(http.StatusBadRequest, err))
+    }
+
+    start, err := time.ParseInLocation("20060102", req["start"][0], loc)
     if err != nil {
         return errHandler(w, httpMsg(http.StatusBadRequest,
                                      "invalid date passed as start"))
     }
 
-    dates := strings.Split(req["sched"][0], "")
-    if len(dates) > 7 {
-        // whatever, friend
-        dates = dates[0:7]
+    sched, err := rssrerun.ParseSchedule(req["sched"][0])
+    if err != nil {
+        return errHandler(w, httpErr(http.StatusBadRequest, err))
     }
-    sched := make([]time.Weekday, len(dates))
-    for i, c := range dates {
-        if n, err := strconv.Atoi(c); err == nil {
-            sched[i] = weekdays[n]
+
+    ds := rssrerun.NewLocalDateSource(start, sched, loc, at)
+    if req["pause"] != nil {
+        ds.Blackouts, err = rssrerun.ParseBlackouts(req["pause"][0], loc)
+        if err != nil {
+            return errHandler(w, httpErr(http.StatusBadRequest, err))
         }
     }
-
-    ds := rssrerun.NewDateSource(start, sched)
-    nItems := ds.DatesInRange(start, time.Now())
-    if max := store.NumItems(url); nItems > max {
-        nItems = max
+    ds.Burst, err = countParam(req, "burst", 0)
+    if err != nil {
+        return errHandler(w, httpErr(http.StatusBadRequest, err))
     }
-
-    // get the actual items
-    var items []rssrerun.Item
-    if nItems >= 5 {
-        items, err = store.Get(url, nItems - 5, nItems)
-        ds.SkipForward(nItems - 5)
-    } else {
-        items, err = store.Get(url, 0, nItems)
+    window, err := countParam(req, "window", defaultWindow)
+    if err != nil {
+        return errHandler(w, httpErr(http.StatusBadRequest, err))
+    }
+    if window < 1 || window > maxWindow {
+        return errHandler(w, httpMsg(http.StatusBadRequest,
+                                     "window must be from 1 to " +
+                                     strconv.Itoa(maxWindow)))
+    }
+    if req["end"] != nil {
+        ds.End, err = rssrerun.ParseEndPolicy(req["end"][0])
+        if err != nil {
+            return errHandler(w, httpErr(http.StatusBadRequest, err))
+        }
     }
+    ds.ByEpisode, err = orderParam(req)
     if err != nil {
-        return errHandler(w, httpErr(http.StatusInternalServerError, err))
+        return errHandler(w, httpErr(http.StatusBadRequest, err))
+    }
+    ds.Filter, err = filterParams(req)
+    if err != nil {
+        return errHandler(w, httpErr(http.StatusBadRequest, err))
+    }
+    // say when each item was originally published in its description
+    ds.NoteOriginal = req["note"] != nil
+    // the format to send it back in, if not the one it came in
+    format := ""
+    if req["format"] != nil {
+        format = req["format"][0]
+        if format != "json" && format != "rss" && format != "atom" {
+            return errHandler(w, httpMsg(http.StatusBadRequest,
+                                         "unknown format " + format))
+        }
     }
 
-    // mangle the pubdates
-    for _, it := range(items) {
-        nd, _ := ds.NextDate()
-        it.SetPubDate(nd)
+    // get the items that are out, with their pubdates mangled
+    stored, err := store.FeedFor(url, ds)
+    if err != nil {
+        return errHandler(w, httpErr(http.StatusInternalServerError, err))
+    }
+    items, err := stored.ShiftedAt(window, time.Now())
+    if err != nil {
+        return errHandler(w, httpErr(http.StatusInternalServerError, err))
     }
 
     // build and return the feed
-    w.Header().Add("Content-Type", "text/xml")
     wrapstr, err := store.GetInfo(url, "wrapper")
     if err != nil {
         return errHandler(w, httpErr(http.StatusInternalServerError, err))
     }
-    wrap := []byte(wrapstr)
-    //  some feeds have an <itunes:new-feed-url> tag to act as a redirect. We
-    // are going to strip that out if it exists because we don't want to get
-    // overruled by a redirect.
-    doc, err := gokogiri.ParseXml(wrap)
+    wrap, err := rssrerun.RewriteChannel([]byte(wrapstr), rewriteParams(r, ds))
     if err != nil {
         return errHandler(w, httpErr(http.StatusInternalServerError, err))
     }
-    xp := doc.DocXPathCtx()
-    for _, ns := range doc.Root().DeclaredNamespaces() {
-        xp.RegisterNamespace(ns.Prefix, ns.Uri)
+    fd, err := rssrerun.NewFeed(wrap, nil)
+    if err != nil {
+        return errHandler(w, httpErr(http.StatusInternalServerError, err))
     }
-    searches := []string{}
-    for _, ns := range doc.Root().DeclaredNamespaces() {
-        if ns.Uri == "http://www.itunes.com/dtds/podcast-1.0.dtd" && len(ns.Prefix) > 0 {
-            searches = append(searches, "channel/" + ns.Prefix + ":new-feed-url")
-            searches = append(searches, "feed/" + ns.Prefix + ":new-feed-url")
+
+    var converted []byte
+    contentType := ""
+    switch format {
+    case "json":
+        contentType = "application/feed+json"
+        converted = rssrerun.ToJSONFeed(fd, items)
+    case "rss":
+        contentType = "application/rss+xml"
+        converted = rssrerun.ToRssFeed(fd, items)
+    case "atom":
+        contentType = "application/atom+xml"
+        converted = rssrerun.ToAtomFeed(fd, items)
+    }
+    switch {
+    case len(format) > 0 && converted == nil:
+        return errHandler(w, httpMsg(http.StatusInternalServerError,
+                                     "couldn't convert the feed to " + format))
+    case len(format) > 0:
+        w.Header().Add("Content-Type", contentType)
+        if _, err = w.Write(converted); err != nil {
+            return httpErr(http.StatusInternalServerError, err)
         }
-    }
-    searches = append(searches, "channel/new-feed-url")
-    searches = append(searches, "feed/new-feed-url")
-    for _, search := range searches {
-        tags, err := doc.Root().Search(search)
-        if err != nil {
-            continue
+    default:
+        if _, isJSON := fd.(*rssrerun.JSONFeed); isJSON {
+            w.Header().Add("Content-Type", "application/feed+json")
+        } else {
+            w.Header().Add("Content-Type", "text/xml")
         }
-        for _, tag := range tags {
-            tag.Unlink()
+        sent := &countingWriter{w, 0}
+        if err = fd.WriteWithItems(sent, items); err != nil {
+            if sent.n == 0 {
+                w.Header().Set("Content-Type", "text/html")
+                return errHandler(w, httpErr(http.StatusInternalServerError,
+                                             err))
+            }
+            //  Too late to send an error page once some of the feed has gone
+            // out, but it still gets logged.
+            return httpErr(http.StatusInternalServerError, err)
         }
     }
-    wrap = doc.ToBuffer(nil)
+    return nil
+}
+
+// an `io.Writer` that knows how much has been written through it
+type countingWriter struct {
+    w io.Writer
+    n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+    n, err := c.w.Write(p)
+    c.n += int64(n)
+    return n, err
+}
 
-    fd, _ := rssrerun.NewFeed(wrap, nil)
-    // flip the ordering of `items`
-    for i := 0; i < len(items) / 2; i++ {
-        j := len(items) - i - 1
-        items[i], items[j] = items[j], items[i]
+//  How to make the rerun look like a rerun in podcast apps: " (Rerun)" on the
+// title (or `suffix`, which can be blank), a note on the schedule, this
+// request as the feed's own link, and no redirects to the live feed or tags
+// that hide it. `strip` is a comma-separated list of more tags to take out.
+func rewriteParams(r *http.Request, ds *rssrerun.DateSource) rssrerun.ChannelRewrite {
+    req := r.URL.Query()
+    rw := rssrerun.ChannelRewrite{TitleSuffix: " (Rerun)"}
+    if req["suffix"] != nil {
+        rw.TitleSuffix = req["suffix"][0]
+    }
+    scheme := "http"
+    if r.TLS != nil {
+        scheme = "https"
+    }
+    rw.SelfLink = scheme + "://" + r.Host + r.URL.RequestURI()
+    rw.DescriptionNote = ("A rerun starting " +
+                          ds.StartDate.Format("January 2, 2006"))
+    switch sched := ds.Schedule.(type) {
+    case rssrerun.Weekdays:
+        days := make([]string, len(sched))
+        for i, day := range sched {
+            days[i] = day.String()
+        }
+        rw.DescriptionNote += ", every " + strings.Join(days, "/")
+    case rssrerun.DayInterval:
+        rw.DescriptionNote += ", every " + strconv.Itoa(int(sched)) + " days"
     }
-    fmt.Fprint(w, string(fd.BytesWithItems(items)))
-    return nil
+    rw.DescriptionNote += ".\n\n"
+    rw.RemoveTags = rssrerun.DefaultRemovedTags
+    if req["strip"] != nil {
+        rw.RemoveTags = append(rw.RemoveTags[:len(rw.RemoveTags):len(rw.RemoveTags)],
+                               strings.Split(req["strip"][0], ",")...)
+    }
+    return rw
 }
 
 func gradeApiHandler(w http.ResponseWriter, r *http.Request) httpError {
@@ -558,16 +807,22 @@ func errHandler(w http.ResponseWriter, err httpError) httpError {
     return err
 }
 
-func init() {
+func loadTemplates() {
     for _, fn := range templateSources {
-        templates[fn] = template.Must(template.ParseFiles("public/" + fn))
+        templates[fn] = template.Must(template.ParseFiles(templateDir + fn))
     }
+}
 
+func init() {
     flag.BoolVar(&LogVerbose, "v", false, "Report info, warn, errors")
     flag.BoolVar(&LogQuiet, "q", false, "Only report errors")
     flag.StringVar(&LogFile, "logfile", "", "File to append logs into")
     flag.BoolVar(&BlackholeEnabled, "blackhole", false, "fail2ban-like protection")
     flag.IntVar(&WatchDelay, "watch", 0, "check for template changes")
+    flag.BoolVar(&Ephemeral, "ephemeral", false,
+                 "keep feeds in memory instead of data/stores/podcasts/")
+    flag.StringVar(&Snapshot, "snapshot", "",
+                   "with -ephemeral, file to keep a snapshot of the feeds in")
 }
 
 func main() {
@@ -595,10 +850,26 @@ func main() {
         log.AddHook(lfshook.NewHook(logfd, &log.JSONFormatter{}))
     }
 
+    loadTemplates()
     if WatchDelay > 0 {
         go templateWatcher()
     }
 
+    if Ephemeral {
+        if Snapshot == "" {
+            store = rssrerun.NewMemStore()
+        } else {
+            memStore, err := rssrerun.LoadMemStore(Snapshot)
+            if err != nil {
+                log.WithFields(log.Fields{
+                    "filename": Snapshot,
+                    "err msg": err,
+                }).Fatal("Could not load snapshot!")
+            }
+            store = memStore
+        }
+    }
+
     http.HandleFunc("/", createHandler("home", homeHandler))
     http.HandleFunc("/preview", createHandler("preview", previewHandler))
     http.HandleFunc("/build", createHandler("build", buildHandler))
@@ -0,0 +1,200 @@
+package main
+
+//  Each program in cmd/ is its own `main`, so test one along with its source:
+//   go test cmd/rssrerun-demo-service.go cmd/rssrerun-demo-service_test.go
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    neturl "net/url"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/patrickyeon/rssrerun"
+    "github.com/patrickyeon/rssrerun/testhelp"
+)
+
+const testUrl = "test://podcast"
+
+//  Point the handlers at a fresh `MemStore` holding a feed of `n` items at
+// `testUrl`, the way the fetcher would have stored it.
+func memStoreWith(t *testing.T, n int) {
+    store = rssrerun.NewMemStore()
+    feed, err := rssrerun.NewFeed(testhelp.CreateAndPopulateRSS(n,
+                                      testhelp.StartDate()).Bytes(), nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err = store.CreateIndex(testUrl); err != nil {
+        t.Fatal(err)
+    }
+    its := make([]rssrerun.Item, n)
+    for i := 0; i < n; i++ {
+        its[n - i - 1] = feed.Item(i)
+    }
+    if err = store.Update(testUrl, its); err != nil {
+        t.Fatal(err)
+    }
+    if err = store.SetInfo(testUrl, "wrapper", string(feed.Wrapper())); err != nil {
+        t.Fatal(err)
+    }
+    if err = store.SetInfo(testUrl, "grade", gradeAutoTrusted); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func serve(fn handlerFuncErr, target string) *httptest.ResponseRecorder {
+    w := httptest.NewRecorder()
+    fn(w, httptest.NewRequest("GET", target, nil))
+    return w
+}
+
+func TestFeedApi(t *testing.T) {
+    memStoreWith(t, 10)
+    // started 3 weeks ago, every day, so everything but the window is out
+    start := time.Now().AddDate(0, 0, -21).Format("20060102")
+    base := "/api/feed?url=" + neturl.QueryEscape(testUrl) + "&start=" + start
+    w := serve(feedApiHandler, base + "&sched=0123456")
+    if w.Code != http.StatusOK {
+        t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+    }
+    if ctype := w.Header().Get("Content-Type"); ctype != "text/xml" {
+        t.Errorf("expected text/xml, got %s", ctype)
+    }
+    feed, err := rssrerun.NewFeed(w.Body.Bytes(), nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if n := feed.LenItems(); n != defaultWindow {
+        t.Errorf("expected %d items, got %d", defaultWindow, n)
+    }
+    if !strings.Contains(w.Body.String(), "(Rerun)") {
+        t.Error("expected the title to say it's a rerun")
+    }
+
+    w = serve(feedApiHandler, base + "&sched=0123456&window=2&format=json")
+    if w.Code != http.StatusOK {
+        t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+    }
+    if ctype := w.Header().Get("Content-Type"); ctype != "application/feed+json" {
+        t.Errorf("expected application/feed+json, got %s", ctype)
+    }
+    jfeed := map[string]interface{}{}
+    if err = json.Unmarshal(w.Body.Bytes(), &jfeed); err != nil {
+        t.Fatal(err)
+    }
+    if items, _ := jfeed["items"].([]interface{}); len(items) != 2 {
+        t.Errorf("expected 2 items, got %v", jfeed["items"])
+    }
+
+    for target, code := range map[string]int{
+        base: http.StatusBadRequest,
+        base + "&sched=0123456&format=docx": http.StatusBadRequest,
+        base + "&sched=0123456&window=0": http.StatusBadRequest,
+        base + "&sched=0123456&tz=Nowhere/Special": http.StatusBadRequest,
+        "/api/feed?url=test://other&start=" + start + "&sched=1": http.StatusNotFound,
+    } {
+        if w = serve(feedApiHandler, target); w.Code != code {
+            t.Errorf("%s: expected %d, got %d", target, code, w.Code)
+        }
+    }
+}
+
+func TestBuildHandlerRedirects(t *testing.T) {
+    memStoreWith(t, 3)
+    w := serve(buildHandler, "/build?url=" + neturl.QueryEscape(testUrl) +
+                             "&mon=&wed=&pause=20200101-20200107&burst=2")
+    if w.Code != http.StatusFound {
+        t.Fatalf("expected a redirect, got %d", w.Code)
+    }
+    loc := w.Header().Get("Location")
+    for _, want := range []string{"/preview?", "&mon=", "&wed=",
+                                  "&pause=20200101-20200107", "&burst=2"} {
+        if !strings.Contains(loc, want) {
+            t.Errorf("expected %s in %s", want, loc)
+        }
+    }
+}
+
+func TestPreviewHandler(t *testing.T) {
+    templateDir = "../public/"
+    loadTemplates()
+    memStoreWith(t, 10)
+    w := serve(previewHandler, "/preview?url=" + neturl.QueryEscape(testUrl) +
+                               "&mon=&thu=&pause=20200101-20200107")
+    if w.Code != http.StatusOK {
+        t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+    }
+    if !strings.Contains(w.Body.String(), "pause=20200101-20200107") {
+        t.Error("expected the feed link to keep the pause")
+    }
+
+    //  Every day for the week it covers, but paused for the first 4 of them, as
+    // the feed it links to would be.
+    base := "/preview?url=" + neturl.QueryEscape(testUrl) +
+            "&sun=&mon=&tue=&wed=&thu=&fri=&sat="
+    paused := (time.Now().AddDate(0, 0, -7).Format("20060102") + "-" +
+               time.Now().AddDate(0, 0, -4).Format("20060102"))
+    w = serve(previewHandler, base)
+    all := strings.Count(w.Body.String(), "<li>")
+    w = serve(previewHandler, base + "&pause=" + paused)
+    if n := strings.Count(w.Body.String(), "<li>"); n != all - 4 {
+        t.Errorf("expected %d items with the pause, got %d", all - 4, n)
+    }
+    if w = serve(previewHandler, base + "&end=never"); w.Code != http.StatusBadRequest {
+        t.Errorf("expected 400 for a bad end, got %d", w.Code)
+    }
+
+    w = serve(previewHandler, "/preview?url=test://other&mon=")
+    if w.Code != http.StatusNotFound {
+        t.Errorf("expected 404 for a feed we don't have, got %d", w.Code)
+    }
+    w = serve(previewHandler, "/preview?url=" + neturl.QueryEscape(testUrl))
+    if w.Code != http.StatusBadRequest {
+        t.Errorf("expected 400 with no days to rerun on, got %d", w.Code)
+    }
+}
+
+func TestBuildApiExisting(t *testing.T) {
+    memStoreWith(t, 3)
+    w := serve(buildApiHandler, "/api/build?url=" + neturl.QueryEscape(testUrl))
+    if w.Code != http.StatusBadRequest ||
+            !strings.Contains(w.Body.String(), "feedexists") {
+        t.Errorf("expected feedexists, got %d: %s", w.Code, w.Body.String())
+    }
+    if n := store.NumItems(testUrl); n != 3 {
+        t.Errorf("existing feed shouldn't change, got %d items", n)
+    }
+    w = serve(buildApiHandler, "/api/build")
+    if w.Code != http.StatusBadRequest ||
+            !strings.Contains(w.Body.String(), "badurl") {
+        t.Errorf("expected badurl, got %d: %s", w.Code, w.Body.String())
+    }
+}
+
+func TestGradeApi(t *testing.T) {
+    memStoreWith(t, 3)
+    store.SetInfo(testUrl, "grade", gradeAutoSuspect)
+    target := "/api/grade?url=" + neturl.QueryEscape(testUrl) + "&grade="
+    w := serve(gradeApiHandler, target + gradeUserGood)
+    if w.Code != http.StatusOK {
+        t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+    }
+    if grade, _ := store.GetInfo(testUrl, "grade"); grade != gradeUserGood {
+        t.Errorf("expected %s, got %s", gradeUserGood, grade)
+    }
+    // users can't hand out admin grades
+    if w = serve(gradeApiHandler, target + gradeAdminGood); w.Code != http.StatusBadRequest {
+        t.Errorf("expected 400, got %d", w.Code)
+    }
+    // or override them
+    store.SetInfo(testUrl, "grade", gradeAdminBad)
+    if w = serve(gradeApiHandler, target + gradeUserGood); w.Code != http.StatusUnauthorized {
+        t.Errorf("expected 401, got %d", w.Code)
+    }
+    if grade, _ := store.GetInfo(testUrl, "grade"); grade != gradeAdminBad {
+        t.Errorf("expected %s to stay, got %s", gradeAdminBad, grade)
+    }
+}
@@ -14,6 +14,7 @@ import (
 
 var OpmlFile string
 var StoreDir string
+var StoreSQLite bool
 var LogFile string
 var LogQuiet bool
 var LogVerbose bool
@@ -29,6 +30,8 @@ type Stats struct {
 func init() {
     flag.StringVar(&OpmlFile, "opml", "", "Feed list in opml format")
     flag.StringVar(&StoreDir, "store", "", "Directory of the feedstore")
+    flag.BoolVar(&StoreSQLite, "sqlite", false,
+                 "The feedstore is an SQLite database file, not a directory")
     flag.StringVar(&LogFile, "logfile", "", "File to append logs into")
     flag.BoolVar(&LogQuiet, "q", false, "Only report errors")
     flag.BoolVar(&LogVerbose, "v", false, "Report info, warn, errors")
@@ -84,9 +87,77 @@ func maybeFetchUrl(s rssrerun.Store, url string) (int, []byte, error) {
     if resp.ContentLength != 0 {
         dat, _ = ioutil.ReadAll(resp.Body)
     }
+    if resp.StatusCode == 200 {
+        // the server might be saying what encoding it's in, so this is the
+        // only place to tell
+        dat, err = rssrerun.ToUTF8(dat, resp.Header.Get("Content-Type"))
+        if err != nil {
+            return resp.StatusCode, nil, err
+        }
+    }
     return resp.StatusCode, dat, nil
 }
 
+//  Fetch `u` and add any new items in it to `store`, keeping count in
+// `stats`.
+func fetchFeed(store rssrerun.Store, u string, stats *Stats) {
+    code, data, err := maybeFetchUrl(store, u)
+    if err != nil {
+        log.WithFields(log.Fields{
+            "HTTP code": code,
+            "err msg":   err,
+            "url":       u,
+        }).Warn("Fetching error")
+        return
+    }
+    stats.HttpCodes[code] += 1
+    log.WithFields(log.Fields{
+        "HTTP code": code,
+        "data len":  len(data),
+        "url":       u,
+    }).Info("URL Fetched")
+
+    if code != 200 {
+        return
+    }
+    rss, err := rssrerun.NewFeed(data, nil)
+    if err != nil {
+        stats.NparseErrors += 1
+        log.WithFields(log.Fields{"err msg": err}).Error("RSS error")
+        return
+    }
+    nItems := rss.LenItems()
+    stats.Nitems += nItems
+    precount := store.NumItems(u)
+    if precount == 0 {
+        store.CreateIndex(u)
+    }
+    // We need to flip the ordering of the `items`, so that they are stored
+    // oldest-first.
+    its := make([]rssrerun.Item, nItems)
+    for j := 0; j < nItems; j++ {
+        its[nItems - j - 1] = rss.Item(j)
+    }
+    err = store.Update(u, its)
+    if err != nil {
+        stats.NstoreErrors += 1
+        log.WithFields(log.Fields{
+            "err msg":   err,
+            "url":       u,
+            "num items": nItems,
+        }).Error("Store update failed.")
+        return
+    }
+    store.SetInfo(u, "wrapper", string(rss.Wrapper()))
+    postcount := store.NumItems(u)
+    log.WithFields(log.Fields{
+        "url":           u,
+        "num items":     nItems,
+        "num new items": postcount - precount,
+    }).Info("Store updated")
+    stats.NnewItems += (postcount - precount)
+}
+
 func main() {
     flag.Parse()
     if OpmlFile == "" || StoreDir == "" {
@@ -116,10 +187,21 @@ func main() {
         log.AddHook(lfshook.NewHook(logfd, &log.JSONFormatter{}))
     }
 
-    if StoreDir[len(StoreDir) - 1] != os.PathSeparator {
-        StoreDir += string(os.PathSeparator)
+    var store rssrerun.Store
+    if StoreSQLite {
+        sqlStore, err := rssrerun.NewSQLiteStore(StoreDir)
+        if err != nil {
+            log.Fatal(err)
+            return
+        }
+        defer sqlStore.Close()
+        store = sqlStore
+    } else {
+        if StoreDir[len(StoreDir) - 1] != os.PathSeparator {
+            StoreDir += string(os.PathSeparator)
+        }
+        store = rssrerun.NewJSONStore(StoreDir)
     }
-    store := rssrerun.NewJSONStore(StoreDir)
     f, err := os.Open(OpmlFile)
     if err != nil {
         log.Fatal(err)
@@ -147,61 +229,7 @@ func main() {
         if len(u) == 0 {
             continue
         }
-        code, data, err := maybeFetchUrl(store, u)
-        if err != nil {
-            log.WithFields(log.Fields{
-                "HTTP code": code,
-                "err msg":   err,
-                "url":       u,
-            }).Warn("Fetching error")
-            continue
-        }
-        stats.HttpCodes[code] += 1
-        log.WithFields(log.Fields{
-            "HTTP code": code,
-            "data len":  len(data),
-            "url":       u,
-        }).Info("URL Fetched")
-
-        if code != 200 {
-            continue
-        }
-        rss, err := rssrerun.NewFeed(data, nil)
-        if err != nil {
-            stats.NparseErrors += 1
-            log.WithFields(log.Fields{"err msg": err}).Error("RSS error")
-            continue
-        }
-        nItems := rss.LenItems()
-        stats.Nitems += nItems
-        precount := store.NumItems(u)
-        if precount == 0 {
-            store.CreateIndex(u)
-        }
-        // We need to flip the ordering of the `items`, so that they are stored
-        // oldest-first.
-        its := make([]rssrerun.Item, nItems)
-        for j := 0; j < nItems; j++ {
-            its[nItems - j - 1] = rss.Item(j)
-        }
-        err = store.Update(u, its)
-        if err != nil {
-            stats.NstoreErrors += 1
-            log.WithFields(log.Fields{
-                "err msg":   err,
-                "url":       u,
-                "num items": nItems,
-            }).Error("Store update failed.")
-            continue
-        }
-        store.SetInfo(u, "wrapper", string(rss.Wrapper()))
-        postcount := store.NumItems(u)
-        log.WithFields(log.Fields{
-            "url":           u,
-            "num items":     nItems,
-            "num new items": postcount - precount,
-        }).Info("Store updated")
-        stats.NnewItems += (postcount - precount)
+        fetchFeed(store, u, &stats)
     }
     log.WithFields(log.Fields{
         "num parse errors": stats.NparseErrors,
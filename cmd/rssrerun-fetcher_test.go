@@ -0,0 +1,150 @@
+package main
+
+//  Each program in cmd/ is its own `main`, so test one along with its source:
+//   go test cmd/rssrerun-fetcher.go cmd/rssrerun-fetcher_test.go
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "testing"
+
+    "github.com/patrickyeon/rssrerun"
+    "github.com/patrickyeon/rssrerun/testhelp"
+)
+
+//  A feed that answers with `rss`, and a 304 to anyone who already has the
+// etag it hands out.
+func etagServer(rss *testhelp.RSS, etag string, hits *[]*http.Request) *httptest.Server {
+    return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+                                                    r *http.Request) {
+        *hits = append(*hits, r)
+        if r.Header.Get("If-None-Match") == etag {
+            w.WriteHeader(http.StatusNotModified)
+            return
+        }
+        w.Header().Set("ETag", "W/" + etag)
+        w.Header().Set("Last-Modified", "Tue, 04 Mar 2008 00:00:00 GMT")
+        w.Write(rss.Bytes())
+    }))
+}
+
+func TestMaybeFetchUrl(t *testing.T) {
+    rss := testhelp.CreateAndPopulateRSS(3, testhelp.StartDate())
+    hits := []*http.Request{}
+    srv := etagServer(rss, "\"v1\"", &hits)
+    defer srv.Close()
+    store := rssrerun.NewMemStore()
+    store.CreateIndex(srv.URL)
+
+    code, dat, err := maybeFetchUrl(store, srv.URL)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if code != 200 || string(dat) != string(rss.Bytes()) {
+        t.Fatalf("expected the feed, got %d: %s", code, string(dat))
+    }
+    if hits[0].Header.Get("If-None-Match") != "" ||
+            hits[0].Header.Get("If-Modified-Since") != "" {
+        t.Error("first fetch shouldn't be conditional")
+    }
+    // a weak etag is good enough
+    if etag, _ := store.GetInfo(srv.URL, "etag"); etag != "\"v1\"" {
+        t.Errorf("expected etag \"v1\", got %s", etag)
+    }
+    lastMod, _ := store.GetInfo(srv.URL, "last-modified")
+    if lastMod != "Tue, 04 Mar 2008 00:00:00 GMT" {
+        t.Errorf("wrong last-modified stored: %s", lastMod)
+    }
+
+    code, dat, err = maybeFetchUrl(store, srv.URL)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if code != 304 || len(dat) != 0 {
+        t.Errorf("expected an empty 304, got %d: %s", code, string(dat))
+    }
+    // and a 304 doesn't wipe out what we had
+    if etag, _ := store.GetInfo(srv.URL, "etag"); etag != "\"v1\"" {
+        t.Errorf("expected etag \"v1\" to be kept, got %s", etag)
+    }
+
+    // without an etag, it falls back on last-modified
+    store.SetInfo(srv.URL, "etag", "")
+    if _, _, err = maybeFetchUrl(store, srv.URL); err != nil {
+        t.Fatal(err)
+    }
+    if got := hits[2].Header.Get("If-Modified-Since"); got != lastMod {
+        t.Errorf("expected If-Modified-Since %s, got %s", lastMod, got)
+    }
+}
+
+func TestFetchFeed(t *testing.T) {
+    rss := testhelp.CreateAndPopulateRSS(4, testhelp.StartDate())
+    hits := []*http.Request{}
+    srv := etagServer(rss, "\"v1\"", &hits)
+    defer srv.Close()
+    store := rssrerun.NewMemStore()
+    stats := Stats{make(map[int]int), 0, 0, 0, 0}
+
+    fetchFeed(store, srv.URL, &stats)
+    if n := store.NumItems(srv.URL); n != 4 {
+        t.Fatalf("expected 4 items stored, got %d", n)
+    }
+    if stats.NnewItems != 4 || stats.Nitems != 4 || stats.HttpCodes[200] != 1 {
+        t.Errorf("wrong stats after first fetch: %+v", stats)
+    }
+    // stored oldest first
+    feed, err := rssrerun.NewFeed(rss.Bytes(), nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    its, err := store.Get(srv.URL, 0, 1)
+    if err != nil {
+        t.Fatal(err)
+    }
+    want, _ := feed.Item(3).Guid()
+    if got, _ := its[0].Guid(); got != want {
+        t.Errorf("expected %s stored first, got %s", want, got)
+    }
+    if wrap, _ := store.GetInfo(srv.URL, "wrapper"); wrap == "" {
+        t.Error("no wrapper stored")
+    }
+
+    // a new item shows up
+    rss.AddPost("<item><title>new</title><guid>new</guid><pubDate>" +
+                "Tue, 04 Mar 2008 00:00:00 GMT</pubDate></item>")
+    fetchFeed(store, srv.URL, &stats)
+    if n := store.NumItems(srv.URL); n != 5 {
+        t.Fatalf("expected 5 items stored, got %d", n)
+    }
+    if stats.NnewItems != 5 || stats.HttpCodes[200] != 2 {
+        t.Errorf("wrong stats after second fetch: %+v", stats)
+    }
+
+    // and then nothing's changed
+    fetchFeed(store, srv.URL, &stats)
+    if n := store.NumItems(srv.URL); n != 5 {
+        t.Errorf("expected still 5 items stored, got %d", n)
+    }
+    if stats.HttpCodes[304] != 1 || stats.NnewItems != 5 {
+        t.Errorf("wrong stats after third fetch: %+v", stats)
+    }
+}
+
+func TestFetchFeedParseError(t *testing.T) {
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+                                                    r *http.Request) {
+        w.Write([]byte("<html><body>not a feed</body></html>"))
+    }))
+    defer srv.Close()
+    store := rssrerun.NewMemStore()
+    stats := Stats{make(map[int]int), 0, 0, 0, 0}
+
+    fetchFeed(store, srv.URL, &stats)
+    if stats.NparseErrors != 1 {
+        t.Errorf("expected a parse error, got %+v", stats)
+    }
+    if store.Contains(srv.URL) {
+        t.Error("nothing should be stored for a broken feed")
+    }
+}
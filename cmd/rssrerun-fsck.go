@@ -0,0 +1,56 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "os"
+
+    "github.com/patrickyeon/rssrerun"
+)
+
+var StoreDir string
+var Repair bool
+var Quiet bool
+
+func init() {
+    flag.StringVar(&StoreDir, "store", "", "directory of the feedstore")
+    flag.BoolVar(&Repair, "repair", false,
+                 "fix what can be fixed, cutting off anything never committed")
+    flag.BoolVar(&Quiet, "q", false, "Only report problems that weren't fixed")
+}
+
+//  Check that everything in a feedstore agrees with itself, and optionally fix
+// what can be. Exits with 1 if there's anything left wrong.
+func main() {
+    flag.Parse()
+    if StoreDir == "" {
+        flag.PrintDefaults()
+        os.Exit(2)
+    }
+    if StoreDir[len(StoreDir) - 1] != os.PathSeparator {
+        StoreDir += string(os.PathSeparator)
+    }
+
+    // nothing here needs a url canonicalized, so don't go fetching any
+    store := rssrerun.NewJSONStoreWith(StoreDir, rssrerun.JSONStoreOptions{
+        Canon: rssrerun.CanonIdentity,
+    })
+    problems, err := store.Check(Repair)
+    if err != nil {
+        fmt.Fprintln(os.Stderr, err)
+        os.Exit(2)
+    }
+
+    failed := false
+    for _, p := range problems {
+        if !p.Fixed {
+            failed = true
+        } else if Quiet {
+            continue
+        }
+        fmt.Println(p)
+    }
+    if failed {
+        os.Exit(1)
+    }
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "io/ioutil"
+    "net/http"
+    "os"
+
+    "github.com/patrickyeon/rssrerun"
+)
+
+var Url string
+var FeedFile string
+var StoreDir string
+var Quiet bool
+
+func init() {
+    flag.StringVar(&Url, "url", "", "url of the feed to check")
+    flag.StringVar(&FeedFile, "file", "", "file with the feed to check")
+    flag.StringVar(&StoreDir, "store", "",
+                   "check the feedstore's copy of -url instead of fetching it")
+    flag.BoolVar(&Quiet, "q", false, "Only report errors")
+}
+
+func readFeed() ([]byte, error) {
+    if FeedFile != "" {
+        return ioutil.ReadFile(FeedFile)
+    }
+    resp, err := http.Get(Url)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return nil, fmt.Errorf("fetching %s: %s", Url, resp.Status)
+    }
+    text, err := ioutil.ReadAll(resp.Body)
+    if err != nil {
+        return nil, err
+    }
+    return rssrerun.ToUTF8(text, resp.Header.Get("Content-Type"))
+}
+
+//  Check a feed for anything that would stop it making a good rerun, and say
+// what it is. Exits with 1 if there are any errors (not just warnings).
+func main() {
+    flag.Parse()
+    if (Url == "") == (FeedFile == "") || (StoreDir != "" && Url == "") {
+        flag.PrintDefaults()
+        os.Exit(2)
+    }
+
+    var problems []rssrerun.LintProblem
+    if StoreDir != "" {
+        if StoreDir[len(StoreDir) - 1] != os.PathSeparator {
+            StoreDir += string(os.PathSeparator)
+        }
+        store := rssrerun.NewJSONStore(StoreDir)
+        if !store.Contains(Url) {
+            fmt.Fprintln(os.Stderr, Url, "isn't in the store")
+            os.Exit(2)
+        }
+        feed, err := store.FeedFor(Url, nil)
+        if err != nil {
+            fmt.Fprintln(os.Stderr, err)
+            os.Exit(2)
+        }
+        problems = rssrerun.Lint(feed)
+    } else {
+        text, err := readFeed()
+        if err != nil {
+            fmt.Fprintln(os.Stderr, err)
+            os.Exit(2)
+        }
+        problems = rssrerun.LintBytes(text)
+    }
+
+    failed := false
+    for _, p := range problems {
+        if p.Level == rssrerun.LintError {
+            failed = true
+        } else if Quiet {
+            continue
+        }
+        fmt.Println(p)
+    }
+    if failed {
+        os.Exit(1)
+    }
+}
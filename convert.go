@@ -0,0 +1,229 @@
+package rssrerun
+
+import (
+    "errors"
+    "html"
+    "strings"
+    "time"
+)
+
+//  Converting between kinds of feed, for clients that only understand one of
+// them. Only what we know how to map comes across: the title, link and
+// description of the feed, and the title, link, guid/id, dates,
+// description/content, author, enclosure and season/episode of each item (plus
+// our own <rerun:originalPubDate>). Everything else, like iTunes artwork, is
+// left behind.
+
+const atomNamespace = "http://www.w3.org/2005/Atom"
+
+//  Make an empty RSS 2.0 feed out of `info`, that items from any other kind of
+// feed can be put in (see `ToRssItem()`).
+func NewRssFeedFrom(info FeedInfo) (Feed, error) {
+    text := "<rss version=\"2.0\"><channel>"
+    text += "<title>" + esc(info.Title) + "</title>"
+    text += "<link>" + esc(info.Link) + "</link>"
+    text += "<description>" + esc(info.Description) + "</description>"
+    text += "</channel></rss>"
+    return NewFeed([]byte(text), nil)
+}
+
+//  Make an empty Atom feed out of `info`, that items from any other kind of
+// feed can be put in (see `ToAtomItem()`). Atom needs an `<updated>`, which
+// has to be made up as now.
+func NewAtomFeedFrom(info FeedInfo) (Feed, error) {
+    text := "<feed xmlns=\"" + atomNamespace + "\">"
+    text += "<title>" + esc(info.Title) + "</title>"
+    if len(info.Link) > 0 {
+        text += "<link rel=\"alternate\" href=\"" + esc(info.Link) + "\"/>"
+        text += "<id>" + esc(info.Link) + "</id>"
+    }
+    if len(info.Description) > 0 {
+        text += "<subtitle>" + esc(info.Description) + "</subtitle>"
+    }
+    text += "<updated>" + time.Now().UTC().Format(time.RFC3339) + "</updated>"
+    text += "</feed>"
+    return NewFeed([]byte(text), nil)
+}
+
+//  Render `items` as an RSS 2.0 feed, keeping what we can of `f`. Items that
+// can't be converted (eg, no guid) are left out. Returns nil if it can't be
+// done at all.
+func ToRssFeed(f Feed, items []Item) []byte {
+    var rss Feed = f
+    if _, ok := f.(*RssFeed); !ok {
+        var err error
+        if rss, err = NewRssFeedFrom(f.Info()); err != nil {
+            return nil
+        }
+    }
+    its := []Item{}
+    for _, item := range items {
+        if it, err := ToRssItem(item); err == nil {
+            its = append(its, it)
+        }
+    }
+    return rss.BytesWithItems(its)
+}
+
+//  Render `items` as an Atom feed, keeping what we can of `f`. Items that
+// can't be converted (eg, no guid) are left out. Returns nil if it can't be
+// done at all.
+func ToAtomFeed(f Feed, items []Item) []byte {
+    var atom Feed = f
+    if _, ok := f.(*AtomFeed); !ok {
+        var err error
+        if atom, err = NewAtomFeedFrom(f.Info()); err != nil {
+            return nil
+        }
+    }
+    its := []Item{}
+    for _, item := range items {
+        if it, err := ToAtomItem(item); err == nil {
+            its = append(its, it)
+        }
+    }
+    return atom.BytesWithItems(its)
+}
+
+// `item` as an RSS 2.0 `<item>` (which is just `item`, if it already is one)
+func ToRssItem(item Item) (Item, error) {
+    if _, ok := item.(*RssItem); ok {
+        return item, nil
+    }
+    guid, err := item.Guid()
+    if err != nil {
+        return nil, err
+    }
+    r := item.Render()
+    text := "<item>"
+    text += "<title>" + esc(r.Title) + "</title>"
+    if len(r.Url) > 0 {
+        text += "<link>" + esc(r.Url) + "</link>"
+    }
+    text += "<description>" + esc(r.Description) + "</description>"
+    if strings.Contains(r.Author, "@") {
+        text += "<author>" + esc(r.Author) + "</author>"
+    } else if len(r.Author) > 0 {
+        text += "<dc:creator xmlns:dc=\"" + dcNamespace + "\">"
+        text += esc(r.Author) + "</dc:creator>"
+    }
+    if guid == r.Url {
+        text += "<guid>" + esc(guid) + "</guid>"
+    } else {
+        text += "<guid isPermaLink=\"false\">" + esc(guid) + "</guid>"
+    }
+    if pd, err := item.PubDate(); err == nil {
+        text += "<pubDate>" + pd.Format(time.RFC1123Z) + "</pubDate>"
+    }
+    if len(r.Enclosure) > 0 {
+        // RSS wants all three, even if we have to make them up
+        length, typ := r.EnclosureLength, r.EnclosureType
+        if len(length) == 0 {
+            length = "0"
+        }
+        if len(typ) == 0 {
+            typ = "application/octet-stream"
+        }
+        text += "<enclosure url=\"" + esc(r.Enclosure) + "\" length=\"" +
+                esc(length) + "\" type=\"" + esc(typ) + "\"/>"
+    }
+    text += itunesTags(r)
+    text += "</item>"
+    return convertedItem(item, text)
+}
+
+// `item` as an Atom `<entry>` (which is just `item`, if it already is one)
+func ToAtomItem(item Item) (Item, error) {
+    if _, ok := item.(*AtomItem); ok {
+        return item, nil
+    }
+    guid, err := item.Guid()
+    if err != nil {
+        return nil, err
+    }
+    r := item.Render()
+    text := "<entry xmlns=\"" + atomNamespace + "\">"
+    text += "<id>" + esc(guid) + "</id>"
+    text += "<title>" + esc(r.Title) + "</title>"
+    if len(r.Url) > 0 {
+        text += "<link rel=\"alternate\" href=\"" + esc(r.Url) + "\"/>"
+    }
+    if pd, err := item.PubDate(); err == nil {
+        date := esc(pd.Format(time.RFC3339))
+        text += "<published>" + date + "</published>"
+        text += "<updated>" + date + "</updated>"
+    }
+    if len(r.Author) > 0 {
+        text += "<author><name>" + esc(r.Author) + "</name></author>"
+    }
+    // RSS descriptions are (almost) always HTML
+    text += "<content type=\"html\">" + esc(r.Description) + "</content>"
+    if len(r.Enclosure) > 0 {
+        text += "<link rel=\"enclosure\" href=\"" + esc(r.Enclosure) + "\""
+        if len(r.EnclosureType) > 0 {
+            text += " type=\"" + esc(r.EnclosureType) + "\""
+        }
+        if len(r.EnclosureLength) > 0 {
+            text += " length=\"" + esc(r.EnclosureLength) + "\""
+        }
+        text += "/>"
+    }
+    text += itunesTags(r)
+    text += "</entry>"
+    return convertedItem(item, text)
+}
+
+//  The iTunes season and episode tags for `r`, if it has them. Podcast apps
+// want these whatever the feed is. iTunes episodes are whole numbers, so a
+// fractional one has to be a Podcasting 2.0 `podcast:episode` instead.
+func itunesTags(r RenderItem) string {
+    episodeNs, episodePrefix := ItunesNamespace, "itunes"
+    if strings.Contains(r.Episode, ".") {
+        episodeNs, episodePrefix = PodcastNamespace, "podcast"
+    }
+    text := ""
+    for _, tag := range [][4]string{
+            {"itunes", ItunesNamespace, "season", r.Season},
+            {episodePrefix, episodeNs, "episode", r.Episode},
+            {"itunes", ItunesNamespace, "episodeType", r.EpisodeType}} {
+        if len(tag[3]) > 0 {
+            name := tag[0] + ":" + tag[2]
+            text += "<" + name + " xmlns:" + tag[0] + "=\"" + tag[1] + "\">" +
+                    esc(tag[3]) + "</" + name + ">"
+        }
+    }
+    return text
+}
+
+//  Parse the converted `text` of `orig`, and bring across the original pubdate
+// if it has one.
+func convertedItem(orig Item, text string) (Item, error) {
+    ret, err := MkItem([]byte(text))
+    if err != nil {
+        return nil, err
+    }
+    if ret == nil {
+        return nil, errors.New("couldn't convert item")
+    }
+    if od, err := orig.OriginalPubDate(); err == nil {
+        if err = ret.SetOriginalPubDate(od); err != nil {
+            return nil, err
+        }
+    }
+    return ret, nil
+}
+
+//  Escaped for use in XML text or attributes. Control characters can't be in
+// XML at all, even escaped, so they're left out.
+func esc(s string) string {
+    return html.EscapeString(strings.Map(func(r rune) rune {
+        switch {
+        case r == '\t' || r == '\n' || r == '\r':
+            return r
+        case r < 0x20 || (r >= 0xd800 && r < 0xe000) || r == 0xfffe ||
+                r == 0xffff:
+            return -1
+        }
+        return r
+    }, s))
+}
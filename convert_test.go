@@ -0,0 +1,185 @@
+package rssrerun
+
+import (
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/patrickyeon/rssrerun/testhelp"
+)
+
+//  Convert every item in `tf` with `to`, and check that the result is a `want`
+// feed with the same guids, dates, and what else we map across.
+func testConvert(t *testing.T, tf testhelp.TestFeed,
+                 to func(Feed, []Item) []byte, want string) {
+    src, err := NewFeed(tf.Bytes(), nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    converted, err := NewFeed(to(src, src.Items(0, src.LenItems())), nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    switch converted.(type) {
+    case *RssFeed:
+        if want != "rss" {
+            t.Fatalf("converted to RSS, not %s", want)
+        }
+    case *AtomFeed:
+        if want != "atom" {
+            t.Fatalf("converted to Atom, not %s", want)
+        }
+    default:
+        t.Fatalf("didn't convert to %s", want)
+    }
+    if info := converted.Info(); info != src.Info() {
+        t.Errorf("feed info should be %v, got %v", src.Info(), info)
+    }
+    if converted.LenItems() != src.LenItems() {
+        t.Fatalf("expected %d items, got %d", src.LenItems(),
+                 converted.LenItems())
+    }
+    for i := 0; i < src.LenItems(); i++ {
+        wantItem, gotItem := src.Item(i), converted.Item(i)
+        wantGuid, _ := wantItem.Guid()
+        if gotGuid, _ := gotItem.Guid(); wantGuid != gotGuid {
+            t.Errorf("item %d should have guid %s, got %s", i, wantGuid, gotGuid)
+        }
+        wantDate, _ := wantItem.PubDate()
+        if gotDate, err := gotItem.PubDate(); err != nil || !wantDate.Equal(gotDate) {
+            t.Errorf("item %d should be dated %v, got %v (%v)", i, wantDate,
+                     gotDate, err)
+        }
+        w, g := wantItem.Render(), gotItem.Render()
+        if w.Title != g.Title || w.Url != g.Url || w.Description != g.Description ||
+           w.PubDate != g.PubDate {
+            t.Errorf("item %d should render as %v, got %v", i, w, g)
+        }
+    }
+}
+
+func TestRssToAtom(t *testing.T) {
+    testConvert(t, testhelp.CreateAndPopulateRSS(5, testhelp.StartDate()),
+                ToAtomFeed, "atom")
+}
+
+func TestAtomToRss(t *testing.T) {
+    atom := testhelp.CreateAndPopulateATOM(0, testhelp.StartDate())
+    atom.AddPost("<title>with a link</title><id>1</id>" +
+                 "<published>2015-04-12T01:00:00Z</published>" +
+                 "<link href=\"http://example.com/1\"/>" +
+                 "<summary>&lt;p&gt;hi &amp; bye&lt;/p&gt;</summary>")
+    testConvert(t, atom, ToRssFeed, "rss")
+}
+
+func TestRdfToRss(t *testing.T) {
+    testConvert(t, testhelp.CreateAndPopulateRDF(5, testhelp.StartDate()),
+                ToRssFeed, "rss")
+}
+
+func TestJSONToAtom(t *testing.T) {
+    testConvert(t, testhelp.CreateAndPopulateJSON(5, testhelp.StartDate()),
+                ToAtomFeed, "atom")
+}
+
+func TestConvertExtras(t *testing.T) {
+    rss := testhelp.CreateAndPopulateRSS(0, testhelp.StartDate())
+    rss.AddPost("<item><title>extras</title><guid>1</guid>" +
+                "<author>pat@example.com (Pat)</author>" +
+                "<pubDate>Sun, 12 Apr 2015 01:00:00 +0000</pubDate>" +
+                "<enclosure url=\"foo://bar.mp3\" length=\"1234\" " +
+                "type=\"audio/mpeg\"/></item>")
+    src, err := NewFeed(rss.Bytes(), nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err = src.Item(0).SetOriginalPubDate(testhelp.StartDate()); err != nil {
+        t.Fatal(err)
+    }
+    atom, err := ToAtomItem(src.Item(0))
+    if err != nil {
+        t.Fatal(err)
+    }
+    back, err := ToRssItem(atom)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, it := range []Item{atom, back} {
+        r := it.Render()
+        if r.Author != "pat@example.com (Pat)" {
+            t.Errorf("author didn't convert: %v", r)
+        }
+        if r.Enclosure != "foo://bar.mp3" || r.EnclosureType != "audio/mpeg" ||
+                r.EnclosureLength != "1234" {
+            t.Errorf("enclosure didn't convert: %v", r)
+        }
+        orig, err := it.OriginalPubDate()
+        if err != nil || !orig.Equal(testhelp.StartDate()) {
+            t.Errorf("original pubdate should be %v, got %v (%v)",
+                     testhelp.StartDate(), orig, err)
+        }
+    }
+}
+
+func TestConvertEmpty(t *testing.T) {
+    rerun := NewDateSource(testhelp.StartDate().AddDate(1, 0, 0),
+                           []time.Weekday{time.Monday})
+    src, err := NewFeed(testhelp.CreateAndPopulateRSS(5,
+                                                    testhelp.StartDate()).Bytes(),
+                        rerun)
+    if err != nil {
+        t.Fatal(err)
+    }
+    // nothing is out before the rerun starts
+    items, err := src.ShiftedAt(5, rerun.StartDate.AddDate(0, 0, -1))
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, out := range [][]byte{src.BytesWithItems(items),
+                                 ToAtomFeed(src, items)} {
+        feed, err := NewFeed(out, nil)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if feed.LenItems() != 0 {
+            t.Errorf("expected no items, got %d", feed.LenItems())
+        }
+    }
+    if out := string(ToAtomFeed(src, items)); !strings.Contains(out, "<title>foo</title>") {
+        t.Errorf("expected the feed's title in %s", out)
+    }
+}
+
+//  A JSON Feed can have characters in it that XML can't, escaped or not. They
+// get left out of the converted feed rather than breaking it.
+func TestConvertControlChars(t *testing.T) {
+    text := strings.Replace(string(testhelp.CreateAndPopulateJSON(2,
+                                       testhelp.StartDate()).Bytes()),
+                            "\"title\": \"foo\"", "\"title\": \"fo\\u0001o\"", 1)
+    text = strings.Replace(text, "post number 2", "post\\u0008 number 2", 1)
+    src, err := NewFeed([]byte(text), nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for name, to := range map[string]func(Feed, []Item) []byte{
+            "rss": ToRssFeed, "atom": ToAtomFeed} {
+        out := to(src, src.Items(0, src.LenItems()))
+        if out == nil {
+            t.Errorf("%s: couldn't convert", name)
+            continue
+        }
+        feed, err := NewFeed(out, nil)
+        if err != nil {
+            t.Errorf("%s: %v", name, err)
+            continue
+        }
+        if title := feed.Info().Title; title != "foo" {
+            t.Errorf("%s: expected title foo, got %q", name, title)
+        }
+        if feed.LenItems() != 2 {
+            t.Errorf("%s: expected 2 items, got %d", name, feed.LenItems())
+        } else if title := feed.Item(0).Render().Title; title != "post number 2" {
+            t.Errorf("%s: expected title \"post number 2\", got %q", name, title)
+        }
+    }
+}
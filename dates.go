@@ -0,0 +1,135 @@
+package rssrerun
+
+import (
+    "errors"
+    "strings"
+    "time"
+)
+
+//  Feeds in the wild date things in just about every way imaginable. Atom and
+// `dc:date` are supposed to be RFC3339 (or W3C-DTF, which is a bit looser), RSS
+// is supposed to be RFC822, and then there are two-digit years, missing
+// seconds, misspelled weekdays, and zones by name.
+
+// ISO 8601 flavours, which all start with the year
+var isoDateTypes = []string{
+    time.RFC3339,
+    "2006-01-02T15:04:05Z0700",
+    "2006-01-02T15:04Z07:00",
+    "2006-01-02T15:04Z0700",
+    "2006-01-02T15:04:05",
+    "2006-01-02T15:04",
+    "2006-01-02 15:04:05Z07:00",
+    "2006-01-02 15:04:05Z0700",
+    "2006-01-02 15:04:05",
+    "20060102T150405Z0700",
+    "2006-01-02",
+    "20060102",
+    "2006-01",
+    "2006",
+}
+
+//  RFC822 and its many deviations, once the weekday is taken out and any named
+// zone is made into an offset (see `cleanRfc822()`).
+var rfc822DateTypes = rfc822Layouts()
+
+func rfc822Layouts() []string {
+    ret := []string{}
+    for _, day := range []string{"2 Jan 2006", "2 Jan 06", "2 January 2006",
+                                 "Jan 2 2006"} {
+        for _, clock := range []string{"15:04:05", "15:04"} {
+            for _, zone := range []string{" -0700", " -07:00", " MST", ""} {
+                ret = append(ret, day + " " + clock + zone)
+            }
+        }
+    }
+    return ret
+}
+
+//  Zones that feeds name instead of giving an offset. RFC822 only has the US
+// ones, but the rest turn up anyway. Ambiguous ones (like IST) are left out,
+// and parse as UTC.
+var namedZones = map[string]string{
+    "UT": "+0000", "UTC": "+0000", "GMT": "+0000", "Z": "+0000",
+    "EST": "-0500", "EDT": "-0400", "CST": "-0600", "CDT": "-0500",
+    "MST": "-0700", "MDT": "-0600", "PST": "-0800", "PDT": "-0700",
+    "AKST": "-0900", "AKDT": "-0800", "HST": "-1000",
+    "AST": "-0400", "ADT": "-0300", "NST": "-0330", "NDT": "-0230",
+    "WET": "+0000", "WEST": "+0100", "BST": "+0100",
+    "CET": "+0100", "CEST": "+0200", "EET": "+0200", "EEST": "+0300",
+    "MSK": "+0300", "JST": "+0900", "KST": "+0900",
+    "AWST": "+0800", "ACST": "+0930", "AEST": "+1000", "AEDT": "+1100",
+    "NZST": "+1200", "NZDT": "+1300",
+}
+
+//  Parse a date from any of the formats above. Dates without a zone are taken
+// to be UTC, and so are dates with no offset however they say it ("GMT",
+// "+00:00", ...).
+func parseDate(s string) (time.Time, error) {
+    date, err := parseAnyDate(s)
+    if _, offset := date.Zone(); err == nil && offset == 0 {
+        date = date.UTC()
+    }
+    return date, err
+}
+
+func parseAnyDate(s string) (time.Time, error) {
+    s = strings.Join(strings.Fields(s), " ")
+    if len(s) == 0 {
+        return zeroDate(), errors.New("empty date")
+    }
+    if s[0] >= '0' && s[0] <= '9' {
+        iso := strings.ToUpper(s)
+        for _, typ := range isoDateTypes {
+            if date, err := time.Parse(typ, iso); err == nil {
+                return date, nil
+            }
+        }
+    }
+    clean := cleanRfc822(s)
+    for _, typ := range rfc822DateTypes {
+        if date, err := time.Parse(typ, clean); err == nil {
+            return date, nil
+        }
+    }
+    return zeroDate(), errors.New("invalid date format \"" + s + "\"")
+}
+
+//  Take the weekday off the front of an RFC822-ish date (it's redundant, and
+// often misspelled), drop the comma some put after the day of the month, and
+// swap a named zone for its offset.
+func cleanRfc822(s string) string {
+    fields := strings.Fields(strings.Replace(s, ",", " ", -1))
+    if len(fields) > 1 && isLetters(fields[0]) && !isMonth(fields[0]) {
+        fields = fields[1:]
+    }
+    if n := len(fields); n > 0 && isLetters(fields[n - 1]) {
+        if offset, ok := namedZones[strings.ToUpper(fields[n - 1])]; ok {
+            fields[n - 1] = offset
+        }
+    }
+    return strings.Join(fields, " ")
+}
+
+func isLetters(s string) bool {
+    s = strings.TrimSuffix(s, ".")
+    for _, c := range s {
+        if (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') {
+            return false
+        }
+    }
+    return len(s) > 0
+}
+
+func isMonth(s string) bool {
+    if len(s) < 3 {
+        return false
+    }
+    prefix := strings.ToLower(s[:3])
+    for m := time.January; m <= time.December; m++ {
+        if strings.ToLower(m.String()[:3]) == prefix {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,129 @@
+package rssrerun
+
+import (
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/patrickyeon/rssrerun/testhelp"
+)
+
+func TestParseDate(t *testing.T) {
+    utc := time.Date(2015, 4, 12, 1, 0, 0, 0, time.UTC)
+    pst := time.FixedZone("", -8 * 60 * 60)
+    dates := map[string]time.Time{
+        // by the book
+        "2015-04-12T01:00:00Z": utc,
+        "2015-04-12T01:00:00+00:00": utc,
+        "2015-04-11T17:00:00-08:00": utc,
+        "Sun, 12 Apr 2015 01:00:00 +0000": utc,
+        "Sun, 12 Apr 2015 01:00:00 GMT": utc,
+        "12 Apr 15 01:00 UTC": utc,
+        // ISO 8601 that isn't quite RFC3339
+        "2015-04-12T01:00:00.000Z": utc,
+        "2015-04-12t01:00:00z": utc,
+        "2015-04-12T01:00Z": utc,
+        "2015-04-12T01:00:00+0000": utc,
+        "2015-04-12T01:00:00": utc,
+        "2015-04-12 01:00:00": utc,
+        "20150412T010000Z": utc,
+        "2015-04-12": time.Date(2015, 4, 12, 0, 0, 0, 0, time.UTC),
+        "2015-04": time.Date(2015, 4, 1, 0, 0, 0, 0, time.UTC),
+        // RFC822, as it's really written
+        "Sun, 12 Apr 2015 01:00 GMT": utc,
+        "Sun, 12 Apr 15 01:00:00 +0000": utc,
+        "Sunday, 12 Apr 2015 01:00:00 GMT": utc,
+        "Thurs, 12 Apr 2015 01:00:00 GMT": utc,
+        "12 Apr 2015 01:00:00 +00:00": utc,
+        "Sun, 12 April 2015 01:00:00 GMT": utc,
+        "Sun,  12 Apr 2015   01:00:00 GMT\n": utc,
+        "Apr 12, 2015 01:00:00 GMT": utc,
+        "Sun, 12 Apr 2015 01:00:00": utc,
+        "Sat, 11 Apr 2015 17:00:00 PST": utc.In(pst),
+        "Sat, 11 Apr 2015 18:00:00 pdt": utc,
+        "Sun, 12 Apr 2015 03:00:00 CEST": utc,
+        "Sun, 12 Apr 2015 11:00:00 AEST": utc,
+    }
+    for s, want := range dates {
+        got, err := parseDate(s)
+        if err != nil {
+            t.Errorf("couldn't parse \"%s\": %v", s, err)
+        } else if !got.Equal(want) {
+            t.Errorf("\"%s\" should be %v, got %v", s, want, got)
+        }
+    }
+    for _, s := range []string{"", "yesterday", "Sun, 32 Apr 2015 01:00:00 GMT",
+                               "2015-13-01"} {
+        if got, err := parseDate(s); err == nil {
+            t.Errorf("\"%s\" shouldn't parse, got %v", s, got)
+        }
+    }
+}
+
+func TestParseDateUTC(t *testing.T) {
+    // no offset comes out as UTC, not some zone that happens to be at +0
+    for _, s := range []string{"Sun, 12 Apr 2015 01:00:00 GMT",
+                               "2015-04-12T01:00:00+00:00",
+                               "12 Apr 2015 01:00:00 +0000"} {
+        if got, _ := parseDate(s); got.Location() != time.UTC {
+            t.Errorf("\"%s\" should be UTC, got %v", s, got.Location())
+        }
+    }
+}
+
+func TestDateFallbacks(t *testing.T) {
+    rss := testhelp.CreateAndPopulateRSS(0, testhelp.StartDate())
+    rss.AddPost("<item><guid>dc</guid><dc:date " +
+                "xmlns:dc=\"http://purl.org/dc/elements/1.1/\">" +
+                "2015-04-12T01:00:00Z</dc:date></item>")
+    rss.AddPost("<item><guid>atom</guid><atom:updated " +
+                "xmlns:atom=\"http://www.w3.org/2005/Atom\">" +
+                "2015-04-12T01:00:00Z</atom:updated></item>")
+    rss.AddPost("<item><guid>bad</guid><pubDate>last week</pubDate><dc:date " +
+                "xmlns:dc=\"http://purl.org/dc/elements/1.1/\">" +
+                "2015-04-12T01:00:00Z</dc:date></item>")
+    atom := testhelp.CreateAndPopulateATOM(0, testhelp.StartDate())
+    atom.AddPost("<id>updated</id><updated>2015-04-12T01:00:00Z</updated>")
+    for _, tf := range []testhelp.TestFeed{rss, atom} {
+        feed, err := NewFeed(tf.Bytes(), nil)
+        if err != nil {
+            t.Fatal(err)
+        }
+        for _, item := range feed.Items(0, feed.LenItems()) {
+            guid, _ := item.Guid()
+            pd, err := item.PubDate()
+            if err != nil || !pd.Equal(testhelp.StartDate()) {
+                t.Errorf("%s should be dated %v, got %v (%v)", guid,
+                         testhelp.StartDate(), pd, err)
+            }
+            when := testhelp.StartDate().AddDate(1, 0, 0)
+            if err = item.SetPubDate(when); err != nil {
+                t.Fatal(err)
+            }
+            if pd, err = item.PubDate(); err != nil || !pd.Equal(when) {
+                t.Errorf("%s should be re-dated %v, got %v (%v)", guid, when,
+                         pd, err)
+            }
+        }
+    }
+}
+
+func TestAtomSetPubDate(t *testing.T) {
+    feed, err := NewFeed(testhelp.CreateAndPopulateATOM(1,
+                                                      testhelp.StartDate()).Bytes(),
+                         nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    item := feed.Item(0)
+    when := time.Date(2016, 4, 12, 1, 2, 3, 0, time.FixedZone("", 3600))
+    if err = item.SetPubDate(when); err != nil {
+        t.Fatal(err)
+    }
+    for _, tag := range []string{"<published>2016-04-12T01:02:03+01:00</published>",
+                                 "<updated>2016-04-12T01:02:03+01:00</updated>"} {
+        if !strings.Contains(item.String(), tag) {
+            t.Errorf("expected %s in %s", tag, item.String())
+        }
+    }
+}
@@ -2,93 +2,298 @@ package rssrerun
 
 import (
     "errors"
+    "sort"
+    "strconv"
+    "strings"
     "time"
 )
 
-//  We want to set a schedule for reruns based on a start date and a day-of-week
-// schedule (eg, Monday-Wednesday-Friday). This lets us do that and do things
-// like iterate through the dates.
+//  We want to set a schedule for reruns based on a start date and a
+// `Schedule` (eg, Monday-Wednesday-Friday, or every 3 days). This lets us do
+// that and do things like iterate through the dates.
+//
+//  Dates come out at `ReleaseAt` past midnight in `Location`, so a Monday
+// episode can show up at 06:00 Monday for someone in Vancouver instead of
+// 16:00 Sunday. `StartDate` is midnight of the first day, in `Location`.
+//
+//  Any scheduled days that fall in one of the `Blackouts` are skipped over, and
+// the rerun picks up again with the next episode afterwards.
+//
+//  The first `Burst` episodes all come out together on the first release of
+// the rerun, so that someone starting a rerun can get a few episodes in right
+// away. The `Schedule` then picks up with the episode after those.
+//
+//  Items keep their original pubdate in a <rerun:originalPubDate> tag. With
+// `NoteOriginal`, their descriptions also start by saying when that was, so
+// that listeners know how old they are.
+//
+//  `End` says what to do once the rerun has gone through every item there is
+// (see `EndPolicy`).
+//
+//  Items are replayed in the order the feed has them, unless `ByEpisode` is
+// set, in which case they go by season and episode number instead (for feeds
+// whose pubDates can't be trusted). Only the items `Filter` includes are
+// replayed at all.
+//
+//  If `Speed` is set, the `Schedule` is ignored and instead items are replayed
+// with the same spacing they were originally published with, `Speed` times as
+// fast (so 2 would replay a weekly show twice a week). See `CadenceDate()`.
+//
+//  `DateAt()` and `DatesInRange()` never change the `DateSource`, so one can be
+// shared between goroutines as long as they stick to those. `NextDate()` and
+// `SkipForward()` are a cursor on top of `DateAt()`, and are not safe to share.
 type DateSource struct {
     StartDate time.Time
-    Schedule []time.Weekday
-    lastDate time.Time
+    Schedule Schedule
+    Location *time.Location
+    ReleaseAt time.Duration
+    Blackouts []Blackout
+    Burst int
+    End EndPolicy
+    ByEpisode bool
+    Filter *Filter
+    NoteOriginal bool
+    Speed float64
+    // index of the date `NextDate()` will return
+    next int
 }
 
-func (d *DateSource) containsDay(day time.Time) bool {
-    // only checks the schedule, not for startdate
-    weekday := day.Weekday()
-    for _, wd := range d.Schedule {
-        if wd == weekday {
-            return true
+//  A pause in the rerun, from the day `From` through the day `To` (so a
+// one-off skipped day has both the same). Only the day each falls on in the
+// `DateSource`'s `Location` matters, not the time.
+type Blackout struct {
+    From, To time.Time
+}
+
+//  Read in blackouts as comma-separated days or ranges of days, eg
+// "20150601-20150614,20150704" (the first two weeks of June, and July 4th).
+// The days are in `loc`.
+func ParseBlackouts(s string, loc *time.Location) ([]Blackout, error) {
+    ret := []Blackout{}
+    for _, part := range strings.Split(s, ",") {
+        days := strings.SplitN(strings.TrimSpace(part), "-", 2)
+        from, err := time.ParseInLocation("20060102", days[0], loc)
+        if err != nil {
+            return nil, errors.New("invalid blackout date \"" + part + "\"")
+        }
+        to := from
+        if len(days) == 2 {
+            to, err = time.ParseInLocation("20060102", days[1], loc)
+            if err != nil || to.Before(from) {
+                return nil, errors.New("invalid blackout range \"" +
+                                       part + "\"")
+            }
+        }
+        ret = append(ret, Blackout{from, to})
+    }
+    return ret, nil
+}
+
+//  What a rerun does once it runs out of items to replay.
+type EndPolicy int
+
+const (
+    // the rerun stops, and the feed stays on its last few items
+    EndStop EndPolicy = iota
+    // start over from the first item, with fresh guids so they show up again
+    EndLoop
+    //  keep replaying on schedule, but never release an item before it was
+    // originally published. Once the rerun catches up, new items come out as
+    // they are published.
+    EndLive
+)
+
+var endPolicyNames = []string{"stop", "loop", "live"}
+
+func (e EndPolicy) String() string {
+    if e < 0 || int(e) >= len(endPolicyNames) {
+        return "EndPolicy(" + strconv.Itoa(int(e)) + ")"
+    }
+    return endPolicyNames[e]
+}
+
+// Read in an `EndPolicy` by name: "stop", "loop", or "live".
+func ParseEndPolicy(s string) (EndPolicy, error) {
+    for i, name := range endPolicyNames {
+        if s == name {
+            return EndPolicy(i), nil
         }
     }
-    return false
+    return EndStop, errors.New("unknown end policy \"" + s + "\"")
 }
 
+//  The longest we'll look for the next date on a schedule. The worst legitimate
+// case is the 29th of February, which can go 8 years between leap days.
+const maxScheduleGap = 8 * 366
+
+//  Make a `DateSource` that publishes on the days of the week in `schedule`.
+// An empty `schedule` makes a `DateSource` with no dates at all.
 func NewDateSource(start time.Time, schedule []time.Weekday) *DateSource {
+    if len(schedule) == 0 {
+        return NewScheduledDateSource(start, nil)
+    }
+    return NewScheduledDateSource(start, Weekdays(schedule))
+}
+
+//  Make a `DateSource` that publishes on any kind of `Schedule`, at midnight
+// UTC.
+func NewScheduledDateSource(start time.Time, schedule Schedule) *DateSource {
+    // the date `start` is on is kept as-is, whatever zone it's in
+    day := time.Date(start.Year(), start.Month(), start.Day(),
+                     0, 0, 0, 0, time.UTC)
+    return NewLocalDateSource(day, schedule, time.UTC, 0)
+}
+
+//  Make a `DateSource` that publishes on `schedule`, at `at` past midnight in
+// `loc`. The rerun starts on whatever day it is in `loc` at `start`.
+func NewLocalDateSource(start time.Time, schedule Schedule,
+                        loc *time.Location, at time.Duration) *DateSource {
     d := new(DateSource)
+    start = start.In(loc)
     d.StartDate = time.Date(start.Year(), start.Month(), start.Day(),
-                            0, 0, 0, 0, time.UTC)
+                            0, 0, 0, 0, loc)
     d.Schedule = schedule
-    // initialize to a day before the startdate so that we catch it too
-    d.lastDate = d.StartDate.AddDate(0, 0, -1)
+    d.Location = loc
+    d.ReleaseAt = at
     return d
 }
 
+//  What is the `k`th date on the schedule? Counts from 0, so `DateAt(0)` is the
+// first date of the rerun.
+func (d *DateSource) DateAt(k int) (time.Time, error) {
+    if k >= 0 && k < d.Burst {
+        return d.release(d.firstDay()), nil
+    }
+    if d.Schedule == nil {
+        return d.StartDate, errors.New("DateAt() on empty schedule")
+    }
+    day, err := nthDay(d.schedule(), d.firstDay(), k - d.Burst)
+    if err != nil {
+        return d.StartDate, err
+    }
+    return d.release(day), nil
+}
+
+//  In original cadence mode (`Speed` > 0), when does an item originally
+// published at `pub` come out? `origin` is when the first item of the feed was
+// published, which gets replayed at the start of the rerun. Anything published
+// before `origin` (ie, a `Burst`) comes out at the start too.
+func (d *DateSource) CadenceDate(origin, pub time.Time) time.Time {
+    if pub.Before(origin) {
+        pub = origin
+    }
+    offset := float64(pub.Sub(origin)) / d.Speed
+    return d.release(d.firstDay()).Add(time.Duration(offset))
+}
+
+//  The reverse of `CadenceDate()`: by time `t`, everything originally published
+// up to and including the returned time will have come out.
+func (d *DateSource) CadenceCutoff(origin, t time.Time) time.Time {
+    elapsed := float64(t.Sub(d.release(d.firstDay()))) * d.Speed
+    return origin.Add(time.Duration(elapsed))
+}
+
 // What is the next date on the schedule for our `DateSource`?
 func (d *DateSource) NextDate() (time.Time, error) {
-    if d.Schedule == nil {
-        return d.StartDate, errors.New("NextDate() on empty schedule")
-    }
-    if d.lastDate.Before(d.StartDate) {
-        d.lastDate = d.StartDate
-    } else {
-		d.lastDate = d.lastDate.AddDate(0, 0, 1)
-	}
-    for {
-        if d.containsDay(d.lastDate) {
-        // will eventually work, we already know d.Schedule is not empty.
-            return d.lastDate, nil
-        }
-        d.lastDate = d.lastDate.AddDate(0, 0, 1)
+    when, err := d.DateAt(d.next)
+    if err != nil {
+        return d.StartDate, err
     }
+    d.next++
+    return when, nil
 }
 
-// skip forward by `nDays` *scheduled* days
+//  Skip forward by `nDays` *scheduled* days, as if `NextDate()` had been called
+// that many times. Negative `nDays` steps back instead, but never to before
+// the start of the schedule.
 func (d *DateSource) SkipForward(nDays int) {
-    for nDays != 0 {
-        if nDays > 0 {
-            _, _ = d.NextDate()
-            nDays--
-        } else {
-            for {
-                d.lastDate = d.lastDate.AddDate(0, 0, -1)
-                if d.containsDay(d.lastDate) {
-                    break
-                }
-            }
-            nDays++
-        }
+    d.next += nDays
+    if d.next < 0 {
+        d.next = 0
     }
 }
 
-// how many days are there between `from` and `to`, inclusive?
-// TODO or does it not include `to`? Also, is this actually correct?
+//  How many scheduled dates are there from `from` up to, but not including,
+// `to`? A date is counted if `from` <= date < `to`, where the date is the
+// moment it is released (see `ReleaseAt`). Dates before `StartDate` are never
+// counted, but a `Burst` counts as that many dates on the first release.
 func (d *DateSource) DatesInRange(from, to time.Time) int {
-    if from.After(to) {
-        return 0
-    }
-    storelast := d.lastDate
-    d.lastDate = from
-    nDates := 0
-    for when := from; when.Before(to); when, _ = d.NextDate() {
-        nDates++
-    }
-    d.lastDate = storelast
-    // if from is not a date on our schedule, we have counted one too many
-    // TODO I don't actually trust this.
-    if !d.containsDay(from) {
-        nDates -= 1
-    }
-    return nDates
+    burst := 0
+    if rel := d.release(d.firstDay()); !rel.Before(from) && rel.Before(to) {
+        burst = d.Burst
+    }
+    if d.Schedule == nil {
+        return burst
+    }
+    first, last := d.firstReleasedBy(from), d.firstReleasedBy(to)
+    if start := d.firstDay(); first.Before(start) {
+        first = start
+    }
+    if !first.Before(last) {
+        return burst
+    }
+    return burst + countDays(d.schedule(), d.firstDay(), first, last)
+}
+
+//  The `Schedule`, with the `Blackouts` taken out. The blackouts are sorted and
+// merged fresh every time so that we never have to change the `DateSource`.
+func (d *DateSource) schedule() Schedule {
+    if len(d.Blackouts) == 0 {
+        return d.Schedule
+    }
+    spans := make([][2]time.Time, len(d.Blackouts))
+    for i, b := range d.Blackouts {
+        spans[i] = [2]time.Time{d.civilDay(b.From),
+                                d.civilDay(b.To).AddDate(0, 0, 1)}
+    }
+    sort.Slice(spans, func(i, j int) bool {
+        return spans[i][0].Before(spans[j][0])
+    })
+    merged := spans[:1]
+    for _, span := range spans[1:] {
+        last := &merged[len(merged) - 1]
+        if span[0].After(last[1]) {
+            merged = append(merged, span)
+        } else if span[1].After(last[1]) {
+            last[1] = span[1]
+        }
+    }
+    return &blackedOut{d.Schedule, merged}
+}
+
+func (d *DateSource) loc() *time.Location {
+    if d.Location == nil {
+        return time.UTC
+    }
+    return d.Location
+}
+
+//  `Schedule`s work in days as midnight UTC, no matter where the rerun is. This
+// is the day `t` falls on in `Location`, in that form.
+func (d *DateSource) civilDay(t time.Time) time.Time {
+    t = t.In(d.loc())
+    return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// the first day of the rerun, in the form `Schedule`s use
+func (d *DateSource) firstDay() time.Time {
+    return d.civilDay(d.StartDate)
+}
+
+// when does the scheduled `day` (midnight UTC) actually get released?
+func (d *DateSource) release(day time.Time) time.Time {
+    at := d.ReleaseAt
+    return time.Date(day.Year(), day.Month(), day.Day(),
+                     int(at / time.Hour), int(at % time.Hour / time.Minute),
+                     int(at % time.Minute / time.Second),
+                     int(at % time.Second), d.loc())
+}
+
+// the first day (midnight UTC) that is not released before `t`
+func (d *DateSource) firstReleasedBy(t time.Time) time.Time {
+    day := d.civilDay(t)
+    if d.release(day).Before(t) {
+        day = day.AddDate(0, 0, 1)
+    }
+    return day
 }
@@ -1,6 +1,8 @@
 package rssrerun
 
 import (
+    "errors"
+    "fmt"
     "time"
     "testing"
 )
@@ -134,3 +136,456 @@ func TestDatesInRangeOffset(t *testing.T) {
         t.Errorf("Expected 2 dates in range, got %d", nDates);
     }
 }
+
+//  `DateSource` as it was before `DatesInRange()` and `SkipForward()` were
+// worked out arithmetically, walking one day at a time. The methods are copied
+// word for word, so the new ones can be checked against them. The differences
+// are on purpose:
+//  - an empty range (`from` == `to`) has no dates in it, where the old
+//    `DatesInRange()` could say -1
+//  - dates before `StartDate` aren't counted, where the old `DatesInRange()`
+//    counted `from` as a date if it was before the start
+type legacyDateSource struct {
+    StartDate time.Time
+    Schedule Schedule
+    lastDate time.Time
+}
+
+func newLegacyDateSource(start time.Time, schedule Schedule) *legacyDateSource {
+    return &legacyDateSource{start, schedule, start.AddDate(0, 0, -1)}
+}
+
+func (d *legacyDateSource) containsDay(day time.Time) bool {
+    // only checks the schedule, not for startdate
+    return d.Schedule.Contains(d.StartDate, day)
+}
+
+func (d *legacyDateSource) NextDate() (time.Time, error) {
+    if d.Schedule == nil {
+        return d.StartDate, errors.New("NextDate() on empty schedule")
+    }
+    if d.lastDate.Before(d.StartDate) {
+        d.lastDate = d.StartDate
+    } else {
+		d.lastDate = d.lastDate.AddDate(0, 0, 1)
+	}
+    for i := 0; i < maxScheduleGap; i++ {
+        if d.containsDay(d.lastDate) {
+            return d.lastDate, nil
+        }
+        d.lastDate = d.lastDate.AddDate(0, 0, 1)
+    }
+    return d.StartDate, errors.New("NextDate() found no dates on schedule")
+}
+
+// skip forward by `nDays` *scheduled* days
+func (d *legacyDateSource) SkipForward(nDays int) {
+    for nDays != 0 {
+        if nDays > 0 {
+            _, _ = d.NextDate()
+            nDays--
+        } else {
+            for i := 0; i < maxScheduleGap; i++ {
+                d.lastDate = d.lastDate.AddDate(0, 0, -1)
+                if d.containsDay(d.lastDate) {
+                    break
+                }
+            }
+            nDays++
+        }
+    }
+}
+
+// how many days are there between `from` and `to`, inclusive?
+// TODO or does it not include `to`? Also, is this actually correct?
+func (d *legacyDateSource) DatesInRange(from, to time.Time) int {
+    if from.After(to) || d.Schedule == nil {
+        return 0
+    }
+    storelast := d.lastDate
+    d.lastDate = from
+    nDates := 0
+    for when := from; when.Before(to); {
+        nDates++
+        var err error
+        if when, err = d.NextDate(); err != nil {
+            break
+        }
+    }
+    d.lastDate = storelast
+    // if from is not a date on our schedule, we have counted one too many
+    // TODO I don't actually trust this.
+    if !d.containsDay(from) {
+        nDates -= 1
+    }
+    return nDates
+}
+
+func propertySchedules(t *testing.T) []Schedule {
+    ret := []Schedule{}
+    for _, s := range []string{"0", "6", "02", "135", "0123456", "days:1",
+                               "days:3", "days:10", "weeks:2:2",
+                               "weeks:3:045", "nth:1mon", "nth:-1fri,2wed",
+                               "nth:5sun,-1sun", "cron:1,15 * *",
+                               "cron:* 2 *", "cron:29 2 *", "cron:13 * 5",
+                               "cron:*/10 1-6 1-5", "cron:31 * 0"} {
+        sched, err := ParseSchedule(s)
+        if err != nil {
+            t.Fatal(err)
+        }
+        ret = append(ret, sched)
+    }
+    return ret
+}
+
+func TestDatesInRangeMatchesIterating(t *testing.T) {
+    for _, sched := range propertySchedules(t) {
+        for startOff := 0; startOff < 7; startOff++ {
+            start := StartDate().AddDate(0, 0, startOff)
+            dsrc := NewScheduledDateSource(start, sched)
+            legacy := newLegacyDateSource(start, sched)
+            for fromOff := -3; fromOff < 35; fromOff += 2 {
+                from := start.AddDate(0, 0, fromOff)
+                for toOff := fromOff - 2; toOff < 800; toOff += 1 + toOff / 10 {
+                    to := start.AddDate(0, 0, toOff)
+                    // the differences listed on `legacyDateSource`
+                    lo, exp := from, 0
+                    if lo.Before(start) {
+                        lo = start
+                    }
+                    if to.After(lo) {
+                        exp = legacy.DatesInRange(lo, to)
+                    }
+                    if got := dsrc.DatesInRange(from, to); got != exp {
+                        t.Fatalf("%s from %v: [%v, %v) expected %d, got %d",
+                                 sched, start, from, to, exp, got)
+                    }
+                }
+            }
+        }
+    }
+}
+
+func TestSkipForwardMatchesIterating(t *testing.T) {
+    for _, sched := range propertySchedules(t) {
+        for startOff := 0; startOff < 7; startOff++ {
+            start := StartDate().AddDate(0, 0, startOff)
+            for n := 0; n < 40; n += 1 + n / 8 {
+                for _, back := range []int{0, 1, n / 2, n, n + 1} {
+                    legacy := newLegacyDateSource(start, sched)
+                    legacy.SkipForward(n)
+                    legacy.SkipForward(-back)
+                    exp, err := legacy.NextDate()
+                    if err != nil {
+                        t.Fatal(err)
+                    }
+                    dsrc := NewScheduledDateSource(start, sched)
+                    dsrc.SkipForward(n)
+                    dsrc.SkipForward(-back)
+                    if got, _ := dsrc.NextDate(); got != exp {
+                        t.Fatalf("%s from %v: skipping %d then back %d " +
+                                 "expected %v, got %v",
+                                 sched, start, n, back, exp, got)
+                    }
+                }
+            }
+        }
+    }
+}
+
+func TestDatesInRangeTimeOfDay(t *testing.T) {
+    // dates are at midnight, so partial days count on the `to` end only
+    dsrc := NewDateSource(StartDate(), []time.Weekday{time.Sunday})
+    sunday := time.Date(2015, time.March, 22, 0, 0, 0, 0, time.UTC)
+    if n := dsrc.DatesInRange(StartDate(), sunday); n != 0 {
+        t.Errorf("range ending at midnight should exclude it, got %d", n)
+    }
+    if n := dsrc.DatesInRange(StartDate(), sunday.Add(time.Minute)); n != 1 {
+        t.Errorf("range ending after midnight should include it, got %d", n)
+    }
+    if n := dsrc.DatesInRange(sunday.Add(time.Minute),
+                              sunday.AddDate(0, 0, 1)); n != 0 {
+        t.Errorf("range starting after midnight should exclude it, got %d", n)
+    }
+}
+
+func TestDatesInRangeFarFuture(t *testing.T) {
+    dsrc := NewDateSource(StartDate(), []time.Weekday{time.Sunday,
+                                                      time.Tuesday})
+    // 100 years of Sundays and Tuesdays, give or take a few
+    n := dsrc.DatesInRange(StartDate(), StartDate().AddDate(100, 0, 0))
+    if n < 2 * 5217 || n > 2 * 5218 {
+        t.Errorf("expected about %d dates, got %d", 2 * 5218, n)
+    }
+    dsrc.SkipForward(n - 1)
+    last, err := dsrc.NextDate()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !last.Before(StartDate().AddDate(100, 0, 0)) ||
+            last.Before(StartDate().AddDate(100, 0, -7)) {
+        t.Errorf("last date should be in the last week, got %v", last)
+    }
+}
+
+func TestDateAtMatchesNextDate(t *testing.T) {
+    dsrc := NewDateSource(StartDate(),
+                          []time.Weekday{time.Sunday, time.Tuesday})
+    iter := NewDateSource(StartDate(),
+                          []time.Weekday{time.Sunday, time.Tuesday})
+    for i := 0; i < 100; i++ {
+        when, err := dsrc.DateAt(i)
+        if err != nil {
+            t.Fatal(err)
+        }
+        next, _ := iter.NextDate()
+        if when != next {
+            t.Fatalf("DateAt(%d) is %v, NextDate() is %v", i, when, next)
+        }
+    }
+    if _, err := dsrc.DateAt(-1); err == nil {
+        t.Error("expected an error for a date before the start")
+    }
+}
+
+func TestDateAtDoesNotMove(t *testing.T) {
+    dsrc := NewDateSource(StartDate(),
+                          []time.Weekday{time.Sunday, time.Tuesday})
+    first, _ := dsrc.DateAt(0)
+    _, _ = dsrc.DateAt(50)
+    _ = dsrc.DatesInRange(StartDate(), StartDate().AddDate(1, 0, 0))
+    if next, _ := dsrc.NextDate(); next != first {
+        t.Errorf("expected NextDate() to still start at %v, got %v",
+                 first, next)
+    }
+}
+
+func TestDateSourceConcurrent(t *testing.T) {
+    dsrc := NewDateSource(StartDate(),
+                          []time.Weekday{time.Sunday, time.Tuesday})
+    expected := make([]time.Time, 200)
+    for i := range expected {
+        expected[i], _ = dsrc.DateAt(i)
+    }
+    errs := make(chan error, 8)
+    for g := 0; g < 8; g++ {
+        go func(g int) {
+            for i := g; i < len(expected); i += 3 {
+                when, err := dsrc.DateAt(i)
+                if err == nil && when != expected[i] {
+                    err = fmt.Errorf("DateAt(%d) gave %v, expected %v",
+                                     i, when, expected[i])
+                }
+                n := dsrc.DatesInRange(StartDate(), expected[i])
+                if err == nil && n != i {
+                    err = fmt.Errorf("%d dates before %v, expected %d",
+                                     n, expected[i], i)
+                }
+                if err != nil {
+                    errs <- err
+                    return
+                }
+            }
+            errs <- nil
+        }(g)
+    }
+    for g := 0; g < 8; g++ {
+        if err := <-errs; err != nil {
+            t.Error(err)
+        }
+    }
+}
+
+func TestReleaseTimeOfDay(t *testing.T) {
+    vancouver, err := time.LoadLocation("America/Vancouver")
+    if err != nil {
+        t.Skip("no time zone database: ", err)
+    }
+    dsrc := NewLocalDateSource(StartDate(), Weekdays{time.Monday},
+                               vancouver, 6 * time.Hour)
+    for i := 0; i < 52; i++ {
+        when, err := dsrc.DateAt(i)
+        if err != nil {
+            t.Fatal(err)
+        }
+        local := when.In(vancouver)
+        if local.Weekday() != time.Monday || local.Hour() != 6 ||
+                local.Minute() != 0 {
+            // includes across daylight savings changes
+            t.Fatalf("date %d should be Monday 06:00 local, got %v", i, local)
+        }
+    }
+}
+
+func TestDatesInRangeReleaseTime(t *testing.T) {
+    vancouver, err := time.LoadLocation("America/Vancouver")
+    if err != nil {
+        t.Skip("no time zone database: ", err)
+    }
+    dsrc := NewLocalDateSource(StartDate(), Weekdays{time.Monday},
+                               vancouver, 6 * time.Hour)
+    // Monday the 23rd at 06:00 in Vancouver is 13:00 UTC
+    release := time.Date(2015, time.March, 23, 13, 0, 0, 0, time.UTC)
+    first, _ := dsrc.DateAt(0)
+    if !first.Equal(release) {
+        t.Fatalf("expected first release at %v, got %v", release, first)
+    }
+    if n := dsrc.DatesInRange(dsrc.StartDate, release); n != 0 {
+        t.Errorf("released too early, %d dates before %v", n, release)
+    }
+    if n := dsrc.DatesInRange(dsrc.StartDate, release.Add(time.Second)); n != 1 {
+        t.Errorf("not released on time, %d dates by %v", n, release)
+    }
+}
+
+func TestLocalStartDay(t *testing.T) {
+    vancouver, err := time.LoadLocation("America/Vancouver")
+    if err != nil {
+        t.Skip("no time zone database: ", err)
+    }
+    // late on the 19th in Vancouver is already the 20th in UTC
+    start := time.Date(2015, time.March, 20, 4, 0, 0, 0, time.UTC)
+    dsrc := NewLocalDateSource(start, DayInterval(1), vancouver, 0)
+    first, _ := dsrc.DateAt(0)
+    if first.In(vancouver).Day() != 19 {
+        t.Errorf("expected the rerun to start on the 19th, got %v",
+                 first.In(vancouver))
+    }
+}
+
+func TestBlackoutSkipsDates(t *testing.T) {
+    sched := []time.Weekday{time.Sunday, time.Tuesday}
+    plain := NewDateSource(StartDate(), sched)
+    paused := NewDateSource(StartDate(), sched)
+    // two weeks off, which is 4 dates
+    paused.Blackouts = []Blackout{{StartDate().AddDate(0, 0, 14),
+                                   StartDate().AddDate(0, 0, 27)}}
+    for i := 0; i < 50; i++ {
+        when, err := paused.DateAt(i)
+        if err != nil {
+            t.Fatal(err)
+        }
+        expected, _ := plain.DateAt(i)
+        if !expected.Before(StartDate().AddDate(0, 0, 14)) {
+            // past the blackout, we're 4 dates behind
+            expected, _ = plain.DateAt(i + 4)
+        }
+        if when != expected {
+            t.Fatalf("date %d should be %v, got %v", i, expected, when)
+        }
+    }
+}
+
+func TestBlackoutDatesInRange(t *testing.T) {
+    sched := []time.Weekday{time.Sunday, time.Tuesday}
+    dsrc := NewDateSource(StartDate(), sched)
+    end := StartDate().AddDate(0, 3, 0)
+    before := dsrc.DatesInRange(StartDate(), end)
+    // overlapping ranges, a one-off day inside them, and one on its own
+    dsrc.Blackouts = []Blackout{
+        {StartDate().AddDate(0, 0, 14), StartDate().AddDate(0, 0, 20)},
+        {StartDate().AddDate(0, 0, 17), StartDate().AddDate(0, 0, 27)},
+        {StartDate().AddDate(0, 0, 19), StartDate().AddDate(0, 0, 19)},
+        {StartDate().AddDate(0, 0, 38), StartDate().AddDate(0, 0, 38)},
+    }
+    // 4 dates in the ranges, and the 38th day is a Sunday
+    if n := dsrc.DatesInRange(StartDate(), end); n != before - 5 {
+        t.Errorf("expected %d dates, got %d", before - 5, n)
+    }
+    for i := 0; i < dsrc.DatesInRange(StartDate(), end); i++ {
+        when, _ := dsrc.DateAt(i)
+        for _, b := range dsrc.Blackouts {
+            if !when.Before(b.From) && !when.After(b.To) {
+                t.Errorf("date %d (%v) is in a blackout", i, when)
+            }
+        }
+        if n := dsrc.DatesInRange(StartDate(), when); n != i {
+            t.Errorf("expected %d dates before %v, got %d", i, when, n)
+        }
+    }
+}
+
+func TestParseBlackouts(t *testing.T) {
+    bs, err := ParseBlackouts("20150601-20150614,20150704", time.UTC)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(bs) != 2 {
+        t.Fatalf("expected 2 blackouts, got %d", len(bs))
+    }
+    if bs[0].To.Day() != 14 || bs[1].From != bs[1].To {
+        t.Errorf("blackouts parsed wrong: %v", bs)
+    }
+    // and they come back out the same way in the schedule's description
+    dsrc := NewDateSource(StartDate(), []time.Weekday{time.Sunday, time.Tuesday})
+    dsrc.Blackouts = bs
+    want := "02 except 20150601-20150614,20150704"
+    if got := dsrc.schedule().String(); got != want {
+        t.Errorf("expected %s, got %s", want, got)
+    }
+    for _, s := range []string{"", "2015060", "20150614-20150601", "foo"} {
+        if _, err := ParseBlackouts(s, time.UTC); err == nil {
+            t.Errorf("expected an error parsing \"%s\"", s)
+        }
+    }
+}
+
+func TestBurstDateAt(t *testing.T) {
+    sched := []time.Weekday{time.Sunday, time.Tuesday}
+    plain := NewDateSource(StartDate(), sched)
+    dsrc := NewDateSource(StartDate(), sched)
+    dsrc.Burst = 3
+    for i := 0; i < 3; i++ {
+        when, err := dsrc.DateAt(i)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if !when.Equal(StartDate()) {
+            t.Errorf("burst date %d should be %v, got %v", i, StartDate(), when)
+        }
+    }
+    // and then the schedule carries on as it would have
+    for i := 0; i < 20; i++ {
+        expected, _ := plain.DateAt(i)
+        when, _ := dsrc.DateAt(i + 3)
+        if !when.Equal(expected) {
+            t.Errorf("date %d should be %v, got %v", i + 3, expected, when)
+        }
+    }
+}
+
+func TestBurstDatesInRange(t *testing.T) {
+    dsrc := NewDateSource(StartDate(), []time.Weekday{time.Sunday})
+    dsrc.Burst = 3
+    if n := dsrc.DatesInRange(StartDate(), StartDate()); n != 0 {
+        t.Errorf("expected nothing out before the start, got %d", n)
+    }
+    if n := dsrc.DatesInRange(StartDate(), StartDate().Add(time.Second)); n != 3 {
+        t.Errorf("expected the burst of 3 right away, got %d", n)
+    }
+    // StartDate() is a Thursday, so 10 days in is after one Sunday
+    end := StartDate().AddDate(0, 0, 10)
+    if n := dsrc.DatesInRange(StartDate(), end); n != 4 {
+        t.Errorf("expected 4 dates, got %d", n)
+    }
+    if n := dsrc.DatesInRange(StartDate().Add(time.Hour), end); n != 1 {
+        t.Errorf("expected the burst to be left out, got %d", n)
+    }
+    // works without any schedule at all
+    dsrc.Schedule = nil
+    if n := dsrc.DatesInRange(StartDate(), end); n != 3 {
+        t.Errorf("expected only the burst, got %d", n)
+    }
+}
+
+func TestParseEndPolicy(t *testing.T) {
+    for _, e := range []EndPolicy{EndStop, EndLoop, EndLive} {
+        got, err := ParseEndPolicy(e.String())
+        if err != nil || got != e {
+            t.Errorf("%s didn't round-trip: %v, %v", e, got, err)
+        }
+    }
+    if _, err := ParseEndPolicy("forever"); err == nil {
+        t.Error("expected an error for an unknown end policy")
+    }
+}
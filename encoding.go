@@ -0,0 +1,119 @@
+package rssrerun
+
+import (
+    "bytes"
+    "errors"
+    "mime"
+    "regexp"
+    "strings"
+    "unicode/utf16"
+    "unicode/utf8"
+
+    log "github.com/sirupsen/logrus"
+)
+
+//  Everything past `NewFeed()` assumes UTF-8: the wrapper is kept as a Go
+// string, items are stored as text, and JSON can't hold anything else. So
+// feeds in anything else are transcoded as they come in, and their XML
+// declaration changed to match.
+
+// <?xml ... encoding="..."?>, and where in it the encoding is
+var xmlDeclEncoding = regexp.MustCompile(
+        `^<\?xml[^>]*?\sencoding\s*=\s*["']([A-Za-z0-9._:-]+)["']`)
+
+//  Windows-1252 is ISO-8859-1 but with printable characters where 8859-1 has
+// C1 control codes (0x80-0x9F). Nobody means those control codes, so like web
+// browsers do, ISO-8859-1 is read as Windows-1252. The five unused spots are
+// left as the control codes.
+var cp1252High = [32]rune{
+    '€', '\u0081', '‚', 'ƒ', '„', '…', '†', '‡',
+    'ˆ', '‰', 'Š', '‹', 'Œ', '\u008d', 'Ž', '\u008f',
+    '\u0090', '‘', '’', '“', '”', '•', '–', '—',
+    '˜', '™', 'š', '›', 'œ', '\u009d', 'ž', 'Ÿ',
+}
+
+//  Transcode the feed `t` to UTF-8, if it isn't already. The encoding comes
+// from (in order) a byte order mark, the charset in `contentType` (the HTTP
+// Content-Type header it came with, or "" if there isn't one), or the XML
+// declaration. If the feed has an XML declaration, it's changed to say UTF-8.
+// UTF-8, ASCII, ISO-8859-1, Windows-1252 and UTF-16 are understood. Anything
+// else is passed through untouched (with a warning logged), to leave it up to
+// the XML parser and whatever it can make of the declaration.
+func ToUTF8(t []byte, contentType string) ([]byte, error) {
+    charset := ""
+    switch {
+    case bytes.HasPrefix(t, []byte("\xef\xbb\xbf")):
+        charset, t = "utf-8", t[3:]
+    case bytes.HasPrefix(t, []byte("\xfe\xff")):
+        charset, t = "utf-16be", t[2:]
+    case bytes.HasPrefix(t, []byte("\xff\xfe")):
+        charset, t = "utf-16le", t[2:]
+    }
+    if len(charset) == 0 && len(contentType) > 0 {
+        if _, params, err := mime.ParseMediaType(contentType); err == nil {
+            charset = params["charset"]
+        }
+    }
+    if len(charset) == 0 {
+        if m := xmlDeclEncoding.FindSubmatch(t); m != nil {
+            charset = string(m[1])
+        }
+    }
+
+    var ret []byte
+    switch strings.ToLower(charset) {
+    case "", "utf-8", "utf8", "us-ascii", "ascii":
+        if !utf8.Valid(t) {
+            return nil, errors.New("feed isn't valid UTF-8")
+        }
+        ret = t
+    case "iso-8859-1", "iso8859-1", "iso_8859-1", "latin1", "latin-1", "l1",
+            "windows-1252", "cp1252", "x-cp1252":
+        ret = decodeCp1252(t)
+    case "utf-16", "utf-16be":
+        // without a byte order mark, UTF-16 is big-endian
+        ret = decodeUtf16(t, true)
+    case "utf-16le":
+        ret = decodeUtf16(t, false)
+    default:
+        log.WithFields(log.Fields{
+            "charset": charset,
+        }).Warn("can't transcode feed, passing it through as-is")
+        return t, nil
+    }
+
+    if m := xmlDeclEncoding.FindSubmatchIndex(ret); m != nil {
+        fixed := make([]byte, 0, len(ret))
+        fixed = append(fixed, ret[:m[2]]...)
+        fixed = append(fixed, "UTF-8"...)
+        ret = append(fixed, ret[m[3]:]...)
+    }
+    return ret, nil
+}
+
+func decodeCp1252(t []byte) []byte {
+    ret := make([]byte, 0, len(t) + len(t) / 8)
+    for _, b := range t {
+        switch {
+        case b < 0x80:
+            ret = append(ret, b)
+        case b < 0xa0:
+            ret = append(ret, string(cp1252High[b - 0x80])...)
+        default:
+            ret = append(ret, string(rune(b))...)
+        }
+    }
+    return ret
+}
+
+func decodeUtf16(t []byte, bigEndian bool) []byte {
+    units := make([]uint16, len(t) / 2)
+    for i := range units {
+        if bigEndian {
+            units[i] = uint16(t[2 * i]) << 8 | uint16(t[2 * i + 1])
+        } else {
+            units[i] = uint16(t[2 * i + 1]) << 8 | uint16(t[2 * i])
+        }
+    }
+    return []byte(string(utf16.Decode(units)))
+}
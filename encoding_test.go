@@ -0,0 +1,121 @@
+package rssrerun
+
+import (
+    "strings"
+    "testing"
+
+    "github.com/patrickyeon/rssrerun/testhelp"
+)
+
+func TestToUTF8(t *testing.T) {
+    decl := func(enc string) string {
+        return "<?xml version=\"1.0\" encoding=\"" + enc + "\"?>"
+    }
+    cases := []struct {
+        text, contentType, want string
+    }{
+        {"<rss>café</rss>", "", "<rss>café</rss>"},
+        {decl("UTF-8") + "<rss>café</rss>", "", decl("UTF-8") + "<rss>café</rss>"},
+        {decl("ISO-8859-1") + "<rss>caf\xe9</rss>", "",
+         decl("UTF-8") + "<rss>café</rss>"},
+        {decl("windows-1252") + "<rss>\x93caf\xe9\x94 \x80</rss>", "",
+         decl("UTF-8") + "<rss>“café” €</rss>"},
+        // the server knows better than the feed
+        {decl("utf-8") + "<rss>caf\xe9</rss>", "text/xml; charset=iso-8859-1",
+         decl("UTF-8") + "<rss>café</rss>"},
+        {"<rss>caf\xe9</rss>", "application/rss+xml; charset=\"Latin1\"",
+         "<rss>café</rss>"},
+        // and a byte order mark knows better than either
+        {"\xef\xbb\xbf" + decl("ISO-8859-1") + "<rss>café</rss>",
+         "text/xml; charset=iso-8859-1", decl("UTF-8") + "<rss>café</rss>"},
+        {"\xff\xfe<\x00r\x00>\x00\xe9\x00<\x00/\x00r\x00>\x00", "", "<r>é</r>"},
+        {"\xfe\xff\x00<\x00r\x00>\x00\xe9\x00<\x00/\x00r\x00>", "", "<r>é</r>"},
+        {"{\"title\": \"caf\xe9\"}", "application/feed+json; charset=latin1",
+         "{\"title\": \"café\"}"},
+    }
+    for _, c := range cases {
+        got, err := ToUTF8([]byte(c.text), c.contentType)
+        if err != nil {
+            t.Errorf("%q: %v", c.text, err)
+        } else if string(got) != c.want {
+            t.Errorf("%q should be %q, got %q", c.text, c.want, got)
+        }
+    }
+
+    // with nothing to say otherwise, it has to be UTF-8
+    for _, bad := range []string{decl("utf-8") + "<rss>caf\xe9</rss>",
+                                 "<rss>caf\xe9</rss>"} {
+        if _, err := ToUTF8([]byte(bad), ""); err == nil {
+            t.Errorf("%q shouldn't transcode", bad)
+        }
+    }
+}
+
+func TestToUTF8Unknown(t *testing.T) {
+    //  Charsets we don't know how to read are left alone, declaration and all,
+    // rather than turning the feed away.
+    for _, c := range []struct {
+        text, contentType string
+    }{
+        {"<?xml version=\"1.0\" encoding=\"windows-1251\"?><rss>\xcc\xe8\xf0</rss>", ""},
+        {"<?xml version=\"1.0\" encoding=\"EBCDIC\"?><rss/>", ""},
+        {"<rss>\x82\xa0</rss>", "text/xml; charset=shift_jis"},
+        {"<rss>caf\xa4</rss>", "application/rss+xml; charset=ISO-8859-15"},
+    } {
+        got, err := ToUTF8([]byte(c.text), c.contentType)
+        if err != nil {
+            t.Errorf("%q: %v", c.text, err)
+        } else if string(got) != c.text {
+            t.Errorf("%q should be untouched, got %q", c.text, got)
+        }
+    }
+}
+
+func TestLatin1Feed(t *testing.T) {
+    latin1 := testhelp.CreateLatin1RSS(3, testhelp.StartDate())
+    if strings.Contains(string(latin1.Bytes()), "é") {
+        t.Fatal("test feed should be in Latin-1, not UTF-8")
+    }
+    feed, err := NewFeed(latin1.Bytes(), nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    check := func(f Feed, when string) {
+        if title := f.Info().Title; title != "Café Fußball" {
+            t.Errorf("%s: wrong feed title %q", when, title)
+        }
+        if title := f.Item(0).Render().Title; title != "café numéro 3" {
+            t.Errorf("%s: wrong item title %q", when, title)
+        }
+    }
+    check(feed, "parsed")
+    if strings.Contains(string(feed.Wrapper()), "ISO-8859-1") {
+        t.Errorf("wrapper still says it's Latin-1: %s", feed.Wrapper())
+    }
+
+    // and it all survives going in the store and back out
+    url := "test://latin1"
+    s := emptyStore()
+    if _, err = s.CreateIndex(url); err != nil {
+        t.Fatal(err)
+    }
+    items := feed.Items(0, feed.LenItems())
+    for i := 0; i < len(items) / 2; i++ {
+        items[i], items[len(items) - i - 1] = items[len(items) - i - 1], items[i]
+    }
+    if err = s.Update(url, items); err != nil {
+        t.Fatal(err)
+    }
+    if err = s.SetInfo(url, "wrapper", string(feed.Wrapper())); err != nil {
+        t.Fatal(err)
+    }
+    stored, err := s.FeedFor(url, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    check(stored, "stored")
+    out := string(stored.BytesWithItems(stored.Items(0, 1)))
+    if !strings.Contains(out, "publié à l'origine") {
+        t.Errorf("rendered feed lost its accents: %s", out)
+    }
+}
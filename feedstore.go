@@ -5,12 +5,12 @@ import (
     "encoding/hex"
     "encoding/json"
     "errors"
+    "io"
     "io/ioutil"
     "os"
+    "path/filepath"
     "strconv"
     "time"
-
-    "github.com/patrickyeon/rssrerun/util"
 )
 
 //  All of the feeds we monitor will be stored broken up by items already to
@@ -49,7 +49,15 @@ type Store interface {
 /1.xml (items 1-19)
  [...]
 /n.xml (items n*10 - max)
+/.lock (held while changing anything in the subdirectory)
  items are stored as <item> elements, oldest first
+
+ The root directory has its own /.lock too, held while creating an `Index`.
+
+ Nothing is committed until index.json is replaced (it, and offsets.json, are
+ written beside the old ones and then renamed over them). Anything in the .xml
+ files past what the index knows about was left by a write that never finished,
+ and gets cut off the next time the feed is opened.
 */
 
 type jsonStore struct {
@@ -68,7 +76,8 @@ type jsonStore struct {
  'hash': 'actual hash',
  'others': {$url: $hash}, // as in, other urls that have collided with this hash
  'guids': [set_of_stashed_guids], // guids for items we've got
- 'meta': {$key: $val} // for external use
+ 'meta': {$key: $val}, // for external use
+ 'tail': 'length of the .xml file with the last item in it'
 }
 */
 
@@ -79,19 +88,61 @@ type Index struct {
     Guids []string `json:"guids"`
     Others map[string]string `json:"others"`
     Meta map[string]string `json:"meta"`
+    //  How much of the last .xml file is committed. Stores from before this was
+    // kept don't have it, and they trust the file.
+    Tail int64 `json:"tail,omitempty"`
     offsets map[string]int64
 }
 
+//  How to set up a `jsonStore`. Anything left as its zero value gets the
+// default.
+type JSONStoreOptions struct {
+    //  How to canonicalize urls (see `CanonFollowHttp`, `CanonSyntactic` and
+    // `CanonIdentity`). By default, they're followed over HTTP.
+    Canon func(string) (string, error)
+    //  How many canonicalizations to remember, so they don't need working out
+    // every time. Default is `DefaultCanonCacheSize`, and anything less than 0
+    // means not to remember any.
+    CanonCacheSize int
+    //  Function to turn a (canonical) url into the name of the subdirectory
+    // it's stored in. Default is the MD5 of the url. Collisions are fine.
+    Key func(string) string
+}
+
+const DefaultCanonCacheSize = 1024
+
 func NewJSONStore(dir string) *jsonStore {
+    return NewJSONStoreWith(dir, JSONStoreOptions{})
+}
+
+func NewJSONStoreWith(dir string, opts JSONStoreOptions) *jsonStore {
     // expand dir to canonical rep
     // make sure it exists
     ret := new(jsonStore)
     ret.rootdir = dir
-    ret.key = justmd5
-    ret.canon = cachingFollowHttp
+    ret.key = opts.Key
+    if ret.key == nil {
+        ret.key = justmd5
+    }
+    ret.canon = cachingCanon(opts.Canon, opts.CanonCacheSize)
     return ret
 }
 
+// `canon` (by default, following over HTTP), cached as `JSONStoreOptions` says
+func cachingCanon(canon func(string) (string, error),
+                  size int) func(string) (string, error) {
+    if canon == nil {
+        canon = CanonFollowHttp
+    }
+    if size < 0 {
+        return canon
+    }
+    if size == 0 {
+        size = DefaultCanonCacheSize
+    }
+    return NewCachingCanon(canon, size)
+}
+
 //  create the key for an `url` by MD5'ing it. Eventually this will end up with
 // a collision, and that's handled by the `Index`.
 func justmd5(url string) string {
@@ -99,21 +150,6 @@ func justmd5(url string) string {
     return hex.EncodeToString(ret[:])
 }
 
-//  wrap a cache of url->canonicalization mappings around the canonicalization
-// so that we're not doing a fetch every time we want the canonical
-// url for something.
-var canonCache = make(map[string]string)
-func cachingFollowHttp(url string) (string, error) {
-    if canonCache[url] != "" {
-        return canonCache[url], nil
-    }
-    ret, err := util.CanonicalUrl(url)
-    if err == nil {
-        canonCache[url] = ret
-    }
-    return ret, err
-}
-
 func fileof(s *jsonStore, ind Index, item int) string {
     retval := s.rootdir + ind.Hash + "/"
     if item == -1 {
@@ -122,6 +158,9 @@ func fileof(s *jsonStore, ind Index, item int) string {
     } else if item == -2 {
         // another special case, offsets
         retval += "offsets.json"
+    } else if item == -3 {
+        // and the lock
+        retval += ".lock"
     } else if item >= 0 {
         retval += strconv.Itoa(item / 10) + ".xml"
     }
@@ -139,31 +178,48 @@ func (s *jsonStore) indexFor(url string) (Index, error) {
     }
 
     if ind.Url != url {
-        for key := range ind.Others {
-            if key == url {
-                return s.indexForHash(ind.Others[key])
-            }
+        hash, ok := ind.Others[url]
+        if !ok {
+            return Index{}, errors.New("couldn't find url")
+        }
+        if ind, err = s.indexForHash(hash); err != nil {
+            return Index{}, err
         }
-        return Index{}, errors.New("couldn't find url")
     }
+    return s.recovered(ind)
+}
 
-    offsets, err := os.Open(fileof(s, ind, -2))
-    if err != nil {
-        return Index{}, err
+//  `ind`, after cleaning up whatever an interrupted write left past the end of
+// it. That needs the feed locked, so it's only done if there's something to
+// clean up, and then `ind` is read again under the lock.
+func (s *jsonStore) recovered(ind Index) (Index, error) {
+    if !s.pastTail(ind) {
+        return ind, nil
     }
-    defer offsets.Close()
-    dat, err := ioutil.ReadAll(offsets)
+    ind, lock, err := s.lockIndex(ind.Hash)
     if err != nil {
         return Index{}, err
     }
-    err = json.Unmarshal(dat, &(ind.offsets))
-    if err != nil {
-        return Index{}, err
-    }
-
+    unlockFile(lock)
     return ind, nil
 }
 
+//  Whether the .xml files for `ind` have more in them than it committed (see
+// `recoverChunks()`). It might just be a write that's still going, which is
+// fine: taking the lock waits for it, and then there's nothing to cut.
+func (s *jsonStore) pastTail(ind Index) bool {
+    next := 0
+    if ind.Count > 0 {
+        stat, err := os.Stat(fileof(s, ind, ind.Count - 1))
+        if err == nil && ind.Tail > 0 && stat.Size() > ind.Tail {
+            return true
+        }
+        next = (ind.Count + 9) / 10 * 10
+    }
+    _, err := os.Stat(fileof(s, ind, next))
+    return err == nil
+}
+
 func (s *jsonStore) indexForHash(hash string) (Index, error) {
     index, err := os.Open(s.rootdir + hash + "/index.json")
     if err != nil {
@@ -227,6 +283,11 @@ func (s *jsonStore) CreateIndex(url string) (Index, error) {
     if err != nil {
         return Index{}, err
     }
+    lock, err := lockFile(s.rootdir + ".lock")
+    if err != nil {
+        return Index{}, err
+    }
+    defer unlockFile(lock)
     if s.Contains(url) {
         return Index{}, errors.New("Index already exists")
     }
@@ -235,14 +296,9 @@ func (s *jsonStore) CreateIndex(url string) (Index, error) {
     ind.Url = url
     hash := s.key(url)
     parent, err := s.indexForHash(hash)
-    if err == nil {
-        // there is a collision
-        ind.Hash = parent.Hash + "-" + strconv.Itoa(len(ind.Others))
-        if parent.Others == nil {
-            parent.Others = make(map[string]string)
-        }
-        parent.Others[url] = ind.Hash
-        s.saveIndex(parent)
+    collided := err == nil
+    if collided {
+        ind.Hash = parent.Hash + "-" + strconv.Itoa(len(parent.Others))
     } else {
         ind.Hash = hash
     }
@@ -256,9 +312,75 @@ func (s *jsonStore) CreateIndex(url string) (Index, error) {
         return Index{}, err
     }
 
+    if collided {
+        //  Only point the parent at it once it's all there, and don't clobber
+        // anything that happened to the parent since we looked.
+        parent, plock, err := s.lockIndex(parent.Hash)
+        if err != nil {
+            return Index{}, err
+        }
+        defer unlockFile(plock)
+        if parent.Others == nil {
+            parent.Others = make(map[string]string)
+        }
+        parent.Others[url] = ind.Hash
+        if err = s.saveIndex(parent); err != nil {
+            return Index{}, err
+        }
+    }
+
     return ind, nil
 }
 
+//  Lock the `Index` at `hash` against anybody else changing it, and read it
+// fresh now that nobody can. If a write to it was interrupted, anything that
+// write left behind gets cleaned up. The returned file must be passed to
+// `unlockFile()` once done.
+func (s *jsonStore) lockIndex(hash string) (Index, *os.File, error) {
+    lock, err := lockFile(s.rootdir + hash + "/.lock")
+    if err != nil {
+        return Index{}, nil, err
+    }
+    ind, err := s.indexForHash(hash)
+    if err == nil {
+        err = s.recoverChunks(ind)
+    }
+    if err != nil {
+        unlockFile(lock)
+        return Index{}, nil, err
+    }
+    return ind, lock, nil
+}
+
+//  Cut the .xml files for `ind` back to what was committed. Call with it locked.
+func (s *jsonStore) recoverChunks(ind Index) error {
+    // first item that would go in a new file
+    next := 0
+    if ind.Count > 0 {
+        last := fileof(s, ind, ind.Count - 1)
+        stat, err := os.Stat(last)
+        if err != nil {
+            return err
+        }
+        if stat.Size() < ind.Tail {
+            return errors.New(last + " is shorter than its index says")
+        } else if ind.Tail > 0 && stat.Size() > ind.Tail {
+            if err = os.Truncate(last, ind.Tail); err != nil {
+                return err
+            }
+        }
+        next = (ind.Count + 9) / 10 * 10
+    }
+    for i := next; ; i += 10 {
+        err := os.Remove(fileof(s, ind, i))
+        if os.IsNotExist(err) {
+            return nil
+        } else if err != nil {
+            return err
+        }
+    }
+}
+
 func (s *jsonStore) Get(url string, start int, end int) ([]Item, error) {
     index, err := s.indexFor(url)
     if err != nil {
@@ -292,12 +414,22 @@ func (s *jsonStore) getInd(index Index, start int, end int) ([]Item, error) {
                 return nil, err
             }
         }
-        endbyte := index.offsets[strconv.Itoa(i + 1)]
-        if endbyte == 0 {
-            endbyte = int64(len(ftxt))
+        startbyte := index.offsets[strconv.Itoa(i)]
+        var endbyte int64
+        if i == index.Count - 1 && index.Tail > 0 {
+            // there could be an unfinished write after it
+            endbyte = index.Tail
+        } else {
+            endbyte = index.offsets[strconv.Itoa(i + 1)]
+            if endbyte == 0 {
+                endbyte = int64(len(ftxt))
+            }
+        }
+        if endbyte > int64(len(ftxt)) || startbyte >= endbyte {
+            return nil, errors.New(fname + " doesn't match its offsets")
         }
         // ignore the newline we added when storing in Update()
-        itemBytes := ftxt[index.offsets[strconv.Itoa(i)] : endbyte - 1]
+        itemBytes := ftxt[startbyte : endbyte - 1]
         retval, err := MkItem(itemBytes)
         if err != nil {
             return nil, err
@@ -329,20 +461,39 @@ func (s *jsonStore) saveIndex(index Index) error {
         return err
     }
 
-    f, err := os.Create(s.rootdir + index.Hash + "/index.json")
+    //  Offsets first: until the index is swapped in, nobody looks at offsets
+    // past its `Count`.
+    err = writeFileAtomic(fileof(s, index, -2), offind)
     if err != nil {
         return err
     }
+    return writeFileAtomic(fileof(s, index, -1), serind)
+}
 
-    f.Write(serind)
-    f.Close()
-    f, err = os.Create(s.rootdir + index.Hash + "/offsets.json")
+//  Write `data` to `path` by way of a temporary file beside it, so anyone
+// reading `path` (even after a crash) gets either the old contents or the new.
+func writeFileAtomic(path string, data []byte) error {
+    tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path) + ".tmp")
     if err != nil {
         return err
     }
-    f.Write(offind)
-    f.Close()
-    return nil
+    _, err = tmp.Write(data)
+    if err == nil {
+        err = tmp.Chmod(0644)
+    }
+    if err == nil {
+        err = tmp.Sync()
+    }
+    if cerr := tmp.Close(); err == nil {
+        err = cerr
+    }
+    if err == nil {
+        err = os.Rename(tmp.Name(), path)
+    }
+    if err != nil {
+        os.Remove(tmp.Name())
+    }
+    return err
 }
 
 func (s *jsonStore) Update(url string, items []Item) error {
@@ -351,25 +502,41 @@ func (s *jsonStore) Update(url string, items []Item) error {
     if err != nil {
         return err
     }
-    // FIXME will this lead to trying to open the index? Why doesn't it?
-    lastind := ind.Count - 1
-    idx, err := os.OpenFile(fileof(s, ind, -2),
-                            os.O_APPEND | os.O_WRONLY, os.ModePerm)
+    ind, lock, err := s.lockIndex(ind.Hash)
     if err != nil {
         return err
     }
-    idx.Close()
-    storefile, err := os.OpenFile(fileof(s, ind, lastind),
-                                  os.O_APPEND | os.O_WRONLY, os.ModePerm)
-    if os.IsNotExist(err) {
-        storefile, err = os.Create(fileof(s, ind, lastind))
+    defer unlockFile(lock)
+
+    lastind := ind.Count - 1
+    var storefile *os.File
+    curPos := int64(0)
+    if lastind >= 0 {
+        storefile, err = os.OpenFile(fileof(s, ind, lastind),
+                                     os.O_APPEND | os.O_WRONLY, os.ModePerm)
+        if err != nil {
+            return err
+        }
+        stat, err := storefile.Stat()
+        if err != nil {
+            storefile.Close()
+            return err
+        }
+        curPos = stat.Size()
     }
-    if err != nil {
+    // make sure it's all on disk before the index says it's there
+    closeStore := func() error {
+        if storefile == nil {
+            return nil
+        }
+        err := storefile.Sync()
+        if cerr := storefile.Close(); err == nil {
+            err = cerr
+        }
+        storefile = nil
         return err
     }
 
-    stat, _ := storefile.Stat()
-    curPos := stat.Size()
     // keep track of guids in a set
     guids := make(map[string]bool)
     for _, g := range ind.Guids {
@@ -379,7 +546,7 @@ func (s *jsonStore) Update(url string, items []Item) error {
     for _, it := range items {
         guid, err := it.Guid()
         if err != nil {
-            storefile.Close()
+            closeStore()
             return err
         }
         if _, found := guids[guid]; found {
@@ -388,7 +555,9 @@ func (s *jsonStore) Update(url string, items []Item) error {
 
         lastind++
         if lastind % 10  == 0 {
-            storefile.Close()
+            if err = closeStore(); err != nil {
+                return err
+            }
             storefile, err = os.Create(fileof(s, ind, lastind))
             if err != nil {
                 return err
@@ -397,21 +566,26 @@ func (s *jsonStore) Update(url string, items []Item) error {
         }
         nWritten, err := storefile.WriteString(it.String() + "\n")
         if err != nil {
-            storefile.Close()
+            closeStore()
             return err
         }
         guids[guid] = true
         ind.offsets[strconv.Itoa(lastind)] = curPos
         curPos += int64(nWritten)
     }
+    if err = closeStore(); err != nil {
+        return err
+    }
     ind.Guids = make([]string, len(guids))
     i := 0
     for g, _ := range guids {
         ind.Guids[i] = g
         i++
     }
-    storefile.Close()
     ind.Count = lastind + 1
+    if ind.Count > 0 {
+        ind.Tail = curPos
+    }
     err = s.saveIndex(ind)
     if err != nil {
         return err
@@ -433,10 +607,15 @@ func (s *jsonStore) getInfo(idx Index, key string) string {
 
 func (s *jsonStore) SetInfo(url string, key string, val string) error {
     ind, err := s.indexFor(url)
-    if err == nil {
-        err = s.setInfo(ind, key, val)
+    if err != nil {
+        return err
     }
-    return err
+    ind, lock, err := s.lockIndex(ind.Hash)
+    if err != nil {
+        return err
+    }
+    defer unlockFile(lock)
+    return s.setInfo(ind, key, val)
 }
 
 func (s *jsonStore) setInfo(idx Index, key string, val string) error {
@@ -457,14 +636,19 @@ func (s *jsonStore) FeedFor(url string, ds *DateSource) (Feed, error) {
     if err != nil {
         return nil, err
     }
-    return &StoredFeed{feed, ds, idx, s}, nil
+    get := func(start, end int) ([]Item, error) {
+        return s.getInd(idx, start, end)
+    }
+    return &StoredFeed{feed, ds, idx.Count, get}, nil
 }
 
+//  A `Feed` backed by a `Store`, whichever kind. The store only has to say how
+// many items there are, and how to get them (oldest first, like `Store.Get()`).
 type StoredFeed struct {
     feed Feed
     ds *DateSource
-    idx Index
-    store *jsonStore
+    count int
+    get func(start, end int) ([]Item, error)
 }
 
 //  Some of the `StoredFeed` functions will just be pass-through to the
@@ -476,19 +660,32 @@ func (f *StoredFeed) Wrapper() []byte {
 func (f *StoredFeed) BytesWithItems(items []Item) []byte {
     return f.feed.BytesWithItems(items)
 }
+func (f *StoredFeed) WriteWithItems(w io.Writer, items []Item) error {
+    return f.feed.WriteWithItems(w, items)
+}
+func (f *StoredFeed) Info() FeedInfo {
+    return f.feed.Info()
+}
 
-//  Other functions need a bit more thought
+//  Other functions need a bit more thought. In particular, the store keeps
+// items oldest first, but a `Feed` lists them most recent first.
 
 func (f *StoredFeed) Items(start, end int) []Item {
-    ret, err := f.store.getInd(f.idx, start, end)
+    count := f.LenItems()
+    ret, err := f.get(count - end, count - start)
     if err != nil {
         panic(err.Error())
     }
+    for i := 0; i < len(ret) / 2; i++ {
+        j := len(ret) - i - 1
+        ret[i], ret[j] = ret[j], ret[i]
+    }
     return ret
 }
 
 func (f *StoredFeed) Item(idx int) Item {
-    ret, err := f.store.getInd(f.idx, idx, idx + 1)
+    count := f.LenItems()
+    ret, err := f.get(count - idx - 1, count - idx)
     if err != nil {
         panic(err.Error())
     }
@@ -496,11 +693,11 @@ func (f *StoredFeed) Item(idx int) Item {
 }
 
 func (f *StoredFeed) LenItems() int {
-    return f.idx.Count
+    return f.count
 }
 
 func (f *StoredFeed) allItems() []Item {
-    return f.Items(0, f.LenItems() - 1)
+    return f.Items(0, f.LenItems())
 }
 
 func (f *StoredFeed) appendItems(items []Item) {
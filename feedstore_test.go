@@ -2,6 +2,9 @@ package rssrerun
 
 import (
     "os"
+    "strings"
+    "strconv"
+    "sync"
     "testing"
     "time"
 
@@ -15,11 +18,67 @@ const (
     TDir = "testdat"
 )
 
-func emptyStore() Store {
+//  The tests here are run against every kind of `Store`, by swapping out what
+// `emptyStore()` makes (see `runStoreSuite()`).
+var emptyStore = emptyJSONStore
+
+//  Run the store tests (bar hash collisions, which only the `jsonStore` has)
+// with `empty` making the stores.
+func runStoreSuite(t *testing.T, empty func() Store) {
+    emptyStore = empty
+    defer func() {
+        emptyStore = emptyJSONStore
+    }()
+    tests := []struct {
+        name string
+        test func(*testing.T)
+    }{
+        {"StoreItems", TestStoreItems},
+        {"StoreAndRetrieve", TestStoreAndRetrieve},
+        {"StoreAndRetrieveMany", TestStoreAndRetrieveMany},
+        {"UpdateFile", TestUpdateFile},
+        {"MetaVals", TestMetaVals},
+        {"NoGuid", TestNoGuid},
+        {"StoredFeedTimeShift", TestStoredFeedTimeShift},
+        {"StoredFeedShiftLeavesFeed", TestStoredFeedShiftLeavesFeed},
+        {"StoreLookups", testStoreLookups},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, tt.test)
+    }
+}
+
+func TestStoreLookups(t *testing.T) {
+    testStoreLookups(t)
+}
+
+func testStoreLookups(t *testing.T) {
+    s, url, items := gimmeStore()
+    if !s.Contains(url) || s.Contains("test://nope") {
+        t.Error("Contains() is wrong")
+    }
+    if list := s.List(); len(list) != 1 || list[0] != url {
+        t.Errorf("expected just %s, got %v", url, list)
+    }
+    if _, err := s.CreateIndex(url); err == nil {
+        t.Error("shouldn't be able to create an index twice")
+    }
+    if _, err := s.Get(url, 0, len(items) + 1); err == nil {
+        t.Error("shouldn't be able to get past the end")
+    }
+    if _, err := s.Get("test://nope", 0, 1); err == nil {
+        t.Error("shouldn't be able to get from a feed that isn't there")
+    }
+    if err := s.SetInfo("test://nope", "foo", "bar"); err == nil {
+        t.Error("shouldn't be able to set info on a feed that isn't there")
+    }
+}
+
+func emptyJSONStore() Store {
     _ = os.RemoveAll(TDir + "/store")
     _ = os.Mkdir(TDir + "/store", os.ModeDir | os.ModePerm)
-    ret := NewJSONStore(TDir + "/store/")
-    ret.canon = func (url string) (string, error) {return url, nil}
+    ret := NewJSONStoreWith(TDir + "/store/",
+                            JSONStoreOptions{Canon: CanonIdentity})
     return Store(ret)
 }
 
@@ -162,6 +221,135 @@ func TestHashCollisions(t *testing.T) {
     }
 }
 
+func TestManyHashCollisions(t *testing.T) {
+    s := emptyStore()
+    s.(*jsonStore).key = func (string) string { return "hashed" }
+    _, items, _ := createItems(4, testhelp.StartDate())
+    for i := 0; i < 4; i++ {
+        url := "test://collider/" + strconv.Itoa(i)
+        if _, err := s.CreateIndex(url); err != nil {
+            t.Fatalf("creating collider %d: %s", i, err)
+        }
+        if err := s.Update(url, items[:i + 1]); err != nil {
+            t.Fatal(err)
+        }
+    }
+    for i := 0; i < 4; i++ {
+        url := "test://collider/" + strconv.Itoa(i)
+        if n := s.NumItems(url); n != i + 1 {
+            t.Errorf("%s should have %d items, has %d", url, i + 1, n)
+        }
+    }
+}
+
+//  What a crash partway through an `Update()` leaves behind: items written out,
+// but the index never saved.
+func TestUpdateRecovers(t *testing.T) {
+    s := emptyStore()
+    url := "test://testurl.whatevs"
+    itemBytes, items, _ := createItems(30, testhelp.StartDate())
+    s.CreateIndex(url)
+    if err := s.Update(url, items[:13]); err != nil {
+        t.Fatal(err)
+    }
+    js := s.(*jsonStore)
+    ind, err := js.indexFor(url)
+    if err != nil {
+        t.Fatal(err)
+    }
+    halfWrite := func() {
+        for _, chunk := range []int{13, 20} {
+            f, err := os.OpenFile(fileof(js, ind, chunk),
+                                  os.O_APPEND | os.O_CREATE | os.O_WRONLY, 0644)
+            if err != nil {
+                t.Fatal(err)
+            }
+            f.WriteString("<item><guid>half-writ")
+            f.Close()
+        }
+    }
+    halfWrite()
+
+    // readers only see what was committed, and opening the feed cleans up
+    its, err := s.Get(url, 0, 13)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !sameish(its[12], itemBytes[12]) {
+        t.Errorf("last item picked up the unfinished write: %s", its[12])
+    }
+    if stat, err := os.Stat(fileof(js, ind, 13)); err != nil ||
+            stat.Size() != ind.Tail {
+        t.Errorf("unfinished write should have been cut off the last file")
+    }
+    if _, err = os.Stat(fileof(js, ind, 20)); !os.IsNotExist(err) {
+        t.Error("unfinished write should have been cleaned up")
+    }
+
+    // and so does the next writer
+    halfWrite()
+    if err = s.Update(url, items); err != nil {
+        t.Fatal(err)
+    }
+    if _, err = os.Stat(fileof(js, ind, 30)); !os.IsNotExist(err) {
+        t.Error("unfinished write should have been cleaned up")
+    }
+    its, err = s.Get(url, 0, 30)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for i, it := range its {
+        if !sameish(it, itemBytes[i]) {
+            t.Errorf("item %d is wrong: %s", i, it)
+        }
+    }
+}
+
+//  Two stores (like two fetchers) on the same directory, one adding items and
+// the other changing metadata, shouldn't undo each other's work.
+func TestConcurrentWriters(t *testing.T) {
+    a := emptyStore()
+    b := NewJSONStoreWith(TDir + "/store/",
+                          JSONStoreOptions{Canon: CanonIdentity})
+    url := "test://testurl.whatevs"
+    _, items, _ := createItems(20, testhelp.StartDate())
+    a.CreateIndex(url)
+
+    var wg sync.WaitGroup
+    wg.Add(2)
+    go func() {
+        defer wg.Done()
+        for i := range items {
+            if err := a.Update(url, items[i:i + 1]); err != nil {
+                t.Error(err)
+                return
+            }
+        }
+    }()
+    go func() {
+        defer wg.Done()
+        for i := range items {
+            if err := b.SetInfo(url, strconv.Itoa(i), "set"); err != nil {
+                t.Error(err)
+                return
+            }
+        }
+    }()
+    wg.Wait()
+
+    if n := a.NumItems(url); n != len(items) {
+        t.Errorf("expected %d items, got %d", len(items), n)
+    }
+    if _, err := a.Get(url, 0, len(items)); err != nil {
+        t.Error(err)
+    }
+    for i := range items {
+        if val, _ := a.GetInfo(url, strconv.Itoa(i)); val != "set" {
+            t.Errorf("lost metadata %d", i)
+        }
+    }
+}
+
 func TestUpdateFile(t *testing.T) {
     s := emptyStore()
     url := "test://testurl.whatevs"
@@ -254,6 +442,48 @@ func TestStoredFeedTimeShift(t *testing.T) {
                   storedFeedTesterNewFeed)
 }
 
+func TestStoredFeedShiftLeavesFeed(t *testing.T) {
+    testShiftLeavesFeed(t, testhelp.CreateAndPopulateRSS(10, testhelp.StartDate()),
+                        storedFeedTesterNewFeed)
+}
+
+func TestStoredFeedOrder(t *testing.T) {
+    // stored oldest first, like the fetcher does, but read back newest first
+    rss := testhelp.CreateAndPopulateRSS(5, testhelp.StartDate())
+    feed, err := NewFeed(rss.Bytes(), nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    stored, err := storedFeedTesterNewFeed(rss.Bytes(), nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    guids := func(items []Item) string {
+        ret := []string{}
+        for _, it := range items {
+            g, _ := it.Guid()
+            ret = append(ret, g)
+        }
+        return strings.Join(ret, ",")
+    }
+    want := guids(feed.Items(0, 5))
+    if got := guids(stored.Items(0, 5)); got != want {
+        t.Errorf("expected %s, got %s", want, got)
+    }
+    if got, want := guids(stored.Items(1, 3)), guids(feed.Items(1, 3)); got != want {
+        t.Errorf("expected %s, got %s", want, got)
+    }
+    for i := 0; i < 5; i++ {
+        if got, want := guids([]Item{stored.Item(i)}),
+                        guids([]Item{feed.Item(i)}); got != want {
+            t.Errorf("item %d: expected %s, got %s", i, want, got)
+        }
+    }
+    if got := guids(stored.allItems()); got != want {
+        t.Errorf("expected all of %s, got %s", want, got)
+    }
+}
+
 func storedFeedTesterNewFeed(doc []byte, ds *DateSource) (Feed, error) {
     s := emptyStore()
     url := "test://testurl.whatnot"
@@ -262,7 +492,13 @@ func storedFeedTesterNewFeed(doc []byte, ds *DateSource) (Feed, error) {
         return nil, err
     }
     s.CreateIndex(url)
-    err = s.Update(url, prefeed.Items(0, prefeed.LenItems()))
+    // the store wants items oldest first
+    items := prefeed.Items(0, prefeed.LenItems())
+    for i := 0; i < len(items) / 2; i++ {
+        j := len(items) - i - 1
+        items[i], items[j] = items[j], items[i]
+    }
+    err = s.Update(url, items)
     if err != nil {
         return nil, err
     }
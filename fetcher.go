@@ -58,6 +58,20 @@ func SelectFeedFetcher(url string) (FeedFunc, error) {
     if err != nil {
         return nil, err
     }
+    dat, err = ToUTF8(dat, resp.Header.Get("Content-Type"))
+    if err != nil {
+        return nil, err
+    }
+    if looksLikeJSON(dat) {
+        // a JSON Feed can only tell us about its next page
+        feed, err := NewFeed(dat, nil)
+        if err == nil {
+            if _, err = nextSelfLink(feed, ""); err == nil {
+                return FeedSelfLinking, nil
+            }
+        }
+        return nil, FetcherDetectFailed
+    }
     doc, err := gokogiri.ParseXml(dat)
     if err != nil {
         return nil, err
@@ -161,6 +175,10 @@ func bytesFromUrlWithBackoff(url string, delay int64) ([]byte, int64, error) {
         }
         if resp.StatusCode < 400 {
             dat, _ := ioutil.ReadAll(resp.Body)
+            dat, err = ToUTF8(dat, resp.Header.Get("Content-Type"))
+            if err != nil {
+                return nil, -1, err
+            }
             return dat, delay, nil
         } else if resp.StatusCode == 429 {
             // back off like a chump
@@ -349,6 +367,13 @@ func FeedSelfLinking(url string) (Feed, error) {
 func nextSelfLink(f Feed, url string) (string, error) {
     // look for a channel/atom:link with rel=next
     // (also, try for bare channel/link if that fails)
+    if jf, ok := f.(*JSONFeed); ok {
+        // JSON Feed makes it easy
+        if next := jsonString(jf.top, "next_url"); len(next) > 0 {
+            return next, nil
+        }
+        return "", errors.New("no next_url in JSON Feed")
+    }
     doc, err := gokogiri.ParseXml(f.Wrapper())
     if err != nil {
         return "", err
@@ -0,0 +1,57 @@
+package rssrerun
+
+import (
+    "regexp"
+    "strings"
+    "time"
+)
+
+//  Which items a rerun leaves out, like trailers, bonus episodes and reposts.
+// Left out items aren't scheduled at all, so the rerun only counts the ones
+// that are in. The zero `Filter` leaves everything in.
+type Filter struct {
+    // episode types to leave out, eg "trailer" and "bonus" (see `EpisodeType()`)
+    SkipTypes []string
+    // leave out items whose title or description match
+    SkipTitle, SkipDescription *regexp.Regexp
+    //  leave out items shorter than `MinDuration` or longer than `MaxDuration`
+    // (if they're set). Items that don't say how long they are stay in.
+    MinDuration, MaxDuration time.Duration
+    // leave out items with nothing to listen to (or watch)
+    NeedEnclosure bool
+}
+
+// Does the rerun include `item`?
+func (f *Filter) Includes(item Item) bool {
+    if f == nil {
+        return true
+    }
+    if len(f.SkipTypes) > 0 {
+        typ := item.EpisodeType()
+        for _, skip := range f.SkipTypes {
+            if strings.EqualFold(typ, skip) {
+                return false
+            }
+        }
+    }
+    if f.SkipTitle != nil || f.SkipDescription != nil || f.NeedEnclosure {
+        r := item.Render()
+        if f.SkipTitle != nil && f.SkipTitle.MatchString(r.Title) {
+            return false
+        }
+        if f.SkipDescription != nil && f.SkipDescription.MatchString(r.Description) {
+            return false
+        }
+        if f.NeedEnclosure && len(r.Enclosure) == 0 {
+            return false
+        }
+    }
+    if f.MinDuration > 0 || f.MaxDuration > 0 {
+        if d, err := item.Duration(); err == nil {
+            if d < f.MinDuration || (f.MaxDuration > 0 && d > f.MaxDuration) {
+                return false
+            }
+        }
+    }
+    return true
+}
@@ -0,0 +1,157 @@
+package rssrerun
+
+import (
+    "regexp"
+    "testing"
+    "time"
+
+    "github.com/patrickyeon/rssrerun/testhelp"
+)
+
+func TestParseItunesDuration(t *testing.T) {
+    durations := map[string]time.Duration{
+        "3600": time.Hour,
+        "90.5": 90 * time.Second + 500 * time.Millisecond,
+        "45:30": 45 * time.Minute + 30 * time.Second,
+        "1:02:03": time.Hour + 2 * time.Minute + 3 * time.Second,
+        " 01:00:00 ": time.Hour,
+    }
+    for s, want := range durations {
+        if got, err := parseItunesDuration(s); err != nil || got != want {
+            t.Errorf("\"%s\" should be %v, got %v (%v)", s, want, got, err)
+        }
+    }
+    for _, s := range []string{"", "an hour", "1:2:3:4", "-5"} {
+        if got, err := parseItunesDuration(s); err == nil {
+            t.Errorf("\"%s\" shouldn't parse, got %v", s, got)
+        }
+    }
+}
+
+//  A feed with a bit of everything, newest first: a trailer, a
+// repost, a short update, an episode with no audio, and the real episodes.
+func filterFeed() *testhelp.RSS {
+    rss := testhelp.CreateAndPopulateRSS(0, testhelp.StartDate())
+    enc := "<enclosure url=\"foo://bar.mp3\" length=\"1\" type=\"audio/mpeg\"/>"
+    rss.AddPost(episodeItem("trailer", 0, 0, 9,
+                            enc + "<itunes:episodeType>trailer</itunes:episodeType>"))
+    rss.AddPost(episodeItem("ep4", 0, 0, 8,
+                            enc + "<itunes:duration>45:00</itunes:duration>"))
+    rss.AddPost(episodeItem("repost", 0, 0, 7, enc +
+                            "<description>[REPOST] from the archives</description>"))
+    rss.AddPost(episodeItem("update", 0, 0, 6,
+                            enc + "<itunes:duration>90</itunes:duration>"))
+    rss.AddPost(episodeItem("ep3", 0, 0, 4,
+                            enc + "<itunes:duration>3600</itunes:duration>"))
+    rss.AddPost(episodeItem("blog", 0, 0, 3, ""))
+    rss.AddPost(episodeItem("ep2", 0, 0, 2, enc))
+    rss.AddPost(episodeItem("ep1", 0, 0, 0,
+                            enc + "<itunes:duration>1:30:00</itunes:duration>"))
+    return rss
+}
+
+func TestFilterIncludes(t *testing.T) {
+    feed, err := NewFeed(filterFeed().Bytes(), nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    filters := map[string]*Filter{
+        "none": nil,
+        "types": &Filter{SkipTypes: []string{"Trailer", "bonus"}},
+        "desc": &Filter{SkipDescription: regexp.MustCompile(`(?i)\brepost\b`)},
+        "title": &Filter{SkipTitle: regexp.MustCompile(`^ep`)},
+        "short": &Filter{MinDuration: 5 * time.Minute},
+        "long": &Filter{MaxDuration: time.Hour},
+        "enclosure": &Filter{NeedEnclosure: true},
+    }
+    left := map[string]string{
+        "none": "",
+        "types": "trailer",
+        "desc": "repost",
+        "title": "ep4 ep3 ep2 ep1",
+        "short": "update",
+        "long": "ep1",
+        "enclosure": "blog",
+    }
+    for name, f := range filters {
+        out := ""
+        for _, item := range feed.Items(0, feed.LenItems()) {
+            if !f.Includes(item) {
+                guid, _ := item.Guid()
+                if len(out) > 0 {
+                    out += " "
+                }
+                out += guid
+            }
+        }
+        if out != left[name] {
+            t.Errorf("filter %s should leave out \"%s\", left out \"%s\"", name,
+                     left[name], out)
+        }
+    }
+}
+
+func TestFilteredRerun(t *testing.T) {
+    rerun := NewDateSource(testhelp.StartDate().AddDate(1, 0, 0),
+                           []time.Weekday{time.Monday})
+    rerun.Filter = &Filter{SkipTypes: []string{"trailer"},
+                           SkipDescription: regexp.MustCompile(`REPOST`),
+                           MinDuration: 5 * time.Minute,
+                           NeedEnclosure: true}
+    feed, err := NewFeed(filterFeed().Bytes(), rerun)
+    if err != nil {
+        t.Fatal(err)
+    }
+    // 3 Mondays in, we're on the 3rd included episode
+    items, err := feed.ShiftedAt(10, rerun.StartDate.AddDate(0, 0, 21))
+    if err != nil {
+        t.Fatal(err)
+    }
+    want := []string{"ep3", "ep2", "ep1"}
+    if len(items) != len(want) {
+        t.Fatalf("expected %d items, got %d", len(want), len(items))
+    }
+    for i, guid := range want {
+        if got, _ := items[i].Guid(); got != guid {
+            t.Errorf("item %d should be %s, got %s", i, guid, got)
+        }
+    }
+    // without a trip to the store for every item it checks
+    stored, calls := countingStoredFeed(feed, rerun)
+    items, err = stored.ShiftedAt(1, rerun.StartDate.AddDate(0, 0, 21))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if got, _ := items[0].Guid(); got != "ep3" {
+        t.Errorf("stored feed should be on ep3, got %s", got)
+    }
+    if *calls > 2 {
+        t.Errorf("went to the store %d times for one item", *calls)
+    }
+
+    // and it stops after the last one that's in
+    items, err = feed.ShiftedAt(1, rerun.StartDate.AddDate(1, 0, 0))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if got, _ := items[0].Guid(); len(items) != 1 || got != "ep4" {
+        t.Errorf("expected to end on ep4, got %s", got)
+    }
+
+    //  the same goes for original cadence mode, where ep3 was 4 weeks after
+    // ep1, and ep4 another 4 after that
+    rerun.Speed = 1
+    items, err = feed.ShiftedAt(10, rerun.StartDate.AddDate(0, 0, 29))
+    if err != nil {
+        t.Fatal(err)
+    }
+    want = []string{"ep3", "ep2", "ep1"}
+    if len(items) != len(want) {
+        t.Fatalf("expected %d items, got %d", len(want), len(items))
+    }
+    for i, guid := range want {
+        if got, _ := items[i].Guid(); got != guid {
+            t.Errorf("item %d should be %s, got %s", i, guid, got)
+        }
+    }
+}
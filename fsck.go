@@ -0,0 +1,514 @@
+package rssrerun
+
+import (
+    "bytes"
+    "encoding/json"
+    "encoding/xml"
+    "errors"
+    "io/ioutil"
+    "os"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+//  Checking that a `jsonStore` is all in one piece, like fsck(8) does for a
+// filesystem. What index.json committed is taken to be the truth: its `Count`
+// and `Tail` say where the last committed item ends, anything after that in
+// the .xml files was left by a write that never finished, and offsets.json and
+// the guids can be worked out again from the items before it. Only an index
+// from before `Tail` was kept has to take the .xml files' word for how many
+// items there are. An index.json that can't be read at all is left alone,
+// since the feed's url and metadata are only in there.
+
+// Something wrong with a feed in a `jsonStore`, or with one of its items.
+type StoreProblem struct {
+    // the subdirectory the feed is in
+    Hash string
+    // which item (counting from the oldest), or -1 for the whole feed
+    Item int
+    Message string
+    // whether `Check()` fixed it
+    Fixed bool
+}
+
+func (p StoreProblem) String() string {
+    ret := p.Hash + ": "
+    if p.Item >= 0 {
+        ret += "item " + strconv.Itoa(p.Item) + ": "
+    }
+    ret += p.Message
+    if p.Fixed {
+        ret += " (fixed)"
+    }
+    return ret
+}
+
+//  Go through every feed in the store, checking that index.json, offsets.json
+// and the .xml files agree, that every stored item still parses, and that every
+// url that collided with another can still be found. With `repair`, anything
+// that can be is put right (see `truncateIndex()` and `rebuildIndex()`). Feeds
+// are locked while they're looked at, so it's safe to run with fetchers going.
+func (s *jsonStore) Check(repair bool) ([]StoreProblem, error) {
+    lock, err := lockFile(s.rootdir + ".lock")
+    if err != nil {
+        return nil, err
+    }
+    defer unlockFile(lock)
+    root, err := os.Open(s.rootdir)
+    if err != nil {
+        return nil, err
+    }
+    entries, err := root.Readdir(0)
+    root.Close()
+    if err != nil {
+        return nil, err
+    }
+    hashes := []string{}
+    for _, fi := range entries {
+        if fi.IsDir() {
+            hashes = append(hashes, fi.Name())
+        }
+    }
+    sort.Strings(hashes)
+
+    problems := []StoreProblem{}
+    feeds := make(map[string]Index)
+    for _, hash := range hashes {
+        ind, ok, probs := s.checkFeed(hash, repair)
+        problems = append(problems, probs...)
+        if ok {
+            feeds[hash] = ind
+        }
+    }
+    return append(problems, s.checkCollisions(hashes, feeds, repair)...), nil
+}
+
+// what's actually in a feed's .xml files
+type storedItems struct {
+    offsets map[string]int64
+    // the guid of each item, in order
+    guids []string
+    // end of the last whole item in the last file
+    tail int64
+    // the last file, and where in it the items stop, if there's junk after them
+    lastFile string
+    cut int64
+}
+
+//  Check the feed in the `hash` subdirectory, repairing it if asked to and if
+// there's nothing wrong with the .xml files themselves. Returns its `Index`
+// (and whether there is one) for `checkCollisions()`.
+func (s *jsonStore) checkFeed(hash string,
+                              repair bool) (Index, bool, []StoreProblem) {
+    problems := []StoreProblem{}
+    report := func(item int, fixable bool, msg string) {
+        problems = append(problems, StoreProblem{hash, item, msg, fixable})
+    }
+    dir := s.rootdir + hash + "/"
+    lock, err := lockFile(dir + ".lock")
+    if err != nil {
+        report(-1, false, err.Error())
+        return Index{}, false, problems
+    }
+    defer unlockFile(lock)
+
+    ind := Index{}
+    text, err := ioutil.ReadFile(dir + "index.json")
+    if err == nil {
+        err = json.Unmarshal(text, &ind)
+    }
+    if err != nil {
+        report(-1, false, "can't read index: " + err.Error())
+        return Index{}, false, problems
+    }
+    if ind.Hash != hash {
+        report(-1, true, "index says it's in " + ind.Hash)
+        ind.Hash = hash
+    }
+    text, err = ioutil.ReadFile(dir + "offsets.json")
+    if err == nil {
+        err = json.Unmarshal(text, &ind.offsets)
+    }
+    haveOffsets := err == nil
+    if !haveOffsets {
+        report(-1, true, "can't read offsets: " + err.Error())
+    }
+
+    // whether there's a `Tail` to say where the committed items end
+    committed := ind.Tail > 0 && ind.Count > 0
+    stored, sound := s.readItems(ind, report)
+    if len(stored.lastFile) > 0 {
+        report(-1, true, "unfinished item at byte " +
+               strconv.FormatInt(stored.cut, 10) + " of " + stored.lastFile)
+    }
+
+    count := len(stored.guids)
+    if count != ind.Count {
+        // committed items that have gone missing can't be got back
+        report(-1, !committed, "index has " + strconv.Itoa(ind.Count) +
+               " items, but " + strconv.Itoa(count) + " are stored")
+    }
+    for i := 0; haveOffsets && i < count && i < ind.Count; i++ {
+        key := strconv.Itoa(i)
+        if at, ok := ind.offsets[key]; !ok {
+            report(i, true, "isn't in offsets.json")
+        } else if at != stored.offsets[key] {
+            report(i, true, "offsets.json has it at byte " +
+                   strconv.FormatInt(at, 10) + ", but it's at " +
+                   strconv.FormatInt(stored.offsets[key], 10))
+        }
+    }
+    indexed := make(map[string]bool)
+    for _, g := range ind.Guids {
+        indexed[g] = true
+    }
+    missing := 0
+    for _, g := range stored.guids {
+        if !indexed[g] {
+            missing++
+        }
+        delete(indexed, g)
+    }
+    if missing > 0 {
+        report(-1, true, strconv.Itoa(missing) +
+               " stored items' guids aren't in the index")
+    }
+    if len(indexed) > 0 {
+        report(-1, true, "index has " + strconv.Itoa(len(indexed)) +
+               " guids for items that aren't stored")
+    }
+
+    fixed := repair && sound && (!committed || count == ind.Count)
+    if fixed && len(problems) > 0 {
+        if committed {
+            err = s.truncateIndex(&ind, stored)
+        } else {
+            err = s.rebuildIndex(&ind, stored)
+        }
+        if err != nil {
+            report(-1, false, "couldn't repair: " + err.Error())
+            fixed = false
+        }
+    }
+    for i := range problems {
+        problems[i].Fixed = problems[i].Fixed && fixed
+    }
+    return ind, true, append(problems, s.checkStrays(ind, repair)...)
+}
+
+//  Read the items back out of the .xml files for `ind`, reporting anything
+// wrong with them. If it's nothing that can be fixed, says so. If `ind` has a
+// `Tail`, only the items it committed are read, and anything after them is
+// reported for `truncateIndex()` to cut off.
+func (s *jsonStore) readItems(ind Index,
+                              report func(int, bool, string)) (storedItems, bool) {
+    stored := storedItems{offsets: make(map[string]int64)}
+    sound := true
+    seen := make(map[string]int)
+    committed := ind.Tail > 0 && ind.Count > 0
+    for file := 0; ; file++ {
+        fname := fileof(s, ind, file * 10)
+        text, err := ioutil.ReadFile(fname)
+        if os.IsNotExist(err) {
+            return stored, sound
+        } else if err != nil {
+            report(-1, false, err.Error())
+            return stored, false
+        }
+        var last bool
+        if committed {
+            last = file == (ind.Count - 1) / 10
+        } else {
+            _, err = os.Stat(fileof(s, ind, (file + 1) * 10))
+            last = os.IsNotExist(err)
+        }
+        if committed && last && int64(len(text)) != ind.Tail {
+            // anything past it was never committed, but too little is lost
+            report(-1, int64(len(text)) > ind.Tail,
+                   "index says " + fname + " is " +
+                   strconv.FormatInt(ind.Tail, 10) + " bytes, but it's " +
+                   strconv.Itoa(len(text)))
+            if int64(len(text)) > ind.Tail {
+                text = text[:ind.Tail]
+            }
+        }
+
+        starts, end, err := splitChunk(text)
+        if err != nil {
+            if !last || committed {
+                report(len(stored.guids) + len(starts), false,
+                       err.Error() + " in " + fname)
+                return stored, false
+            }
+            stored.lastFile, stored.cut = fname, end
+        }
+        if len(starts) > 10 || (!last && len(starts) < 10) {
+            report(-1, false, fname + " has " + strconv.Itoa(len(starts)) +
+                   " items, should have 10")
+            return stored, false
+        }
+        for i, start := range starts {
+            n := len(stored.guids)
+            stop := end
+            if i + 1 < len(starts) {
+                stop = starts[i + 1]
+            }
+            // without the newline `Update()` put after it
+            it, err := MkItem(text[start : stop - 1])
+            if err != nil {
+                report(n, false, "doesn't parse: " + err.Error())
+                sound = false
+                stored.guids = append(stored.guids, "")
+                continue
+            }
+            guid, err := it.Guid()
+            if err != nil {
+                report(n, false, "no guid: " + err.Error())
+                sound = false
+            } else if prev, ok := seen[guid]; ok {
+                report(n, false, "same guid as item " + strconv.Itoa(prev))
+                sound = false
+            }
+            seen[guid] = n
+            stored.offsets[strconv.Itoa(n)] = start
+            stored.guids = append(stored.guids, guid)
+        }
+        if len(starts) > 0 {
+            stored.tail = end
+        }
+        if last {
+            if len(starts) == 0 {
+                // there shouldn't be a file at all
+                stored.lastFile, stored.cut = fname, 0
+            }
+            return stored, sound
+        }
+    }
+}
+
+//  Split the text of an .xml file back up into the items `Update()` wrote to
+// it, each followed by a newline. Returns where each one starts, and where the
+// last of them ends; if there's an error, what comes after that isn't a whole
+// item.
+func splitChunk(text []byte) ([]int64, int64, error) {
+    starts := []int64{}
+    pos := 0
+    for pos < len(text) {
+        n, err := itemLength(text[pos:])
+        if err == nil && (pos + n >= len(text) || text[pos + n] != '\n') {
+            err = errors.New("item with no newline after it")
+        }
+        if err != nil {
+            return starts, int64(pos), err
+        }
+        starts = append(starts, int64(pos))
+        pos += n + 1
+    }
+    return starts, int64(pos), nil
+}
+
+// how long the item at the start of `t` is
+func itemLength(t []byte) (int, error) {
+    if looksLikeJSON(t) {
+        // these are stored compact, so they're all on one line
+        n := bytes.IndexByte(t, '\n')
+        if n < 0 {
+            return 0, errors.New("unfinished item")
+        }
+        return n, nil
+    }
+    dec := xml.NewDecoder(bytes.NewReader(t))
+    dec.Strict = false
+    depth := 0
+    for {
+        tok, err := dec.RawToken()
+        if err != nil {
+            return 0, errors.New("unfinished item")
+        }
+        switch tok.(type) {
+        case xml.StartElement:
+            depth++
+        case xml.EndElement:
+            depth--
+            if depth == 0 {
+                return int(dec.InputOffset()), nil
+            }
+        }
+    }
+}
+
+//  Cut the .xml files back to the items `ind` committed, as `recoverChunks()`
+// does, make the rest of `ind` describe those items, and save it. Call with
+// the feed locked.
+func (s *jsonStore) truncateIndex(ind *Index, stored storedItems) error {
+    if err := s.recoverChunks(*ind); err != nil {
+        return err
+    }
+    ind.offsets = stored.offsets
+    ind.Guids = stored.guids
+    return s.saveIndex(*ind)
+}
+
+//  For an index without a `Tail`, make `ind` say what's really in the .xml
+// files, cutting off anything that isn't a whole item, and save it. Call with
+// the feed locked.
+func (s *jsonStore) rebuildIndex(ind *Index, stored storedItems) error {
+    if len(stored.lastFile) > 0 {
+        var err error
+        if stored.cut == 0 {
+            err = os.Remove(stored.lastFile)
+        } else {
+            err = os.Truncate(stored.lastFile, stored.cut)
+        }
+        if err != nil {
+            return err
+        }
+    }
+    ind.Count = len(stored.guids)
+    ind.offsets = stored.offsets
+    ind.Guids = stored.guids
+    ind.Tail = 0
+    if ind.Count > 0 {
+        ind.Tail = stored.tail
+    }
+    return s.saveIndex(*ind)
+}
+
+//  Anything in the feed's directory that shouldn't be. Leftover temporary files
+// from `writeFileAtomic()`, and .xml files past what an index with a `Tail`
+// committed, get cleaned up on `repair`; anything else is left for somebody to
+// look at.
+func (s *jsonStore) checkStrays(ind Index, repair bool) []StoreProblem {
+    problems := []StoreProblem{}
+    dir, err := os.Open(s.rootdir + ind.Hash)
+    if err != nil {
+        return problems
+    }
+    names, _ := dir.Readdirnames(0)
+    dir.Close()
+    sort.Strings(names)
+    for _, name := range names {
+        switch {
+        case name == "index.json" || name == "offsets.json" || name == ".lock":
+            continue
+        case strings.HasSuffix(name, ".xml"):
+            n, err := strconv.Atoi(strings.TrimSuffix(name, ".xml"))
+            if err == nil && n >= 0 && (n == 0 || n * 10 < ind.Count) {
+                continue
+            }
+            if err == nil && n > 0 && ind.Tail > 0 && ind.Count > 0 {
+                fixed := repair && os.Remove(s.rootdir + ind.Hash + "/" + name) == nil
+                problems = append(problems, StoreProblem{ind.Hash, -1,
+                                  name + " is past the last committed item",
+                                  fixed})
+                continue
+            }
+        case strings.HasPrefix(name, "index.json.tmp") ||
+             strings.HasPrefix(name, "offsets.json.tmp"):
+            fixed := repair && os.Remove(s.rootdir + ind.Hash + "/" + name) == nil
+            problems = append(problems, StoreProblem{ind.Hash, -1,
+                              "leftover temporary file " + name, fixed})
+            continue
+        }
+        problems = append(problems, StoreProblem{ind.Hash, -1,
+                          "doesn't belong here: " + name, false})
+    }
+    return problems
+}
+
+//  Check that every url can be found: either it's in the directory its key
+// says, or that directory's `Others` points to where it is. On `repair`, the
+// `Others` are made to say where everything really is.
+func (s *jsonStore) checkCollisions(hashes []string, feeds map[string]Index,
+                                    repair bool) []StoreProblem {
+    problems := []StoreProblem{}
+    where := make(map[string]string)
+    // the `Others` for each parent, as they should be
+    others := make(map[string]map[string]string)
+    for _, hash := range hashes {
+        ind, ok := feeds[hash]
+        if !ok {
+            continue
+        }
+        if prev, ok := where[ind.Url]; ok {
+            problems = append(problems, StoreProblem{hash, -1,
+                              "same url as " + prev + ": " + ind.Url, false})
+            continue
+        }
+        where[ind.Url] = hash
+        if s.key(ind.Url) == hash {
+            others[hash] = make(map[string]string)
+        }
+    }
+
+    for _, hash := range hashes {
+        ind, ok := feeds[hash]
+        if !ok || where[ind.Url] != hash {
+            continue
+        }
+        parent := s.key(ind.Url)
+        if parent == hash {
+            urls := []string{}
+            for url := range ind.Others {
+                urls = append(urls, url)
+            }
+            sort.Strings(urls)
+            for _, url := range urls {
+                if where[url] != ind.Others[url] || s.key(url) != hash {
+                    problems = append(problems, StoreProblem{hash, -1,
+                                      url + " isn't in " + ind.Others[url],
+                                      true})
+                }
+            }
+            continue
+        }
+        if len(ind.Others) > 0 {
+            problems = append(problems, StoreProblem{hash, -1,
+                              "has collisions, but isn't where its url goes",
+                              false})
+        }
+        if _, ok := others[parent]; !ok {
+            problems = append(problems, StoreProblem{hash, -1,
+                              "can't be found, there's nothing in " + parent,
+                              false})
+            continue
+        }
+        others[parent][ind.Url] = hash
+        if feeds[parent].Others[ind.Url] != hash {
+            problems = append(problems, StoreProblem{hash, -1,
+                              "can't be found from " + parent, true})
+        }
+    }
+
+    // which parents need saving
+    fixed := make(map[string]bool)
+    for parent, want := range others {
+        have := feeds[parent].Others
+        same := len(have) == len(want)
+        for url, hash := range want {
+            same = same && have[url] == hash
+        }
+        if same || !repair {
+            continue
+        }
+        ind, lock, err := s.lockIndex(parent)
+        if err == nil {
+            ind.Others = want
+            err = s.saveIndex(ind)
+            unlockFile(lock)
+        }
+        fixed[parent] = err == nil
+    }
+    for i, p := range problems {
+        if p.Fixed {
+            ind := feeds[p.Hash]
+            parent := p.Hash
+            if s.key(ind.Url) != p.Hash {
+                parent = s.key(ind.Url)
+            }
+            problems[i].Fixed = fixed[parent]
+        }
+    }
+    return problems
+}
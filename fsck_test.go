@@ -0,0 +1,232 @@
+package rssrerun
+
+import (
+    "io/ioutil"
+    "os"
+    "strings"
+    "testing"
+
+    "github.com/patrickyeon/rssrerun/testhelp"
+)
+
+func checkStore(t *testing.T, s Store, repair bool) []StoreProblem {
+    problems, err := s.(*jsonStore).Check(repair)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return problems
+}
+
+func expectClean(t *testing.T, s Store, when string) {
+    for _, p := range checkStore(t, s, false) {
+        t.Errorf("%s: %s", when, p)
+    }
+}
+
+func TestCheckClean(t *testing.T) {
+    s, _, _ := gimmeStore()
+    expectClean(t, s, "fresh store")
+
+    s = emptyStore()
+    s.(*jsonStore).key = func (string) string { return "hashed" }
+    _, items, _ := createItems(12, testhelp.StartDate())
+    for _, url := range []string{"test://a", "test://b", "test://c"} {
+        s.CreateIndex(url)
+        if err := s.Update(url, items); err != nil {
+            t.Fatal(err)
+        }
+    }
+    expectClean(t, s, "collisions")
+}
+
+func appendTo(t *testing.T, fname string, text string) {
+    f, err := os.OpenFile(fname, os.O_APPEND | os.O_CREATE | os.O_WRONLY, 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    f.WriteString(text)
+    f.Close()
+}
+
+func expectRepaired(t *testing.T, s Store, atLeast int) {
+    problems := checkStore(t, s, false)
+    if len(problems) < atLeast {
+        t.Errorf("expected at least %d problems, got %v", atLeast, problems)
+    }
+    for _, p := range problems {
+        if p.Fixed {
+            t.Errorf("shouldn't have fixed anything without repair: %s", p)
+        }
+    }
+    problems = checkStore(t, s, true)
+    for _, p := range problems {
+        if !p.Fixed {
+            t.Errorf("should have fixed %s", p)
+        }
+    }
+    expectClean(t, s, "repaired")
+}
+
+func expectItems(t *testing.T, s Store, url string, itemBytes [][]byte) {
+    if n := s.NumItems(url); n != len(itemBytes) {
+        t.Fatalf("expected %d items after repair, got %d", len(itemBytes), n)
+    }
+    its, err := s.Get(url, 0, len(itemBytes))
+    if err != nil {
+        t.Fatal(err)
+    }
+    for i, it := range its {
+        if !sameish(it, itemBytes[i]) {
+            t.Errorf("item %d is wrong after repair: %s", i, it)
+        }
+    }
+}
+
+func TestCheckRepairs(t *testing.T) {
+    s, url, itemBytes := gimmeStore()
+    js := s.(*jsonStore)
+    ind, err := js.indexFor(url)
+    if err != nil {
+        t.Fatal(err)
+    }
+    //  Everything that can be worked out from the committed items is lost, and
+    // a write that never finished left more items after them. (Only `Check()`
+    // can look at it now, since opening the feed would clean up.)
+    ind.Guids = ind.Guids[1:]
+    if err = js.saveIndex(ind); err != nil {
+        t.Fatal(err)
+    }
+    if err = os.Remove(fileof(js, ind, -2)); err != nil {
+        t.Fatal(err)
+    }
+    appendTo(t, fileof(js, ind, 24), "<item><guid>uncommitted</guid></item>\n" +
+                                     "<item><title>unfinished")
+    appendTo(t, fileof(js, ind, 30), "<item><guid>also uncommitted</guid></item>\n")
+
+    expectRepaired(t, s, 3)
+    // what was committed, and nothing else
+    expectItems(t, s, url, itemBytes)
+    if stat, err := os.Stat(fileof(js, ind, 24)); err != nil ||
+            stat.Size() != ind.Tail {
+        t.Error("last file should have been cut back to its tail")
+    }
+    if _, err = os.Stat(fileof(js, ind, 30)); !os.IsNotExist(err) {
+        t.Error("uncommitted file should have been removed")
+    }
+}
+
+func TestCheckLostItems(t *testing.T) {
+    s, url, _ := gimmeStore()
+    js := s.(*jsonStore)
+    ind, err := js.indexFor(url)
+    if err != nil {
+        t.Fatal(err)
+    }
+    // committed items that are gone can't be made up
+    if err = os.Remove(fileof(js, ind, 24)); err != nil {
+        t.Fatal(err)
+    }
+    problems := checkStore(t, s, true)
+    if len(problems) == 0 {
+        t.Fatal("expected the missing items to be reported")
+    }
+    for _, p := range problems {
+        if p.Fixed {
+            t.Errorf("shouldn't have fixed %s", p)
+        }
+    }
+    if ind, err = js.indexForHash(ind.Hash); err != nil || ind.Count != 25 {
+        t.Errorf("index shouldn't have changed, got %d items", ind.Count)
+    }
+}
+
+func TestCheckRebuildsLegacy(t *testing.T) {
+    s, url, itemBytes := gimmeStore()
+    js := s.(*jsonStore)
+    ind, err := js.indexFor(url)
+    if err != nil {
+        t.Fatal(err)
+    }
+    //  From before the tail was kept, so the .xml files are all there is to go
+    // on for how many items there are.
+    ind.Count = 20
+    ind.Guids = ind.Guids[1:]
+    ind.Tail = 0
+    if err = js.saveIndex(ind); err != nil {
+        t.Fatal(err)
+    }
+    if err = os.Remove(fileof(js, ind, -2)); err != nil {
+        t.Fatal(err)
+    }
+    appendTo(t, fileof(js, ind, 24), "<item><title>unfinished")
+
+    expectRepaired(t, s, 4)
+    expectItems(t, s, url, itemBytes)
+}
+
+func TestCheckBadItems(t *testing.T) {
+    s, url, _ := gimmeStore()
+    js := s.(*jsonStore)
+    ind, _ := js.indexFor(url)
+    // a whole item, but not one that can be a feed item
+    fname := fileof(js, ind, 0)
+    text, err := ioutil.ReadFile(fname)
+    if err != nil {
+        t.Fatal(err)
+    }
+    text = []byte(strings.Replace(string(text), "<item>", "<itam>", 1))
+    text = []byte(strings.Replace(string(text), "</item>", "</itam>", 1))
+    if err = ioutil.WriteFile(fname, text, 0644); err != nil {
+        t.Fatal(err)
+    }
+
+    problems := checkStore(t, s, true)
+    found := false
+    for _, p := range problems {
+        if p.Fixed {
+            t.Errorf("can't fix a bad item: %s", p)
+        }
+        found = found || p.Item == 0
+    }
+    if !found {
+        t.Errorf("item 0 should have been reported, got %v", problems)
+    }
+}
+
+func TestCheckCollisionChain(t *testing.T) {
+    s := emptyStore()
+    js := s.(*jsonStore)
+    js.key = func (string) string { return "hashed" }
+    for _, url := range []string{"test://a", "test://b", "test://c"} {
+        if _, err := s.CreateIndex(url); err != nil {
+            t.Fatal(err)
+        }
+    }
+    parent, err := js.indexForHash("hashed")
+    if err != nil {
+        t.Fatal(err)
+    }
+    lost := parent.Others["test://b"]
+    delete(parent.Others, "test://b")
+    parent.Others["test://gone"] = "hashed-9"
+    if err = js.saveIndex(parent); err != nil {
+        t.Fatal(err)
+    }
+    if s.Contains("test://b") {
+        t.Fatal("test://b shouldn't be reachable any more")
+    }
+
+    problems := checkStore(t, s, true)
+    if len(problems) != 2 {
+        t.Errorf("expected 2 problems, got %v", problems)
+    }
+    for _, p := range problems {
+        if !p.Fixed {
+            t.Errorf("should have fixed %s", p)
+        }
+    }
+    expectClean(t, s, "repaired")
+    if ind, err := js.indexFor("test://b"); err != nil || ind.Hash != lost {
+        t.Errorf("test://b should be back in %s", lost)
+    }
+}
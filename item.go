@@ -2,6 +2,9 @@ package rssrerun
 
 import (
     "errors"
+    "html"
+    "regexp"
+    "strings"
     "time"
 
     "github.com/jbowtie/gokogiri"
@@ -18,30 +21,54 @@ type Item interface {
     SetPubDate(date time.Time) (error)
     // get the guid, or make one up
     Guid() (string, error)
+    // change the guid (so that a replayed item looks new)
+    SetGuid(guid string) error
+    // a deep copy, that can be changed without touching the original
+    Clone() Item
+    //  when the item was first published, if it's been rerun (see
+    // `SetOriginalPubDate()`)
+    OriginalPubDate() (time.Time, error)
+    // keep track of when the item was first published, in its own tag
+    SetOriginalPubDate(date time.Time) error
+    // put `note` at the start of the item's description
+    AddNote(note string) error
     // render the item as a string
     String() string
-    // the actual, parsed, xml.Node of the document
+    //  the actual, parsed, xml.Node of the document (or nil, for items that
+    // aren't XML, like JSON Feed's)
     Node() xml.Node
     // Do our best to get a representation of an Item that can be displayed
     Render() RenderItem
+    // the podcast season the item is in (see podcast.go)
+    Season() (int, error)
+    // its episode number, which can be fractional
+    Episode() (float64, error)
+    // "full", "trailer" or "bonus"
+    EpisodeType() string
+    // how long the episode is
+    Duration() (time.Duration, error)
 }
 
+//  The namespace for tags we add to items, like <rerun:originalPubDate>.
+const RerunNamespace = "https://github.com/patrickyeon/rssrerun/ns/1.0"
+
 type RenderItem struct {
-    PubDate, Title, Description, Guid, Url, Enclosure string
+    PubDate, Title, Description, Guid, Url, Author string
+    Enclosure, EnclosureType, EnclosureLength string
+    Season, Episode, EpisodeType string
 }
 
 type RssItem struct {
     src xml.Node
 }
 
+//  Any of the ways RSS items are dated, in the order we'd trust them. Plenty of
+// feeds get the case of <pubDate> wrong.
+var rssDateTags = []string{"pubDate", "pubdate", "PubDate", "PUBDATE",
+                           dcXpath("date"), atomXpath("updated")}
+
 func (item *RssItem) PubDate() (time.Time, error) {
-    for _, str := range []string{"pubDate", "pubdate", "PubDate", "PUBDATE"} {
-        d, err := item.src.Search(str)
-        if err == nil && len(d) > 0 {
-            return parseDate(d[0].Content())
-        }
-    }
-    return zeroDate(), errors.New("no pubdate")
+    return firstDate(item.src, rssDateTags)
 }
 
 func (item *RssItem) SetPubDate(date time.Time) (error) {
@@ -50,9 +77,19 @@ func (item *RssItem) SetPubDate(date time.Time) (error) {
         return err
     }
     if len(pdtag) == 0 {
-        return errors.New("no pubdate tag")
+        // dated some other way, which is what we'll keep up
+        if dctag, err := getChild(item.src, dcXpath("date")); err == nil {
+            return dctag.SetContent(date.Format(time.RFC3339))
+        }
+        newTag := item.src.MyDocument().CreateElementNode("pubDate")
+        if err = item.src.AddChild(newTag); err != nil {
+            return err
+        }
+        pdtag = []xml.Node{newTag}
     }
-    pdtag[0].SetContent(date.Format(time.RFC822))
+    //  RFC822 only has names for a handful of zones, but any zone can be
+    // written out as UTC.
+    pdtag[0].SetContent(date.UTC().Format(time.RFC822))
     return nil
 }
 
@@ -72,6 +109,41 @@ func (item *RssItem) Guid() (string, error) {
     return title + " - " + link, nil
 }
 
+func (item *RssItem) SetGuid(guid string) error {
+    tag, err := item.src.Search("guid")
+    if err != nil {
+        return err
+    }
+    if len(tag) == 0 {
+        newTag := item.src.MyDocument().CreateElementNode("guid")
+        if err = item.src.AddChild(newTag); err != nil {
+            return err
+        }
+        tag = []xml.Node{newTag}
+    }
+    // whatever it was before, it's not a link anymore
+    tag[0].SetAttr("isPermaLink", "false")
+    return tag[0].SetContent(guid)
+}
+
+func (item *RssItem) OriginalPubDate() (time.Time, error) {
+    return originalPubDate(item.src)
+}
+
+func (item *RssItem) SetOriginalPubDate(date time.Time) error {
+    return setOriginalPubDate(item.src, date)
+}
+
+func (item *RssItem) AddNote(note string) error {
+    // RSS doesn't say, but a description with tags in it is going to be HTML
+    isHtml := htmlTag.MatchString(tryContent(item.src, "description"))
+    return prependContent(item.src, "description", notePrefix(note, isHtml))
+}
+
+func (item *RssItem) Clone() Item {
+    return &RssItem{item.src.Duplicate(1)}
+}
+
 func (item *RssItem) String() string {
     return item.src.String()
 }
@@ -90,35 +162,62 @@ func (item *RssItem) Render() RenderItem {
             titletxt = titletxt[0:147] + "..."
         }
     }
+    season, episode, episodeType := renderEpisode(item)
     return RenderItem{
-        pubDate.Format("2006-02-01"),
+        pubDate.Format("2006-01-02"),
         titletxt,
         tryContent(item.src, "description"),
         tryContent(item.src, "guid"),
         tryContent(item.src, "link"),
+        item.author(),
         tryAttr(item.src, "enclosure", "url"),
+        tryAttr(item.src, "enclosure", "type"),
+        tryAttr(item.src, "enclosure", "length"),
+        season,
+        episode,
+        episodeType,
     }
 }
 
+//  <author> is supposed to be an email address, so plenty of feeds use
+// <dc:creator> for a name instead.
+func (item *RssItem) author() string {
+    if author := tryContent(item.src, "author"); len(author) > 0 {
+        return author
+    }
+    return tryContent(item.src, dcXpath("creator"))
+}
+
 
 type AtomItem struct {
     src xml.Node
 }
 
+//  `published` is optional in Atom, but `updated` isn't, so an entry that was
+// never updated might only have that.
+var atomDateTags = []string{xpath("published"), xpath("updated"),
+                            dcXpath("date")}
+
 func (item *AtomItem) PubDate() (time.Time, error) {
-    published, err := getChild(item.src, xpath("published"))
-    if err != nil {
-        return zeroDate(), err
-    }
-    return parseDate(published.Content())
+    return firstDate(item.src, atomDateTags)
 }
 
+//  Sets `published`, and `updated` as well (a rerun is as fresh as it gets).
+// If there's neither, `published` is added.
 func (item *AtomItem) SetPubDate(date time.Time) error {
-    published, err := getChild(item.src, xpath("published"))
-    if err != nil {
-        return err
+    found := false
+    for _, tag := range []string{xpath("published"), xpath("updated")} {
+        if node, err := getChild(item.src, tag); err == nil {
+            found = true
+            if err = node.SetContent(date.Format(time.RFC3339)); err != nil {
+                return err
+            }
+        }
     }
-    return published.SetContent(date.Format(time.RFC822))
+    if found {
+        return nil
+    }
+    return prependContent(item.src, "published", date.Format(time.RFC3339))
 }
 
 func (item *AtomItem) Guid() (string, error) {
@@ -129,6 +228,46 @@ func (item *AtomItem) Guid() (string, error) {
     return id.Content(), nil
 }
 
+func (item *AtomItem) SetGuid(guid string) error {
+    id, err := getChild(item.src, xpath("id"))
+    if err != nil {
+        id = item.src.MyDocument().CreateElementNode("id")
+        if err = item.src.AddChild(id); err != nil {
+            return err
+        }
+    }
+    return id.SetContent(guid)
+}
+
+func (item *AtomItem) OriginalPubDate() (time.Time, error) {
+    return originalPubDate(item.src)
+}
+
+func (item *AtomItem) SetOriginalPubDate(date time.Time) error {
+    return setOriginalPubDate(item.src, date)
+}
+
+func (item *AtomItem) AddNote(note string) error {
+    // the summary if there is one, otherwise the content unless it's markup
+    if summary, err := getChild(item.src, xpath("summary")); err == nil {
+        prefix := notePrefix(note, summary.Attr("type") == "html")
+        return summary.SetContent(prefix + summary.Content())
+    }
+    content, err := getChild(item.src, xpath("content"))
+    if err != nil {
+        return prependContent(item.src, "summary", note)
+    }
+    if typ := content.Attr("type"); typ == "xhtml" {
+        return errors.New("can't add a note to xhtml content")
+    }
+    prefix := notePrefix(note, content.Attr("type") == "html")
+    return content.SetContent(prefix + content.Content())
+}
+
+func (item *AtomItem) Clone() Item {
+    return &AtomItem{item.src.Duplicate(1)}
+}
+
 func (item *AtomItem) String() string {
     return item.src.String()
 }
@@ -139,44 +278,73 @@ func (item *AtomItem) Node() xml.Node {
 
 func (item *AtomItem) Render() RenderItem {
     pubDate, _ := item.PubDate()
-    desc := tryContent(item.src, "content")
+    desc := tryContent(item.src, xpath("content"))
     if len(desc) == 0 {
-        desc = tryContent(item.src, "summary")
-    }
-    id := tryContent(item.src, "id")
-    enclosure := ""
-    encTags, err := item.src.Search("link")
-    if err == nil && len(encTags) > 0 {
-        for _, tag := range encTags {
-            rel, found := tag.Attributes()["rel"]
-            if found && rel.Value() == "enclosure" {
-                enclosure = tag.Attributes()["href"].Value()
-                break
+        desc = tryContent(item.src, xpath("summary"))
+    }
+    id := tryContent(item.src, xpath("id"))
+    // the entry's own page, if it has one, otherwise its id
+    url := id
+    enclosure, enclosureType, enclosureLength := "", "", ""
+    links, err := item.src.Search(xpath("link"))
+    if err == nil {
+        for _, tag := range links {
+            switch tag.Attr("rel") {
+            case "", "alternate":
+                if url == id {
+                    url = tag.Attr("href")
+                }
+            case "enclosure":
+                if len(enclosure) == 0 {
+                    enclosure = tag.Attr("href")
+                    enclosureType = tag.Attr("type")
+                    enclosureLength = tag.Attr("length")
+                }
             }
         }
     }
+    season, episode, episodeType := renderEpisode(item)
     return RenderItem{
-        pubDate.Format("2006-02-01"),
-        tryContent(item.src, "title"),
+        pubDate.Format("2006-01-02"),
+        tryContent(item.src, xpath("title")),
         desc,
         id,
-        id,
+        url,
+        tryContent(item.src, xpath("author") + "/" + xpath("name")),
         enclosure,
+        enclosureType,
+        enclosureLength,
+        season,
+        episode,
+        episodeType,
     }
 }
 
-func parseDate(s string) (time.Time, error) {
-    for _, typ := range(dateTypes) {
-        date, err := time.Parse(typ, s)
-        if err == nil {
+func xpath(s string) string {
+    return "*[local-name()='" + s + "']"
+}
+
+// the xpath to `tag` in the Atom namespace, for when it shows up in RSS
+func atomXpath(tag string) string {
+    return ("*[local-name()='" + tag + "' and namespace-uri()='" +
+            atomNamespace + "']")
+}
+
+//  The date from the first of `tags` under `node` that has one we can make
+// sense of.
+func firstDate(node xml.Node, tags []string) (time.Time, error) {
+    err := errors.New("no pubdate")
+    for _, tag := range tags {
+        found, serr := node.Search(tag)
+        if serr != nil || len(found) == 0 {
+            continue
+        }
+        var date time.Time
+        if date, err = parseDate(found[0].Content()); err == nil {
             return date, nil
         }
     }
-    return zeroDate(), errors.New("invalid date format")
-}
-
-func xpath(s string) string {
-    return "*[local-name()='" + s + "']"
+    return zeroDate(), err
 }
 
 func getChild(parent xml.Node, tagName string) (xml.Node, error) {
@@ -190,14 +358,25 @@ func getChild(parent xml.Node, tagName string) (xml.Node, error) {
     return ret[0], nil
 }
 
-// Given an array of bytes, parse them as an RSS item
+// Given an array of bytes, parse them as an RSS item (or Atom, RDF, JSON Feed)
 func MkItem(s []byte) (Item, error) {
+    if looksLikeJSON(s) {
+        m := make(map[string]interface{})
+        if err := decodeJSON(s, &m); err != nil {
+            return nil, err
+        }
+        return &JSONItem{m}, nil
+    }
     it, err := gokogiri.ParseXml(s)
     if err != nil {
         return nil, err
     }
     switch (it.Root().Name()) {
     case "item":
+        // RSS 1.0 items are namespaced, RSS 2.0 items are not
+        if it.Root().Namespace() == rss1Namespace {
+            return &RdfItem{it.Root()}, nil
+        }
         return &RssItem{it.Root()}, nil
     case "entry":
         return &AtomItem{it.Root()}, nil
@@ -211,6 +390,61 @@ func zeroDate() time.Time {
     return time.Date(0, 0, 0, 0, 0, 0, 0, time.UTC)
 }
 
+// the xpath to our own `tag` (see `RerunNamespace`)
+func rerunXpath(tag string) string {
+    return ("*[local-name()='" + tag + "' and namespace-uri()='" +
+            RerunNamespace + "']")
+}
+
+func originalPubDate(node xml.Node) (time.Time, error) {
+    tag, err := getChild(node, rerunXpath("originalPubDate"))
+    if err != nil {
+        return zeroDate(), err
+    }
+    return time.Parse(time.RFC3339, tag.Content())
+}
+
+func setOriginalPubDate(node xml.Node, date time.Time) error {
+    tag, err := getChild(node, rerunXpath("originalPubDate"))
+    if err != nil {
+        newTag := node.MyDocument().CreateElementNode("originalPubDate")
+        if err = node.AddChild(newTag); err != nil {
+            return err
+        }
+        // declares the namespace here, unless it already is further up
+        newTag.SetNamespace("rerun", RerunNamespace)
+        tag = newTag
+    }
+    return tag.SetContent(date.Format(time.RFC3339))
+}
+
+//  Put `s` at the start of the text of the `tagname` child of `node`, making
+// that child if it doesn't exist.
+//  Something that looks like an HTML tag, opening or closing.
+var htmlTag = regexp.MustCompile(`</?[a-zA-Z][^<>]*>`)
+
+//  What to put ahead of content to add `note` to it: for HTML, the note goes in
+// its own paragraph (as JSONItem.AddNote does), so it isn't run together with
+// whatever comes first.
+func notePrefix(note string, isHtml bool) string {
+    if !isHtml {
+        return note
+    }
+    return "<p>" + html.EscapeString(strings.TrimSpace(note)) + "</p>"
+}
+
+func prependContent(node xml.Node, tagname string, s string) error {
+    tag, err := getChild(node, xpath(tagname))
+    if err != nil {
+        newTag := node.MyDocument().CreateElementNode(tagname)
+        if err = node.AddChild(newTag); err != nil {
+            return err
+        }
+        tag = newTag
+    }
+    return tag.SetContent(s + tag.Content())
+}
+
 func tryContent(node xml.Node, tagname string) string {
     // return the text content of that tag if it exists, "" if it doesn't
     tag, err := node.Search(tagname)
@@ -0,0 +1,406 @@
+package rssrerun
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "html"
+    "io"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/jbowtie/gokogiri/xml"
+)
+
+//  JSON Feed (https://jsonfeed.org/version/1.1) is simple enough that we keep
+// feeds and items as plain maps. That way whatever else is in them, like
+// extensions we've never heard of, comes back out untouched.
+
+const jsonFeedVersion = "https://jsonfeed.org/version/1.1"
+
+type JSONFeed struct {
+    // everything in the feed except for the items
+    top map[string]interface{}
+    items []Item
+    d *DateSource
+}
+
+type JSONItem struct {
+    src map[string]interface{}
+}
+
+// Is `t` (probably) JSON, and not XML?
+func looksLikeJSON(t []byte) bool {
+    t = bytes.TrimLeft(t, "\xef\xbb\xbf \t\r\n")
+    return len(t) > 0 && t[0] == '{'
+}
+
+//  Numbers are kept as `json.Number`s, so that they come back out exactly as
+// they went in.
+func decodeJSON(t []byte, v interface{}) error {
+    dec := json.NewDecoder(bytes.NewReader(t))
+    dec.UseNumber()
+    return dec.Decode(v)
+}
+
+func encodeJSON(v interface{}) ([]byte, error) {
+    var buf bytes.Buffer
+    enc := json.NewEncoder(&buf)
+    // no need to turn every < into \u003c, we're not going in a <script>
+    enc.SetEscapeHTML(false)
+    if err := enc.Encode(v); err != nil {
+        return nil, err
+    }
+    return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
+
+// Try to parse `t` as a JSON Feed.
+func newJSONFeedRaw(t []byte) (*JSONFeed, error) {
+    top := make(map[string]interface{})
+    if err := decodeJSON(t, &top); err != nil {
+        return nil, err
+    }
+    version, _ := top["version"].(string)
+    if !strings.HasPrefix(version, "https://jsonfeed.org/version/") {
+        return nil, errors.New("no JSON Feed version")
+    }
+    f := &JSONFeed{top: top}
+    raw, _ := top["items"].([]interface{})
+    f.items = make([]Item, len(raw))
+    for i, it := range raw {
+        m, ok := it.(map[string]interface{})
+        if !ok {
+            return nil, errors.New("JSON Feed item is not an object")
+        }
+        f.items[i] = &JSONItem{m}
+    }
+    delete(top, "items")
+    return f, nil
+}
+
+//  Make an empty JSON Feed out of `info`, that items from any other kind of
+// feed can be put in (see `BytesWithItems()`).
+func NewJSONFeedFrom(info FeedInfo) *JSONFeed {
+    top := map[string]interface{}{"version": jsonFeedVersion}
+    setIfAny(top, "title", info.Title)
+    setIfAny(top, "home_page_url", info.Link)
+    setIfAny(top, "description", info.Description)
+    return &JSONFeed{top: top}
+}
+
+//  Render `items` as a JSON Feed, keeping what we can of `f`. If `f` is
+// already a JSON Feed, that's just `f.BytesWithItems(items)`.
+func ToJSONFeed(f Feed, items []Item) []byte {
+    jf, ok := f.(*JSONFeed)
+    if !ok {
+        jf = NewJSONFeedFrom(f.Info())
+    }
+    return jf.BytesWithItems(items)
+}
+
+func (f *JSONFeed) Info() FeedInfo {
+    return FeedInfo{
+        jsonString(f.top, "title"),
+        jsonString(f.top, "home_page_url"),
+        jsonString(f.top, "description"),
+        "",
+    }
+}
+
+func (f *JSONFeed) Wrapper() []byte {
+    return f.BytesWithItems([]Item{})
+}
+
+//  Unlike RSS and Atom, `items` can come from any kind of feed. Ones that
+// aren't from a JSON Feed are converted as best we can, and any that can't be
+// (eg, no guid) are left out.
+func (f *JSONFeed) BytesWithItems(items []Item) []byte {
+    return bytesWithItems(f, items)
+}
+
+//  Written out a member at a time (in order, as `encoding/json` would) and an
+// item at a time, so there's never more than one item's worth in memory.
+func (f *JSONFeed) WriteWithItems(w io.Writer, items []Item) error {
+    keys := make([]string, 0, len(f.top) + 1)
+    for k := range f.top {
+        keys = append(keys, k)
+    }
+    keys = append(keys, "items")
+    sort.Strings(keys)
+    if _, err := io.WriteString(w, "{"); err != nil {
+        return err
+    }
+    for i, k := range keys {
+        key, err := encodeJSON(k)
+        if err != nil {
+            return err
+        }
+        if i > 0 {
+            key = append([]byte(","), key...)
+        }
+        if _, err = w.Write(append(key, ':')); err != nil {
+            return err
+        }
+        if k == "items" {
+            err = writeJSONItems(w, items)
+        } else {
+            err = writeJSON(w, f.top[k])
+        }
+        if err != nil {
+            return err
+        }
+    }
+    _, err := io.WriteString(w, "}")
+    return err
+}
+
+func writeJSONItems(w io.Writer, items []Item) error {
+    if _, err := io.WriteString(w, "["); err != nil {
+        return err
+    }
+    first := true
+    for _, item := range items {
+        m, err := jsonItemMap(item)
+        if err != nil {
+            continue
+        }
+        if !first {
+            if _, err = io.WriteString(w, ","); err != nil {
+                return err
+            }
+        }
+        first = false
+        if err = writeJSON(w, m); err != nil {
+            return err
+        }
+    }
+    _, err := io.WriteString(w, "]")
+    return err
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+    text, err := encodeJSON(v)
+    if err != nil {
+        return err
+    }
+    _, err = w.Write(text)
+    return err
+}
+
+func (f *JSONFeed) Items(start, end int) []Item {
+    return f.items[start:end]
+}
+
+func (f *JSONFeed) Item(idx int) Item {
+    return f.items[idx]
+}
+
+func (f *JSONFeed) LenItems() int {
+    return len(f.items)
+}
+
+func (f *JSONFeed) ShiftedAt(n int, t time.Time) ([]Item, error) {
+    return univShiftedAt(n, t, f, f.d)
+}
+
+func (f *JSONFeed) allItems() []Item {
+    return f.items
+}
+
+func (f *JSONFeed) appendItems(items []Item) {
+    f.items = append(f.items, items...)
+}
+
+//  The JSON Feed version of `item`, converting it from another kind of feed
+// if need be.
+func jsonItemMap(item Item) (map[string]interface{}, error) {
+    if it, ok := item.(*JSONItem); ok {
+        return it.src, nil
+    }
+    guid, err := item.Guid()
+    if err != nil {
+        return nil, err
+    }
+    m := map[string]interface{}{"id": guid}
+    if pd, err := item.PubDate(); err == nil {
+        m["date_published"] = pd.Format(time.RFC3339)
+    }
+    r := item.Render()
+    setIfAny(m, "title", r.Title)
+    setIfAny(m, "url", r.Url)
+    // RSS and Atom descriptions are (almost) always HTML
+    m["content_html"] = r.Description
+    if len(r.Enclosure) > 0 {
+        attachment := map[string]interface{}{"url": r.Enclosure}
+        // JSON Feed insists on a type, so make a vague one up if we must
+        attachment["mime_type"] = "application/octet-stream"
+        setIfAny(attachment, "mime_type", r.EnclosureType)
+        if size, err := strconv.ParseInt(r.EnclosureLength, 10, 64); err == nil {
+            attachment["size_in_bytes"] = size
+        }
+        m["attachments"] = []interface{}{attachment}
+    }
+    if len(r.Author) > 0 {
+        m["authors"] = []interface{}{map[string]interface{}{"name": r.Author}}
+    }
+    // same as `JSONItem.Season()` etc expect
+    itunes := map[string]interface{}{}
+    setIfAny(itunes, "season", r.Season)
+    setIfAny(itunes, "episode", r.Episode)
+    if r.EpisodeType != "full" {
+        // which is what it's taken to be if it doesn't say
+        setIfAny(itunes, "episode_type", r.EpisodeType)
+    }
+    if len(itunes) > 0 {
+        m["_itunes"] = itunes
+    }
+    if orig, err := item.OriginalPubDate(); err == nil {
+        m["_rerun"] = map[string]interface{}{
+            "original_date_published": orig.Format(time.RFC3339),
+        }
+    }
+    return m, nil
+}
+
+func setIfAny(m map[string]interface{}, key, val string) {
+    if len(val) > 0 {
+        m[key] = val
+    }
+}
+
+//  The string at `key` in `m`, or "" if it's not there. Numbers count, since
+// some feeds use them for ids.
+func jsonString(m map[string]interface{}, key string) string {
+    switch v := m[key].(type) {
+    case string:
+        return v
+    case json.Number:
+        return v.String()
+    }
+    return ""
+}
+
+func (item *JSONItem) PubDate() (time.Time, error) {
+    pd := jsonString(item.src, "date_published")
+    if len(pd) == 0 {
+        return zeroDate(), errors.New("no date_published")
+    }
+    return parseDate(pd)
+}
+
+func (item *JSONItem) SetPubDate(date time.Time) error {
+    item.src["date_published"] = date.Format(time.RFC3339)
+    return nil
+}
+
+func (item *JSONItem) Guid() (string, error) {
+    id := jsonString(item.src, "id")
+    if len(id) == 0 {
+        return "", errors.New("no id")
+    }
+    return id, nil
+}
+
+func (item *JSONItem) SetGuid(guid string) error {
+    item.src["id"] = guid
+    return nil
+}
+
+func (item *JSONItem) Clone() Item {
+    // easiest way to make sure nothing is shared
+    b, err := encodeJSON(item.src)
+    if err != nil {
+        panic(err.Error())
+    }
+    m := make(map[string]interface{})
+    if err = decodeJSON(b, &m); err != nil {
+        panic(err.Error())
+    }
+    return &JSONItem{m}
+}
+
+//  Our own extension object (as JSON Feed asks for) is `_rerun`, like
+// `<rerun:originalPubDate>` in RSS and Atom.
+func (item *JSONItem) OriginalPubDate() (time.Time, error) {
+    ext, ok := item.src["_rerun"].(map[string]interface{})
+    if !ok {
+        return zeroDate(), errors.New("no _rerun extension")
+    }
+    return time.Parse(time.RFC3339, jsonString(ext, "original_date_published"))
+}
+
+func (item *JSONItem) SetOriginalPubDate(date time.Time) error {
+    ext, ok := item.src["_rerun"].(map[string]interface{})
+    if !ok {
+        ext = make(map[string]interface{})
+        item.src["_rerun"] = ext
+    }
+    ext["original_date_published"] = date.Format(time.RFC3339)
+    return nil
+}
+
+func (item *JSONItem) AddNote(note string) error {
+    text, hasText := item.src["content_text"].(string)
+    markup, hasHtml := item.src["content_html"].(string)
+    if hasText || !hasHtml {
+        item.src["content_text"] = note + text
+    }
+    if hasHtml {
+        escaped := html.EscapeString(strings.TrimSpace(note))
+        item.src["content_html"] = "<p>" + escaped + "</p>" + markup
+    }
+    return nil
+}
+
+func (item *JSONItem) String() string {
+    b, err := encodeJSON(item.src)
+    if err != nil {
+        return ""
+    }
+    return string(b)
+}
+
+// JSON Feed items aren't XML, so there is no node
+func (item *JSONItem) Node() xml.Node {
+    return nil
+}
+
+func (item *JSONItem) Render() RenderItem {
+    pubDate, _ := item.PubDate()
+    desc := jsonString(item.src, "content_html")
+    if len(desc) == 0 {
+        desc = jsonString(item.src, "content_text")
+    }
+    enclosure, enclosureType, enclosureLength := "", "", ""
+    if attachments, ok := item.src["attachments"].([]interface{}); ok {
+        if len(attachments) > 0 {
+            if a, ok := attachments[0].(map[string]interface{}); ok {
+                enclosure = jsonString(a, "url")
+                enclosureType = jsonString(a, "mime_type")
+                enclosureLength = jsonString(a, "size_in_bytes")
+            }
+        }
+    }
+    // 1.1 has a list of `authors`, 1.0 had just the one `author`
+    author, _ := item.src["author"].(map[string]interface{})
+    if authors, ok := item.src["authors"].([]interface{}); ok && len(authors) > 0 {
+        author, _ = authors[0].(map[string]interface{})
+    }
+    season, episode, episodeType := renderEpisode(item)
+    return RenderItem{
+        pubDate.Format("2006-01-02"),
+        jsonString(item.src, "title"),
+        desc,
+        jsonString(item.src, "id"),
+        jsonString(item.src, "url"),
+        jsonString(author, "name"),
+        enclosure,
+        enclosureType,
+        enclosureLength,
+        season,
+        episode,
+        episodeType,
+    }
+}
@@ -0,0 +1,161 @@
+package rssrerun
+
+import (
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/patrickyeon/rssrerun/testhelp"
+)
+
+func TestJSONGuids(t *testing.T) {
+    testGuids(t, testhelp.CreateAndPopulateJSON(10, testhelp.StartDate()))
+}
+
+func TestJSONTimeShift(t *testing.T) {
+    testTimeShift(t, testhelp.CreateAndPopulateJSON(10, testhelp.StartDate()),
+                  NewFeed)
+}
+
+func TestJSONLatestFive(t *testing.T) {
+    json := testhelp.CreateAndPopulateJSON(100, testhelp.StartDate().AddDate(-3, 0, 0))
+    testLatestFive(t, json)
+}
+
+func TestJSONShiftLeavesFeed(t *testing.T) {
+    testShiftLeavesFeed(t, testhelp.CreateAndPopulateJSON(10, testhelp.StartDate()),
+                        NewFeed)
+}
+
+func TestJSONEndLoop(t *testing.T) {
+    testEndLoop(t, testhelp.CreateAndPopulateJSON(3, testhelp.StartDate()))
+}
+
+func TestStoredJSONTimeShift(t *testing.T) {
+    testTimeShift(t, testhelp.CreateAndPopulateJSON(10, testhelp.StartDate()),
+                  storedFeedTesterNewFeed)
+}
+
+func TestJSONKeepsExtras(t *testing.T) {
+    json := testhelp.CreateAndPopulateJSON(0, testhelp.StartDate())
+    json.AddPost(`{"id": 12, "date_published": "2015-04-12T01:00:00Z",
+                   "content_html": "<p>hi</p>", "_custom": {"size": 123456789012},
+                   "attachments": [{"url": "foo://bar.mp3",
+                                    "mime_type": "audio/mpeg"}]}`)
+    rerun := NewDateSource(testhelp.StartDate().AddDate(1, 0, 0),
+                           []time.Weekday{time.Monday})
+    feed, err := NewFeed(json.Bytes(), rerun)
+    if err != nil {
+        t.Fatal(err)
+    }
+    items, err := feed.ShiftedAt(1, rerun.StartDate.AddDate(0, 0, 7))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(items) != 1 {
+        t.Fatalf("expected 1 item, got %d", len(items))
+    }
+    if g, _ := items[0].Guid(); g != "12" {
+        t.Errorf("expected guid 12, got %s", g)
+    }
+    out := string(feed.BytesWithItems(items))
+    for _, s := range []string{`"_custom":{"size":123456789012}`,
+                               `"content_html":"<p>hi</p>"`,
+                               `"original_date_published":"2015-04-12T01:00:00Z"`,
+                               `"home_page_url":"http://example.com"`} {
+        if !strings.Contains(out, s) {
+            t.Errorf("expected %s in the output: %s", s, out)
+        }
+    }
+    if r := items[0].Render(); r.Enclosure != "foo://bar.mp3" ||
+                               r.EnclosureType != "audio/mpeg" {
+        t.Errorf("enclosure didn't render: %v", r)
+    }
+}
+
+func TestJSONNote(t *testing.T) {
+    rerun := NewDateSource(testhelp.StartDate().AddDate(1, 0, 0),
+                           []time.Weekday{time.Monday})
+    rerun.NoteOriginal = true
+    feed, err := NewFeed(testhelp.CreateAndPopulateJSON(5,
+                                                      testhelp.StartDate()).Bytes(),
+                         rerun)
+    if err != nil {
+        t.Fatal(err)
+    }
+    items, err := feed.ShiftedAt(1, rerun.StartDate.AddDate(0, 0, 7))
+    if err != nil {
+        t.Fatal(err)
+    }
+    note := "Originally published on April 12, 2015."
+    if desc := items[0].Render().Description; !strings.HasPrefix(desc, note) {
+        t.Errorf("expected the description to start with \"%s\": %s", note, desc)
+    }
+}
+
+func TestToJSONFeed(t *testing.T) {
+    rss := testhelp.CreateAndPopulateRSS(3, testhelp.StartDate())
+    rss.AddPost("<item><title>with enclosure</title><guid>0</guid>" +
+                "<enclosure url=\"foo://bar.mp3\" type=\"audio/mpeg\"/></item>")
+    src, err := NewFeed(rss.Bytes(), nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    text := ToJSONFeed(src, src.Items(0, src.LenItems()))
+    if strings.Contains(string(text), "_itunes") {
+        t.Errorf("nothing to put in _itunes, but it's there: %s", text)
+    }
+    converted, err := NewFeed(text, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, ok := converted.(*JSONFeed); !ok {
+        t.Fatal("didn't convert to a JSON Feed")
+    }
+    if info := converted.Info(); info != src.Info() {
+        t.Errorf("feed info should be %v, got %v", src.Info(), info)
+    }
+    if converted.LenItems() != src.LenItems() {
+        t.Fatalf("expected %d items, got %d", src.LenItems(),
+                 converted.LenItems())
+    }
+    for i := 0; i < src.LenItems(); i++ {
+        want, got := src.Item(i), converted.Item(i)
+        wantGuid, _ := want.Guid()
+        gotGuid, _ := got.Guid()
+        if wantGuid != gotGuid {
+            t.Errorf("item %d should have guid %s, got %s", i, wantGuid, gotGuid)
+        }
+        wantDate, wantErr := want.PubDate()
+        gotDate, _ := got.PubDate()
+        if wantErr == nil && !wantDate.Equal(gotDate) {
+            t.Errorf("item %d should be dated %v, got %v", i, wantDate, gotDate)
+        }
+        if w, g := want.Render().PubDate, got.Render().PubDate; w != g {
+            t.Errorf("item %d should render dated %s, got %s", i, w, g)
+        }
+    }
+    last := converted.Item(src.LenItems() - 1).Render()
+    if last.Enclosure != "foo://bar.mp3" || last.EnclosureType != "audio/mpeg" {
+        t.Errorf("enclosure didn't convert: %v", last)
+    }
+}
+
+func TestJSONPubDateFormats(t *testing.T) {
+    want := time.Date(2015, time.March, 19, 9, 30, 0, 0, time.UTC)
+    for _, pd := range []string{"2015-03-19T09:30:00Z",
+                                "2015-03-19T10:30:00+01:00",
+                                "2015-03-19T09:30:00",
+                                "Thu, 19 Mar 2015 09:30:00 GMT"} {
+        item, err := MkItem([]byte(`{"id": "1", "date_published": "` + pd + `"}`))
+        if err != nil {
+            t.Fatal(err)
+        }
+        if got, err := item.PubDate(); err != nil || !got.Equal(want) {
+            t.Errorf("%s should be %v, got %v (%v)", pd, want, got, err)
+        }
+        if r := item.Render(); r.PubDate != "2015-03-19" {
+            t.Errorf("%s should render as 2015-03-19, got %s", pd, r.PubDate)
+        }
+    }
+}
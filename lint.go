@@ -0,0 +1,181 @@
+package rssrerun
+
+import (
+    "strconv"
+    "time"
+
+    "github.com/jbowtie/gokogiri"
+)
+
+//  Finding out why a feed doesn't rerun well. Everything is checked with the
+// same `Item` accessors the rerun itself uses, so if the linter can't find an
+// item's guid or date, neither can the rerun.
+
+type LintLevel int
+
+const (
+    //  Something that makes for a worse rerun, but it will still work, eg items
+    // that aren't newest first.
+    LintWarning LintLevel = iota
+    // Something the rerun can't work around, eg items with no guid.
+    LintError
+)
+
+func (l LintLevel) String() string {
+    if l == LintError {
+        return "error"
+    }
+    return "warning"
+}
+
+// Something wrong with a feed, or with one of its items.
+type LintProblem struct {
+    Level LintLevel
+    // which item it is (counting from the top of the feed), or -1 for the feed
+    Item int
+    Message string
+}
+
+func (p LintProblem) String() string {
+    if p.Item < 0 {
+        return p.Level.String() + ": feed: " + p.Message
+    }
+    return p.Level.String() + ": item " + strconv.Itoa(p.Item) + ": " + p.Message
+}
+
+//  Everything wrong with `f`: the wrapper, and items with no guid (or only one
+// made up from their other tags), the same guid as another item, no date (or
+// one we can't parse), or that are out of order. If the feed looks like a
+// podcast (some items have an enclosure), any items that don't have one are
+// pointed out as well.
+func Lint(f Feed) []LintProblem {
+    return append(lintWrapper(f.Wrapper()), lintFeed(f)...)
+}
+
+//  Like `Lint()`, but for a feed that hasn't been parsed yet. Some problems
+// (like an RSS feed with two `<channel>`s) stop `NewFeed()` from parsing a feed
+// at all, and this can still say what they are.
+func LintBytes(t []byte) []LintProblem {
+    t, err := ToUTF8(t, "")
+    if err != nil {
+        return []LintProblem{{LintError, -1, err.Error()}}
+    }
+    problems := lintWrapper(t)
+    f, err := NewFeed(t, nil)
+    if err != nil {
+        if len(problems) == 0 {
+            problems = append(problems, LintProblem{LintError, -1, err.Error()})
+        }
+        return problems
+    }
+    return append(problems, lintFeed(f)...)
+}
+
+// problems with the feed apart from its items
+func lintWrapper(wrap []byte) []LintProblem {
+    if looksLikeJSON(wrap) {
+        // anything that's wrong with a JSON Feed's wrapper stops it parsing
+        return []LintProblem{}
+    }
+    doc, err := gokogiri.ParseXml(wrap)
+    if err != nil {
+        return []LintProblem{{LintError, -1, "not XML: " + err.Error()}}
+    }
+    root := doc.Root()
+    if root == nil {
+        return []LintProblem{{LintError, -1, "empty document"}}
+    }
+    switch root.Name() {
+    case "feed":
+        return []LintProblem{}
+    case "rss", "RDF":
+        channels, err := root.Search(xpath("channel"))
+        if err != nil {
+            return []LintProblem{{LintError, -1, err.Error()}}
+        }
+        if len(channels) == 0 {
+            return []LintProblem{{LintError, -1, "no <channel>"}}
+        }
+        if len(channels) > 1 {
+            return []LintProblem{{LintError, -1, strconv.Itoa(len(channels)) +
+                                                 " <channel>s, should only be one"}}
+        }
+        return []LintProblem{}
+    }
+    return []LintProblem{{LintError, -1, "<" + root.Name() + "> isn't RSS or Atom"}}
+}
+
+func lintFeed(f Feed) []LintProblem {
+    problems := []LintProblem{}
+    if len(f.Info().Title) == 0 {
+        problems = append(problems, LintProblem{LintWarning, -1, "no title"})
+    }
+    items := f.Items(0, f.LenItems())
+    if len(items) == 0 {
+        problems = append(problems, LintProblem{LintWarning, -1, "no items"})
+    }
+
+    podcast := false
+    for _, item := range items {
+        if len(item.Render().Enclosure) > 0 {
+            podcast = true
+            break
+        }
+    }
+
+    guids := make(map[string]int)
+    var newer time.Time
+    newerIdx := -1
+    for i, item := range items {
+        if guid, err := item.Guid(); err != nil {
+            problems = append(problems, LintProblem{LintError, i, "no guid"})
+        } else {
+            if madeUp := madeUpGuid(item); len(madeUp) > 0 {
+                problems = append(problems, LintProblem{LintWarning, i,
+                    "no guid, so one is made up from its " + madeUp +
+                    ", which changes if they do"})
+            }
+            if first, seen := guids[guid]; seen {
+                problems = append(problems, LintProblem{LintError, i,
+                    "same guid as item " + strconv.Itoa(first) + " (" + guid +
+                    ")"})
+            } else {
+                guids[guid] = i
+            }
+        }
+
+        if date, err := item.PubDate(); err != nil {
+            problems = append(problems, LintProblem{LintError, i,
+                                                    "no date: " + err.Error()})
+        } else {
+            if newerIdx >= 0 && date.After(newer) {
+                problems = append(problems, LintProblem{LintWarning, i,
+                    "newer than item " + strconv.Itoa(newerIdx) +
+                    ", should be newest first"})
+            }
+            newer, newerIdx = date, i
+        }
+
+        if podcast && len(item.Render().Enclosure) == 0 {
+            problems = append(problems, LintProblem{LintWarning, i,
+                                                    "no enclosure in a podcast"})
+        }
+    }
+    return problems
+}
+
+//  If `item` doesn't have a guid of its own, and `Guid()` has to make one up,
+// what from. "" if it has a real one.
+func madeUpGuid(item Item) string {
+    switch it := item.(type) {
+    case *RssItem:
+        if len(tryContent(it.src, "guid")) == 0 {
+            return "title and link"
+        }
+    case *RdfItem:
+        if len(it.src.Attr("about")) == 0 {
+            return "link"
+        }
+    }
+    return ""
+}
@@ -0,0 +1,78 @@
+package rssrerun
+
+import (
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/patrickyeon/rssrerun/testhelp"
+)
+
+func TestLintCleanFeeds(t *testing.T) {
+    for name, feed := range renderFeeds(t, 5) {
+        if problems := Lint(feed); len(problems) != 0 {
+            t.Errorf("%s should be clean, got %v", name, problems)
+        }
+    }
+}
+
+func TestLintItems(t *testing.T) {
+    enc := "<enclosure url=\"foo://bar.mp3\" length=\"1\" type=\"audio/mpeg\"/>"
+    rss := testhelp.CreateAndPopulateRSS(0, testhelp.StartDate())
+    rss.AddPost(episodeItem("ep4", 0, 0, 4, enc))
+    // out of order
+    rss.AddPost(episodeItem("ep5", 0, 0, 5, enc))
+    rss.AddPost(episodeItem("ep3", 0, 0, 3, ""))
+    rss.AddPost("<item><title>no guid</title><pubDate>" +
+                testhelp.StartDate().Format(time.RFC822) + "</pubDate>" +
+                enc + "</item>")
+    rss.AddPost("<item><guid>no date</guid>" + enc + "</item>")
+    rss.AddPost(episodeItem("ep4", 0, 0, 0, enc))
+    rss.AddPost("<item><title>made up</title><link>foo://bar</link><pubDate>" +
+                testhelp.StartDate().Format(time.RFC822) + "</pubDate>" +
+                enc + "</item>")
+    feed, err := NewFeed(rss.Bytes(), nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    want := []string{
+        "warning: item 1: newer than item 0, should be newest first",
+        "warning: item 2: no enclosure in a podcast",
+        "error: item 3: no guid",
+        "error: item 4: no date",
+        "error: item 5: same guid as item 0 (ep4)",
+        "warning: item 6: no guid, so one is made up from its title and link",
+    }
+    problems := Lint(feed)
+    if len(problems) != len(want) {
+        t.Fatalf("expected %d problems, got %v", len(want), problems)
+    }
+    for i, w := range want {
+        if got := problems[i].String(); !strings.HasPrefix(got, w) {
+            t.Errorf("expected \"%s\", got \"%s\"", w, got)
+        }
+    }
+}
+
+func TestLintWrapper(t *testing.T) {
+    text := string(testhelp.CreateAndPopulateRSS(2, testhelp.StartDate()).Bytes())
+    text = strings.Replace(text, "</channel>",
+                           "</channel><channel><title>bar</title></channel>", 1)
+    problems := LintBytes([]byte(text))
+    if len(problems) != 1 || problems[0].Level != LintError ||
+            !strings.Contains(problems[0].Message, "2 <channel>s") {
+        t.Errorf("expected two channels to be an error, got %v", problems)
+    }
+
+    problems = LintBytes([]byte("<html><body>not a feed</body></html>"))
+    if len(problems) != 1 || problems[0].Level != LintError {
+        t.Errorf("expected html to be an error, got %v", problems)
+    }
+
+    // and a good one is just checked like `Lint()` does
+    problems = LintBytes(testhelp.CreateAndPopulateATOM(3,
+                                                        testhelp.StartDate()).Bytes())
+    if len(problems) != 0 {
+        t.Errorf("expected no problems, got %v", problems)
+    }
+}
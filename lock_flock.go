@@ -0,0 +1,36 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package rssrerun
+
+import (
+    "os"
+    "syscall"
+)
+
+//  Take an exclusive lock on the file at `path` (making it if need be), waiting
+// for whoever has it now to let go. It's an flock(2), so it's dropped when the
+// process dies, and a crash can't leave anything locked.
+func lockFile(path string) (*os.File, error) {
+    f, err := os.OpenFile(path, os.O_CREATE | os.O_RDWR, 0666)
+    if err != nil {
+        return nil, err
+    }
+    for {
+        err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+        if err != syscall.EINTR {
+            break
+        }
+    }
+    if err != nil {
+        f.Close()
+        return nil, err
+    }
+    return f, nil
+}
+
+func unlockFile(f *os.File) error {
+    // closing it would drop the lock anyway, but there's no harm in saying so
+    syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+    return f.Close()
+}
@@ -0,0 +1,49 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package rssrerun
+
+import (
+    "os"
+    "sync"
+)
+
+//  Without flock(2), the best we can do is keep goroutines in this process out
+// of each other's way. Running more than one fetcher against the same store at
+// once isn't safe here.
+var fileLocks = struct {
+    mu sync.Mutex
+    // a lock for each path, and which lock each open file is holding
+    byPath map[string]*sync.Mutex
+    byFile map[*os.File]*sync.Mutex
+}{byPath: make(map[string]*sync.Mutex), byFile: make(map[*os.File]*sync.Mutex)}
+
+func lockFile(path string) (*os.File, error) {
+    f, err := os.OpenFile(path, os.O_CREATE | os.O_RDWR, 0666)
+    if err != nil {
+        return nil, err
+    }
+    fileLocks.mu.Lock()
+    l, ok := fileLocks.byPath[path]
+    if !ok {
+        l = new(sync.Mutex)
+        fileLocks.byPath[path] = l
+    }
+    fileLocks.mu.Unlock()
+    l.Lock()
+    fileLocks.mu.Lock()
+    fileLocks.byFile[f] = l
+    fileLocks.mu.Unlock()
+    return f, nil
+}
+
+func unlockFile(f *os.File) error {
+    fileLocks.mu.Lock()
+    l, ok := fileLocks.byFile[f]
+    delete(fileLocks.byFile, f)
+    fileLocks.mu.Unlock()
+    if ok {
+        l.Unlock()
+    }
+    return f.Close()
+}
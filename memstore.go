@@ -0,0 +1,250 @@
+package rssrerun
+
+import (
+    "encoding/json"
+    "errors"
+    "io/ioutil"
+    "os"
+    "sync"
+)
+
+//  A `Store` that's all in memory, for tests and for running somewhere without
+// a disk to keep things on. Urls are used as they are, so nothing is ever
+// fetched to canonicalize them. Items are kept as text and parsed each time
+// they're asked for, like the other stores, so changing an `Item` you got out
+// of it doesn't change what's stored.
+//
+//  It can also keep a snapshot of itself on disk (see `LoadMemStore()`), so
+// that it can be picked back up after a restart.
+type MemStore struct {
+    mu sync.RWMutex
+    feeds map[string]*memFeed
+    // urls, in the order they were added
+    urls []string
+    // where to save a snapshot after every change, if anywhere
+    snapshot string
+}
+
+type memFeed struct {
+    Url string `json:"url"`
+    Items []string `json:"items"`
+    Guids map[string]bool `json:"guids"`
+    Meta map[string]string `json:"meta"`
+}
+
+func NewMemStore() *MemStore {
+    return &MemStore{feeds: make(map[string]*memFeed)}
+}
+
+//  A `MemStore` that starts out as the snapshot at `path` (or empty, if there
+// isn't one yet), and saves a new snapshot there every time it changes. A
+// change whose snapshot can't be saved returns the error and is undone.
+func LoadMemStore(path string) (*MemStore, error) {
+    s := NewMemStore()
+    text, err := ioutil.ReadFile(path)
+    if err == nil {
+        feeds := []*memFeed{}
+        if err = json.Unmarshal(text, &feeds); err != nil {
+            return nil, err
+        }
+        for _, f := range feeds {
+            if f.Guids == nil {
+                f.Guids = make(map[string]bool)
+            }
+            if f.Meta == nil {
+                f.Meta = make(map[string]string)
+            }
+            s.feeds[f.Url] = f
+            s.urls = append(s.urls, f.Url)
+        }
+    } else if !os.IsNotExist(err) {
+        return nil, err
+    }
+    s.snapshot = path
+    return s, nil
+}
+
+// Save a snapshot of the store to `path`, for `LoadMemStore()`.
+func (s *MemStore) Save(path string) error {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+    return s.save(path)
+}
+
+func (s *MemStore) save(path string) error {
+    feeds := make([]*memFeed, len(s.urls))
+    for i, url := range s.urls {
+        feeds[i] = s.feeds[url]
+    }
+    text, err := json.Marshal(feeds)
+    if err != nil {
+        return err
+    }
+    // there's always a whole snapshot there, even if we die halfway through
+    return writeFileAtomic(path, text)
+}
+
+//  Call with the lock held for writing, once something's changed. If the
+// snapshot can't be saved, the caller puts things back the way they were, so
+// what's in memory never gets ahead of what's on disk.
+func (s *MemStore) changed() error {
+    if len(s.snapshot) == 0 {
+        return nil
+    }
+    return s.save(s.snapshot)
+}
+
+func (s *MemStore) feedFor(url string) (*memFeed, error) {
+    f, ok := s.feeds[url]
+    if !ok {
+        return nil, errors.New("couldn't find url")
+    }
+    return f, nil
+}
+
+func (s *MemStore) CreateIndex(url string) (Index, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if _, ok := s.feeds[url]; ok {
+        return Index{}, errors.New("Index already exists")
+    }
+    s.feeds[url] = &memFeed{url, []string{}, make(map[string]bool),
+                            make(map[string]string)}
+    s.urls = append(s.urls, url)
+    if err := s.changed(); err != nil {
+        delete(s.feeds, url)
+        s.urls = s.urls[:len(s.urls) - 1]
+        return Index{}, err
+    }
+    return Index{Url: url}, nil
+}
+
+func (s *MemStore) Get(url string, start int, end int) ([]Item, error) {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+    f, err := s.feedFor(url)
+    if err != nil {
+        return nil, err
+    }
+    if start < 0 || end <= start || end > len(f.Items) {
+        return nil, errors.New("invalid range")
+    }
+    ret := make([]Item, end - start)
+    for i, text := range f.Items[start:end] {
+        if ret[i], err = MkItem([]byte(text)); err != nil {
+            return nil, err
+        }
+    }
+    return ret, nil
+}
+
+func (s *MemStore) NumItems(url string) int {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+    f, err := s.feedFor(url)
+    if err != nil {
+        return 0
+    }
+    return len(f.Items)
+}
+
+func (s *MemStore) Update(url string, items []Item) error {
+    // items must be passed in oldest first
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    f, err := s.feedFor(url)
+    if err != nil {
+        return err
+    }
+    //  Work it all out before changing anything, so that a bad item doesn't
+    // leave the feed half-updated.
+    texts := []string{}
+    guids := make(map[string]bool)
+    for _, it := range items {
+        guid, err := it.Guid()
+        if err != nil {
+            return err
+        }
+        if f.Guids[guid] || guids[guid] {
+            continue
+        }
+        guids[guid] = true
+        texts = append(texts, it.String())
+    }
+    count := len(f.Items)
+    f.Items = append(f.Items, texts...)
+    for guid := range guids {
+        f.Guids[guid] = true
+    }
+    if err = s.changed(); err != nil {
+        f.Items = f.Items[:count]
+        for guid := range guids {
+            delete(f.Guids, guid)
+        }
+        return err
+    }
+    return nil
+}
+
+func (s *MemStore) GetInfo(url string, key string) (string, error) {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+    f, err := s.feedFor(url)
+    if err != nil {
+        return "", err
+    }
+    return f.Meta[key], nil
+}
+
+func (s *MemStore) SetInfo(url string, key string, val string) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    f, err := s.feedFor(url)
+    if err != nil {
+        return err
+    }
+    old, had := f.Meta[key]
+    f.Meta[key] = val
+    if err = s.changed(); err != nil {
+        if had {
+            f.Meta[key] = old
+        } else {
+            delete(f.Meta, key)
+        }
+        return err
+    }
+    return nil
+}
+
+func (s *MemStore) FeedFor(url string, ds *DateSource) (Feed, error) {
+    s.mu.RLock()
+    f, err := s.feedFor(url)
+    if err != nil {
+        s.mu.RUnlock()
+        return nil, err
+    }
+    count, wrap := len(f.Items), f.Meta["wrapper"]
+    s.mu.RUnlock()
+
+    feed, err := NewFeed([]byte(wrap), nil)
+    if err != nil {
+        return nil, err
+    }
+    get := func(start, end int) ([]Item, error) {
+        return s.Get(url, start, end)
+    }
+    return &StoredFeed{feed, ds, count, get}, nil
+}
+
+func (s *MemStore) Contains(url string) bool {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+    _, ok := s.feeds[url]
+    return ok
+}
+
+func (s *MemStore) List() []string {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+    return append([]string{}, s.urls...)
+}
@@ -0,0 +1,147 @@
+package rssrerun
+
+import (
+    "io/ioutil"
+    "os"
+    "testing"
+
+    "github.com/patrickyeon/rssrerun/testhelp"
+)
+
+func TestMemStore(t *testing.T) {
+    runStoreSuite(t, func() Store { return NewMemStore() })
+}
+
+func TestMemStoreSnapshot(t *testing.T) {
+    dir, err := ioutil.TempDir("", "memstore")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    path := dir + "/memstore.json"
+    s, err := LoadMemStore(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    url := "test://snapshot"
+    if _, err = s.CreateIndex(url); err != nil {
+        t.Fatal(err)
+    }
+    _, items, err := createItems(12, testhelp.StartDate())
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err = s.Update(url, items); err != nil {
+        t.Fatal(err)
+    }
+    if err = s.SetInfo(url, "foo", "bar"); err != nil {
+        t.Fatal(err)
+    }
+
+    // every change is already on disk
+    loaded, err := LoadMemStore(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if n := loaded.NumItems(url); n != 12 {
+        t.Fatalf("expected 12 items, got %d", n)
+    }
+    if val, _ := loaded.GetInfo(url, "foo"); val != "bar" {
+        t.Errorf("expected bar, got %s", val)
+    }
+    its, err := loaded.Get(url, 3, 4)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if its[0].String() != items[3].String() {
+        t.Errorf("expected %s, got %s", items[3].String(), its[0].String())
+    }
+    // and the guids came back too, so nothing is stored twice
+    if err = loaded.Update(url, items); err != nil {
+        t.Fatal(err)
+    }
+    if n := loaded.NumItems(url); n != 12 {
+        t.Errorf("expected still 12 items, got %d", n)
+    }
+
+    // a plain one doesn't touch the disk until it's told to
+    mem := NewMemStore()
+    mem.CreateIndex(url)
+    if err = mem.Save(path); err != nil {
+        t.Fatal(err)
+    }
+    if loaded, err = LoadMemStore(path); err != nil {
+        t.Fatal(err)
+    }
+    if !loaded.Contains(url) || loaded.NumItems(url) != 0 {
+        t.Error("saved snapshot didn't load")
+    }
+}
+
+func TestMemStoreSnapshotFails(t *testing.T) {
+    dir, err := ioutil.TempDir("", "memstore")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    // nowhere to save the snapshot to until the directory is made
+    path := dir + "/gone/memstore.json"
+    s, err := LoadMemStore(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    url := "test://snapshot"
+    if _, err = s.CreateIndex(url); err == nil {
+        t.Fatal("expected an error saving the snapshot")
+    }
+    if s.Contains(url) || len(s.List()) != 0 {
+        t.Fatal("failed CreateIndex should have been undone")
+    }
+
+    if err = os.Mkdir(dir + "/gone", os.ModeDir | os.ModePerm); err != nil {
+        t.Fatal(err)
+    }
+    if _, err = s.CreateIndex(url); err != nil {
+        t.Fatal(err)
+    }
+    if err = s.SetInfo(url, "foo", "bar"); err != nil {
+        t.Fatal(err)
+    }
+    if err = os.RemoveAll(dir + "/gone"); err != nil {
+        t.Fatal(err)
+    }
+
+    _, items, err := createItems(12, testhelp.StartDate())
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err = s.Update(url, items); err == nil {
+        t.Fatal("expected an error saving the snapshot")
+    }
+    if n := s.NumItems(url); n != 0 {
+        t.Errorf("failed Update should have been undone, got %d items", n)
+    }
+    if err = s.SetInfo(url, "foo", "baz"); err == nil {
+        t.Fatal("expected an error saving the snapshot")
+    }
+    if err = s.SetInfo(url, "new", "val"); err == nil {
+        t.Fatal("expected an error saving the snapshot")
+    }
+    if val, _ := s.GetInfo(url, "foo"); val != "bar" {
+        t.Errorf("expected bar, got %s", val)
+    }
+    if _, ok := s.feeds[url].Meta["new"]; ok {
+        t.Error("failed SetInfo of a new key should have been undone")
+    }
+
+    // and once it can be saved again, none of the undone guids get in the way
+    if err = os.Mkdir(dir + "/gone", os.ModeDir | os.ModePerm); err != nil {
+        t.Fatal(err)
+    }
+    if err = s.Update(url, items); err != nil {
+        t.Fatal(err)
+    }
+    if n := s.NumItems(url); n != 12 {
+        t.Errorf("expected 12 items, got %d", n)
+    }
+}
@@ -0,0 +1,247 @@
+package rssrerun
+
+import (
+    "errors"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/jbowtie/gokogiri/xml"
+)
+
+//  Podcasts number their episodes with the iTunes tags (`itunes:season`,
+// `itunes:episode`, `itunes:episodeType`), and more and more with the
+// Podcasting 2.0 ones (`podcast:season`, `podcast:episode`) as well. Where both
+// are there, the Podcasting 2.0 ones win. They can turn up in any XML feed, not
+// just RSS.
+
+const (
+    ItunesNamespace = "http://www.itunes.com/dtds/podcast-1.0.dtd"
+    PodcastNamespace = "https://podcastindex.org/namespace/1.0"
+)
+
+// the xpath to `tag` in the iTunes namespace
+func itunesXpath(tag string) string {
+    return ("*[local-name()='" + tag + "' and namespace-uri()='" +
+            ItunesNamespace + "']")
+}
+
+// the xpath to `tag` in the Podcasting 2.0 namespace
+func podcastXpath(tag string) string {
+    return ("*[local-name()='" + tag + "' and namespace-uri()='" +
+            PodcastNamespace + "']")
+}
+
+// the trimmed text of the first of `tags` under `node` that there is
+func firstContent(node xml.Node, tags ...string) (string, error) {
+    for _, tag := range tags {
+        if s := strings.TrimSpace(tryContent(node, tag)); len(s) > 0 {
+            return s, nil
+        }
+    }
+    return "", errors.New("no <" + strings.Join(tags, "> or <") + "> tag found")
+}
+
+func xmlSeason(node xml.Node) (int, error) {
+    s, err := firstContent(node, podcastXpath("season"), itunesXpath("season"))
+    if err != nil {
+        return 0, err
+    }
+    return strconv.Atoi(s)
+}
+
+//  Podcasting 2.0 episode numbers can have decimals (eg, 3.5 for something that
+// came out between 3 and 4), so they're floats.
+func xmlEpisode(node xml.Node) (float64, error) {
+    s, err := firstContent(node, podcastXpath("episode"), itunesXpath("episode"))
+    if err != nil {
+        return 0, err
+    }
+    return strconv.ParseFloat(s, 64)
+}
+
+//  `itunes:duration` is either a number of seconds, or [HH:]MM:SS (and the
+// seconds can have a fraction, in both).
+func parseItunesDuration(s string) (time.Duration, error) {
+    var total float64
+    parts := strings.Split(strings.TrimSpace(s), ":")
+    if len(parts) > 3 {
+        return 0, errors.New("invalid duration \"" + s + "\"")
+    }
+    for _, part := range parts {
+        n, err := strconv.ParseFloat(part, 64)
+        if err != nil || n < 0 {
+            return 0, errors.New("invalid duration \"" + s + "\"")
+        }
+        total = total * 60 + n
+    }
+    return time.Duration(total * float64(time.Second)), nil
+}
+
+func xmlDuration(node xml.Node) (time.Duration, error) {
+    s, err := firstContent(node, itunesXpath("duration"))
+    if err != nil {
+        return 0, err
+    }
+    return parseItunesDuration(s)
+}
+
+// "full", "trailer" or "bonus", where no `itunes:episodeType` means "full"
+func xmlEpisodeType(node xml.Node) string {
+    typ, err := firstContent(node, itunesXpath("episodeType"))
+    if err != nil {
+        return "full"
+    }
+    return strings.ToLower(typ)
+}
+
+func (item *RssItem) Season() (int, error) {
+    return xmlSeason(item.src)
+}
+
+func (item *RssItem) Episode() (float64, error) {
+    return xmlEpisode(item.src)
+}
+
+func (item *RssItem) EpisodeType() string {
+    return xmlEpisodeType(item.src)
+}
+
+func (item *RssItem) Duration() (time.Duration, error) {
+    return xmlDuration(item.src)
+}
+
+func (item *AtomItem) Season() (int, error) {
+    return xmlSeason(item.src)
+}
+
+func (item *AtomItem) Episode() (float64, error) {
+    return xmlEpisode(item.src)
+}
+
+func (item *AtomItem) EpisodeType() string {
+    return xmlEpisodeType(item.src)
+}
+
+func (item *AtomItem) Duration() (time.Duration, error) {
+    return xmlDuration(item.src)
+}
+
+func (item *RdfItem) Season() (int, error) {
+    return xmlSeason(item.src)
+}
+
+func (item *RdfItem) Episode() (float64, error) {
+    return xmlEpisode(item.src)
+}
+
+func (item *RdfItem) EpisodeType() string {
+    return xmlEpisodeType(item.src)
+}
+
+func (item *RdfItem) Duration() (time.Duration, error) {
+    return xmlDuration(item.src)
+}
+
+//  JSON Feed has no standard for these, so we go with what an `_itunes`
+// extension object would look like, if there is one.
+func (item *JSONItem) Season() (int, error) {
+    ext, _ := item.src["_itunes"].(map[string]interface{})
+    return strconv.Atoi(jsonString(ext, "season"))
+}
+
+func (item *JSONItem) Episode() (float64, error) {
+    ext, _ := item.src["_itunes"].(map[string]interface{})
+    return strconv.ParseFloat(jsonString(ext, "episode"), 64)
+}
+
+func (item *JSONItem) EpisodeType() string {
+    ext, _ := item.src["_itunes"].(map[string]interface{})
+    if typ := jsonString(ext, "episode_type"); len(typ) > 0 {
+        return strings.ToLower(typ)
+    }
+    return "full"
+}
+
+// JSON Feed does have a standard for this one, on the attachment
+func (item *JSONItem) Duration() (time.Duration, error) {
+    if attachments, ok := item.src["attachments"].([]interface{}); ok {
+        if len(attachments) > 0 {
+            if a, ok := attachments[0].(map[string]interface{}); ok {
+                if d := jsonString(a, "duration_in_seconds"); len(d) > 0 {
+                    return parseItunesDuration(d)
+                }
+            }
+        }
+    }
+    return 0, errors.New("no duration_in_seconds")
+}
+
+//  The season, episode and episode type of `item`, as `RenderItem` has them
+// (blank for whatever it doesn't have).
+func renderEpisode(item Item) (string, string, string) {
+    season, episode := "", ""
+    if s, err := item.Season(); err == nil {
+        season = strconv.Itoa(s)
+    }
+    if e, err := item.Episode(); err == nil {
+        episode = strconv.FormatFloat(e, 'f', -1, 64)
+    }
+    return season, episode, item.EpisodeType()
+}
+
+//  The order items get replayed in, as indexes into the feed, oldest first. The
+// feed's order unless the `DateSource` says `ByEpisode`, and only the items
+// its `Filter` includes.
+func rerunOrder(f Feed, d *DateSource) []int {
+    nItems := f.LenItems()
+    order := make([]int, 0, nItems)
+    if d.Filter == nil && !d.ByEpisode {
+        for k := 0; k < nItems; k++ {
+            order = append(order, nItems - k - 1)
+        }
+        return order
+    }
+    //  All in one go: from a `StoredFeed`, every `Item()` would be another
+    // trip to the store.
+    all := f.Items(0, nItems)
+    for k := 0; k < nItems; k++ {
+        if d.Filter == nil || d.Filter.Includes(all[nItems - k - 1]) {
+            order = append(order, nItems - k - 1)
+        }
+    }
+    if !d.ByEpisode {
+        return order
+    }
+    nItems = len(order)
+    //  Anything without a number stays right after whatever came before it in
+    // the feed (eg, a bonus episode after the one it goes with).
+    type numbered struct {
+        idx, season int
+        episode float64
+    }
+    items := make([]numbered, nItems)
+    last := numbered{}
+    for k, idx := range order {
+        item := all[idx]
+        if episode, err := item.Episode(); err == nil {
+            last.episode = episode
+            // and no season means the same one as before
+            if season, err := item.Season(); err == nil {
+                last.season = season
+            }
+        }
+        items[k] = numbered{idx, last.season, last.episode}
+    }
+    sort.SliceStable(items, func(i, j int) bool {
+        if items[i].season != items[j].season {
+            return items[i].season < items[j].season
+        }
+        return items[i].episode < items[j].episode
+    })
+    for k, it := range items {
+        order[k] = it.idx
+    }
+    return order
+}
@@ -0,0 +1,167 @@
+package rssrerun
+
+import (
+    "strconv"
+    "testing"
+    "time"
+
+    "github.com/patrickyeon/rssrerun/testhelp"
+)
+
+const itunesNs = " xmlns:itunes=\"http://www.itunes.com/dtds/podcast-1.0.dtd\""
+const podcastNs = " xmlns:podcast=\"https://podcastindex.org/namespace/1.0\""
+
+//  An RSS item with iTunes season/episode tags, published a week after
+// `StartDate()` times `week`.
+func episodeItem(guid string, season, episode, week int, extra string) string {
+    text := "<item" + itunesNs + podcastNs + "><title>" + guid + "</title>"
+    text += "<guid>" + guid + "</guid>"
+    text += "<pubDate>" +
+            testhelp.StartDate().AddDate(0, 0, 7 * week).Format(time.RFC822) +
+            "</pubDate>"
+    if season > 0 {
+        text += "<itunes:season>" + strconv.Itoa(season) + "</itunes:season>"
+    }
+    if episode > 0 {
+        text += "<itunes:episode>" + strconv.Itoa(episode) + "</itunes:episode>"
+    }
+    return text + extra + "</item>"
+}
+
+func TestEpisodeAccessors(t *testing.T) {
+    rss := testhelp.CreateAndPopulateRSS(0, testhelp.StartDate())
+    rss.AddPost(episodeItem("plain", 2, 5, 0, ""))
+    rss.AddPost(episodeItem("p20", 2, 5, 0,
+                            "<podcast:season>3</podcast:season>" +
+                            "<podcast:episode>6.5</podcast:episode>" +
+                            "<itunes:episodeType>Bonus</itunes:episodeType>"))
+    rss.AddPost(episodeItem("none", 0, 0, 0, ""))
+    feed, err := NewFeed(rss.Bytes(), nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    want := []struct {
+        season int
+        episode float64
+        typ string
+    }{{2, 5, "full"}, {3, 6.5, "bonus"}, {0, 0, "full"}}
+    for i, w := range want {
+        item := feed.Item(i)
+        season, serr := item.Season()
+        episode, eerr := item.Episode()
+        if w.season == 0 {
+            if serr == nil || eerr == nil {
+                t.Errorf("item %d shouldn't have a season or episode", i)
+            }
+        } else if season != w.season || episode != w.episode {
+            t.Errorf("item %d should be S%dE%v, got S%dE%v", i, w.season,
+                     w.episode, season, episode)
+        }
+        if typ := item.EpisodeType(); typ != w.typ {
+            t.Errorf("item %d should be %s, got %s", i, w.typ, typ)
+        }
+    }
+    if r := feed.Item(1).Render(); r.Season != "3" || r.Episode != "6.5" ||
+                                   r.EpisodeType != "bonus" {
+        t.Errorf("episode didn't render: %v", r)
+    }
+
+    // and they come across when converted
+    for _, to := range []func(Item) (Item, error){ToAtomItem, ToRssItem} {
+        atom, err := ToAtomItem(feed.Item(1))
+        if err != nil {
+            t.Fatal(err)
+        }
+        converted, err := to(atom)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if r := converted.Render(); r.Season != "3" || r.Episode != "6.5" ||
+                                    r.EpisodeType != "bonus" {
+            t.Errorf("episode didn't convert: %v", r)
+        }
+    }
+}
+
+func TestPodcastGuid(t *testing.T) {
+    rss := testhelp.CreateAndPopulateRSS(1, testhelp.StartDate())
+    text := string(rss.Bytes())
+    text = text[:len("<rss version=\"2.0\"><channel>")] +
+           "<podcast:guid" + podcastNs + ">ead4c236-bf58-58c6-a2c6-a6b28d128cb6" +
+           "</podcast:guid>" + text[len("<rss version=\"2.0\"><channel>"):]
+    feed, err := NewFeed([]byte(text), nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if guid := feed.Info().PodcastGuid; guid != "ead4c236-bf58-58c6-a2c6-a6b28d128cb6" {
+        t.Errorf("wrong podcast:guid: %s", guid)
+    }
+}
+
+//  `f` as a `StoredFeed`, and how many times it's gone to the "store" for
+// items.
+func countingStoredFeed(f Feed, ds *DateSource) (*StoredFeed, *int) {
+    calls := 0
+    // a copy, since an `RssFeed` hands out its own slice
+    oldest := append([]Item{}, f.Items(0, f.LenItems())...)
+    for i := 0; i < len(oldest) / 2; i++ {
+        j := len(oldest) - i - 1
+        oldest[i], oldest[j] = oldest[j], oldest[i]
+    }
+    get := func(start, end int) ([]Item, error) {
+        calls++
+        return append([]Item{}, oldest[start:end]...), nil
+    }
+    return &StoredFeed{f, ds, len(oldest), get}, &calls
+}
+
+func TestByEpisode(t *testing.T) {
+    //  Newest first, as a feed is, but the publisher re-uploaded S1E2 and S2E1
+    // long after the fact. The bonus episode has no number, and stays after
+    // S1E3, which came out just before it.
+    rss := testhelp.CreateAndPopulateRSS(0, testhelp.StartDate())
+    rss.AddPost(episodeItem("S2E1", 2, 1, 9, ""))
+    rss.AddPost(episodeItem("S1E2", 1, 2, 8, ""))
+    rss.AddPost(episodeItem("S2E2", 2, 2, 4, ""))
+    rss.AddPost(episodeItem("bonus", 0, 0, 3, ""))
+    rss.AddPost(episodeItem("S1E3", 1, 3, 2, ""))
+    rss.AddPost(episodeItem("S1E1", 1, 1, 0, ""))
+    rerun := NewDateSource(testhelp.StartDate().AddDate(1, 0, 0),
+                           []time.Weekday{time.Monday})
+    rerun.ByEpisode = true
+    feed, err := NewFeed(rss.Bytes(), rerun)
+    if err != nil {
+        t.Fatal(err)
+    }
+    items, err := feed.ShiftedAt(10, rerun.StartDate.AddDate(0, 2, 0))
+    if err != nil {
+        t.Fatal(err)
+    }
+    want := []string{"S2E2", "S2E1", "bonus", "S1E3", "S1E2", "S1E1"}
+    if len(items) != len(want) {
+        t.Fatalf("expected %d items, got %d", len(want), len(items))
+    }
+    for i, guid := range want {
+        if got, _ := items[i].Guid(); got != guid {
+            t.Errorf("item %d should be %s, got %s", i, guid, got)
+        }
+    }
+
+    // which shouldn't take a trip to the store for every item
+    stored, calls := countingStoredFeed(feed, rerun)
+    items, err = stored.ShiftedAt(1, rerun.StartDate.AddDate(0, 2, 0))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if got, _ := items[0].Guid(); got != "S2E2" {
+        t.Errorf("stored feed should be on S2E2, got %s", got)
+    }
+    if *calls > 2 {
+        t.Errorf("went to the store %d times for one item", *calls)
+    }
+
+    rerun.Speed = 1
+    if _, err = feed.ShiftedAt(10, rerun.StartDate.AddDate(0, 2, 0)); err == nil {
+        t.Error("shouldn't be able to order by episode in cadence mode")
+    }
+}
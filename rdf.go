@@ -0,0 +1,281 @@
+package rssrerun
+
+import (
+    "errors"
+    "io"
+    "time"
+
+    "github.com/jbowtie/gokogiri/xml"
+)
+
+//  RSS 1.0 is RDF, so it looks a lot like RSS 2.0 but the `item`s are beside
+// the `channel` (which lists them again in an `rdf:Seq`), everything is in a
+// namespace, dates are `dc:date`, and the guid is the `rdf:about` attribute.
+/*
+<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+         xmlns="http://purl.org/rss/1.0/"
+         xmlns:dc="http://purl.org/dc/elements/1.1/">
+  <channel rdf:about="http://example.com/">
+    <title>...</title> <link>...</link> <description>...</description>
+    <items><rdf:Seq><rdf:li rdf:resource="http://example.com/1"/></rdf:Seq></items>
+  </channel>
+  <item rdf:about="http://example.com/1">
+    <title>...</title> <link>...</link> <dc:date>2015-04-12T01:00:00Z</dc:date>
+  </item>
+</rdf:RDF>
+*/
+
+const (
+    rdfNamespace = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+    rss1Namespace = "http://purl.org/rss/1.0/"
+    dcNamespace = "http://purl.org/dc/elements/1.1/"
+)
+
+type RdfFeed struct {
+    root xml.Node
+    //  the wrapper, split where the `rdf:li`s for the items go (if the channel
+    // lists them) and then where the items themselves go
+    parts [][]byte
+    itemNodes []xml.Node
+    items []Item
+    d *DateSource
+}
+
+type RdfItem struct {
+    src xml.Node
+}
+
+// Try to extract an RSS 1.0 feed from an `xml.Document`
+func newRdfRaw(doc xml.Document) (*RdfFeed, error) {
+    root := doc.Root()
+    if root.Name() != "RDF" || root.Namespace() != rdfNamespace {
+        return nil, errors.New("<rdf:RDF> tag missing or not root for RSS 1.0 feed")
+    }
+    channels, err := root.Search(xpath("channel"))
+    if err != nil {
+        return nil, err
+    }
+    if len(channels) != 1 {
+        return nil, errors.New("need exactly one <channel> tag for RSS 1.0 feed")
+    }
+
+    f := new(RdfFeed)
+    f.root = root
+    f.itemNodes, err = root.Search(xpath("item"))
+    if err != nil {
+        return nil, err
+    }
+    // as in the Atom case, create a placeholder and remove all the `item` tags
+    placeholder := doc.CreateElementNode("item")
+    if len(f.itemNodes) > 0 {
+        f.itemNodes[0].InsertBefore(placeholder)
+    } else {
+        root.AddChild(placeholder)
+    }
+    placeholder.SetNamespace("", rss1Namespace)
+    for _, item := range f.itemNodes {
+        //  The items only make sense with the namespaces from the root, so they
+        // get their own copy of them to still make sense on their own (eg, when
+        // they are stored).
+        for _, ns := range root.DeclaredNamespaces() {
+            item.DeclareNamespace(ns.Prefix, ns.Uri)
+        }
+        item.Unlink()
+    }
+
+    //  The channel lists what items there are, so that gets filled in when
+    // rendering as well. Without a list, it just doesn't get one.
+    seqs, err := root.Search(xpath("channel") + "/" + xpath("items") + "/" +
+                             xpath("Seq"))
+    if err != nil {
+        return nil, err
+    }
+    if len(seqs) == 0 {
+        f.parts, err = splitWrapper(root, []xml.Node{placeholder})
+        if err != nil {
+            return nil, err
+        }
+        return f, nil
+    }
+    seq := seqs[0]
+    oldList := []xml.Node{}
+    for child := seq.FirstChild(); child != nil; child = child.NextSibling() {
+        oldList = append(oldList, child)
+    }
+    for _, child := range oldList {
+        child.Unlink()
+    }
+    li := doc.CreateElementNode(rdfPrefix(root) + ":li")
+    seq.AddChild(li)
+    f.parts, err = splitWrapper(root, []xml.Node{li, placeholder})
+    li.Unlink()
+    for _, child := range oldList {
+        seq.AddChild(child)
+    }
+    if err != nil {
+        return nil, err
+    }
+    return f, nil
+}
+
+func (f *RdfFeed) makeItems() {
+    f.items = make([]Item, len(f.itemNodes))
+    for i, it := range f.itemNodes {
+        f.items[i] = &RdfItem{it}
+    }
+}
+
+func (f *RdfFeed) Info() FeedInfo {
+    return FeedInfo{
+        tryContent(f.root, xpath("channel") + "/" + xpath("title")),
+        tryContent(f.root, xpath("channel") + "/" + xpath("link")),
+        tryContent(f.root, xpath("channel") + "/" + xpath("description")),
+        tryContent(f.root, xpath("channel") + "/" + podcastXpath("guid")),
+    }
+}
+
+func (f *RdfFeed) Items(start, end int) []Item {
+    return f.items[start:end]
+}
+
+func (f *RdfFeed) Item(idx int) Item {
+    return f.items[idx]
+}
+
+func (f *RdfFeed) LenItems() int {
+    return len(f.items)
+}
+
+func (f *RdfFeed) allItems() []Item {
+    return f.items
+}
+
+func (f *RdfFeed) appendItems(items []Item) {
+    f.items = append(f.items, items...)
+}
+
+func (f *RdfFeed) ShiftedAt(n int, t time.Time) ([]Item, error) {
+    return univShiftedAt(n, t, f, f.d)
+}
+
+func (f *RdfFeed) Wrapper() []byte {
+    return f.root.ToBuffer(nil)
+}
+
+func (f *RdfFeed) BytesWithItems(items []Item) []byte {
+    return bytesWithItems(f, items)
+}
+
+func (f *RdfFeed) WriteWithItems(w io.Writer, items []Item) error {
+    if len(f.parts) == 2 {
+        return writeSplit(w, f.parts, itemWriter(items))
+    }
+    prefix := rdfPrefix(f.root)
+    seq := func(w io.Writer) error {
+        for _, item := range items {
+            guid, err := item.Guid()
+            if err != nil {
+                continue
+            }
+            li := "<" + prefix + ":li " + prefix + ":resource=\"" + esc(guid) + "\"/>"
+            if _, err = io.WriteString(w, li); err != nil {
+                return err
+            }
+        }
+        return nil
+    }
+    return writeSplit(w, f.parts, seq, itemWriter(items))
+}
+
+// what the document calls the RDF namespace (almost always "rdf")
+func rdfPrefix(node xml.Node) string {
+    for _, ns := range node.MyDocument().Root().DeclaredNamespaces() {
+        if ns.Uri == rdfNamespace && len(ns.Prefix) > 0 {
+            return ns.Prefix
+        }
+    }
+    return "rdf"
+}
+
+// the xpath to `tag` in the Dublin Core namespace
+func dcXpath(tag string) string {
+    return ("*[local-name()='" + tag + "' and namespace-uri()='" +
+            dcNamespace + "']")
+}
+
+func (item *RdfItem) PubDate() (time.Time, error) {
+    return firstDate(item.src, []string{dcXpath("date")})
+}
+
+func (item *RdfItem) SetPubDate(date time.Time) error {
+    tag, err := getChild(item.src, dcXpath("date"))
+    if err != nil {
+        newTag := item.src.MyDocument().CreateElementNode("date")
+        if err = item.src.AddChild(newTag); err != nil {
+            return err
+        }
+        newTag.SetNamespace("dc", dcNamespace)
+        tag = newTag
+    }
+    return tag.SetContent(date.Format(time.RFC3339))
+}
+
+func (item *RdfItem) Guid() (string, error) {
+    if about := item.src.Attr("about"); len(about) > 0 {
+        return about, nil
+    }
+    // it's required, but just in case
+    if link := tryContent(item.src, xpath("link")); len(link) > 0 {
+        return link, nil
+    }
+    return "", errors.New("can't build a guid")
+}
+
+func (item *RdfItem) SetGuid(guid string) error {
+    item.src.SetAttr(rdfPrefix(item.src) + ":about", guid)
+    return nil
+}
+
+func (item *RdfItem) Clone() Item {
+    return &RdfItem{item.src.Duplicate(1)}
+}
+
+func (item *RdfItem) OriginalPubDate() (time.Time, error) {
+    return originalPubDate(item.src)
+}
+
+func (item *RdfItem) SetOriginalPubDate(date time.Time) error {
+    return setOriginalPubDate(item.src, date)
+}
+
+func (item *RdfItem) AddNote(note string) error {
+    return prependContent(item.src, "description", note)
+}
+
+func (item *RdfItem) String() string {
+    return item.src.String()
+}
+
+func (item *RdfItem) Node() xml.Node {
+    return item.src
+}
+
+func (item *RdfItem) Render() RenderItem {
+    pubDate, _ := item.PubDate()
+    guid, _ := item.Guid()
+    season, episode, episodeType := renderEpisode(item)
+    return RenderItem{
+        pubDate.Format("2006-01-02"),
+        tryContent(item.src, xpath("title")),
+        tryContent(item.src, xpath("description")),
+        guid,
+        tryContent(item.src, xpath("link")),
+        tryContent(item.src, dcXpath("creator")),
+        "",
+        "",
+        "",
+        season,
+        episode,
+        episodeType,
+    }
+}
@@ -0,0 +1,124 @@
+package rssrerun
+
+import (
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/patrickyeon/rssrerun/testhelp"
+)
+
+func TestRdfGuids(t *testing.T) {
+    testGuids(t, testhelp.CreateAndPopulateRDF(10, testhelp.StartDate()))
+}
+
+func TestRdfTimeShift(t *testing.T) {
+    testTimeShift(t, testhelp.CreateAndPopulateRDF(10, testhelp.StartDate()),
+                  NewFeed)
+}
+
+func TestRdfLatestFive(t *testing.T) {
+    rdf := testhelp.CreateAndPopulateRDF(100, testhelp.StartDate().AddDate(-3, 0, 0))
+    testLatestFive(t, rdf)
+}
+
+func TestRdfShiftLeavesFeed(t *testing.T) {
+    testShiftLeavesFeed(t, testhelp.CreateAndPopulateRDF(10, testhelp.StartDate()),
+                        NewFeed)
+}
+
+func TestRdfBurst(t *testing.T) {
+    testBurst(t, testhelp.CreateAndPopulateRDF(10, testhelp.StartDate()))
+}
+
+func TestRdfEndLoop(t *testing.T) {
+    testEndLoop(t, testhelp.CreateAndPopulateRDF(3, testhelp.StartDate()))
+}
+
+func TestRdfOriginalPubDate(t *testing.T) {
+    testOriginalPubDate(t, testhelp.CreateAndPopulateRDF(10, testhelp.StartDate()))
+}
+
+func TestStoredRdfTimeShift(t *testing.T) {
+    testTimeShift(t, testhelp.CreateAndPopulateRDF(10, testhelp.StartDate()),
+                  storedFeedTesterNewFeed)
+}
+
+func TestRdfSeq(t *testing.T) {
+    rerun := NewDateSource(testhelp.StartDate().AddDate(1, 0, 0),
+                           []time.Weekday{time.Monday})
+    feed, err := NewFeed(testhelp.CreateAndPopulateRDF(10,
+                                                     testhelp.StartDate()).Bytes(),
+                         rerun)
+    if err != nil {
+        t.Fatal(err)
+    }
+    items, err := feed.ShiftedAt(2, rerun.StartDate.AddDate(0, 0, 14))
+    if err != nil {
+        t.Fatal(err)
+    }
+    out := string(feed.BytesWithItems(items))
+    if n := strings.Count(out, "rdf:li "); n != 2 {
+        t.Errorf("expected the channel to list 2 items, got %d: %s", n, out)
+    }
+    for _, about := range []string{"1", "2"} {
+        if !strings.Contains(out, "rdf:resource=\"" + about + "\"") {
+            t.Errorf("expected item %s listed in the channel: %s", about, out)
+        }
+    }
+    // and the channel goes back to how it was
+    if n := strings.Count(string(feed.Wrapper()), "rdf:li "); n != 10 {
+        t.Errorf("expected the wrapper to list 10 items, got %d", n)
+    }
+}
+
+func TestRdfDates(t *testing.T) {
+    rdf := testhelp.CreateAndPopulateRDF(0, testhelp.StartDate())
+    dates := map[string]time.Time{
+        "2015-04-12T01:00:00Z": time.Date(2015, 4, 12, 1, 0, 0, 0, time.UTC),
+        "2015-04-12T03:00+02:00": time.Date(2015, 4, 12, 1, 0, 0, 0, time.UTC),
+        "2015-04-12T01:00:00.5Z": time.Date(2015, 4, 12, 1, 0, 0, 5e8, time.UTC),
+        "2015-04-12": time.Date(2015, 4, 12, 0, 0, 0, 0, time.UTC),
+    }
+    for date, _ := range dates {
+        rdf.AddPost("<item rdf:about=\"" + date + "\"><dc:date>" + date +
+                    "</dc:date></item>")
+    }
+    feed, err := NewFeed(rdf.Bytes(), nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, item := range feed.Items(0, feed.LenItems()) {
+        guid, _ := item.Guid()
+        got, err := item.PubDate()
+        if err != nil || !got.Equal(dates[guid]) {
+            t.Errorf("%s should parse to %v, got %v (%v)", guid, dates[guid],
+                     got, err)
+        }
+    }
+}
+
+func TestRdfMkItem(t *testing.T) {
+    feed, err := NewFeed(testhelp.CreateAndPopulateRDF(1,
+                                                     testhelp.StartDate()).Bytes(),
+                         nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    item, err := MkItem([]byte(feed.Item(0).String()))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, ok := item.(*RdfItem); !ok {
+        t.Fatal("item didn't come back as RSS 1.0")
+    }
+    if pd, err := item.PubDate(); err != nil || !pd.Equal(testhelp.StartDate()) {
+        t.Errorf("expected pubdate %v, got %v (%v)", testhelp.StartDate(), pd, err)
+    }
+    if err = item.SetGuid("foo"); err != nil {
+        t.Fatal(err)
+    }
+    if guid, _ := item.Guid(); guid != "foo" {
+        t.Errorf("expected guid foo, got %s", guid)
+    }
+}
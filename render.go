@@ -0,0 +1,91 @@
+package rssrerun
+
+import (
+    "bytes"
+    "errors"
+    "io"
+
+    "github.com/jbowtie/gokogiri/xml"
+)
+
+//  Rendering a feed is writing out its wrapper with the items in place of the
+// placeholder. Rather than splice the items into the document (which would
+// mean no one else can use it while we do), the wrapper is serialised once,
+// when the feed is parsed, in pieces split wherever something gets filled in.
+// Rendering is then just writing those out with the items between them, and
+// any number of goroutines can do that at once.
+
+// what goes in the doc where it's to be split, for long enough to find it again
+const splitMarker = "rssrerun:split"
+
+//  Serialise `root` in pieces, split where each of `at` is. `at` are swapped out
+// for markers for as long as it takes, so `root` is back as it was after.
+func splitWrapper(root xml.Node, at []xml.Node) ([][]byte, error) {
+    doc := root.MyDocument()
+    markers := make([]xml.Node, len(at))
+    for i, node := range at {
+        markers[i] = doc.CreateCommentNode(splitMarker)
+        if err := node.AddPreviousSibling(markers[i]); err != nil {
+            return nil, err
+        }
+        node.Unlink()
+    }
+    text := root.ToBuffer(nil)
+    for i, node := range at {
+        if err := markers[i].AddNextSibling(node); err != nil {
+            return nil, err
+        }
+        markers[i].Unlink()
+    }
+    parts := bytes.Split(text, []byte("<!--" + splitMarker + "-->"))
+    if len(parts) != len(at) + 1 {
+        // the feed itself had one of our markers in it, somehow
+        return nil, errors.New("couldn't find where the items go in the feed")
+    }
+    return parts, nil
+}
+
+//  Write out `parts`, with what `fill[i]` writes between `parts[i]` and
+// `parts[i + 1]`.
+func writeSplit(w io.Writer, parts [][]byte, fill ...func(io.Writer) error) error {
+    if len(parts) != len(fill) + 1 {
+        return errors.New("feed wrapper wasn't split for rendering")
+    }
+    for i, part := range parts {
+        if _, err := w.Write(part); err != nil {
+            return err
+        }
+        if i < len(fill) {
+            if err := fill[i](w); err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
+
+// writes out the XML for each of `items`
+func itemWriter(items []Item) func(io.Writer) error {
+    return func(w io.Writer) error {
+        for _, item := range items {
+            node := item.Node()
+            if node == nil {
+                return errors.New("item has no XML to render")
+            }
+            if _, err := w.Write(node.ToBuffer(nil)); err != nil {
+                return err
+            }
+        }
+        return nil
+    }
+}
+
+//  `f.WriteWithItems()` into a buffer, for `BytesWithItems()`. Like that, it's
+// nil if anything goes wrong.
+func bytesWithItems(f Feed, items []Item) []byte {
+    var buf bytes.Buffer
+    if err := f.WriteWithItems(&buf, items); err != nil {
+        return nil
+    }
+    return buf.Bytes()
+}
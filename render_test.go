@@ -0,0 +1,131 @@
+package rssrerun
+
+import (
+    "bytes"
+    "errors"
+    "strconv"
+    "sync"
+    "testing"
+
+    "github.com/patrickyeon/rssrerun/testhelp"
+)
+
+func renderFeeds(t *testing.T, n int) map[string]Feed {
+    d := testhelp.StartDate()
+    feeds := make(map[string]Feed)
+    for name, text := range map[string][]byte{
+            "rss": testhelp.CreateAndPopulateRSS(n, d).Bytes(),
+            "atom": testhelp.CreateAndPopulateATOM(n, d).Bytes(),
+            "rdf": testhelp.CreateAndPopulateRDF(n, d).Bytes(),
+            "json": testhelp.CreateAndPopulateJSON(n, d).Bytes()} {
+        feed, err := NewFeed(text, nil)
+        if err != nil {
+            t.Fatal(err)
+        }
+        feeds[name] = feed
+    }
+    return feeds
+}
+
+// check that `text` parses to a feed with exactly `guids` in it
+func checkRendered(text []byte, guids []string) error {
+    feed, err := NewFeed(text, nil)
+    if err != nil {
+        return err
+    }
+    if feed.LenItems() != len(guids) {
+        return errors.New("expected " + strconv.Itoa(len(guids)) +
+                          " items, got " + strconv.Itoa(feed.LenItems()))
+    }
+    for i, want := range guids {
+        if got, _ := feed.Item(i).Guid(); got != want {
+            return errors.New("expected item " + want + ", got " + got)
+        }
+    }
+    return nil
+}
+
+func TestWriteWithItems(t *testing.T) {
+    for name, feed := range renderFeeds(t, 5) {
+        wrapper := feed.Wrapper()
+        items := feed.Items(1, 3)
+        var buf bytes.Buffer
+        if err := feed.WriteWithItems(&buf, items); err != nil {
+            t.Fatalf("%s: %v", name, err)
+        }
+        if !bytes.Equal(buf.Bytes(), feed.BytesWithItems(items)) {
+            t.Errorf("%s: writing and BytesWithItems() should agree", name)
+        }
+        if err := checkRendered(buf.Bytes(), []string{"4", "3"}); err != nil {
+            t.Errorf("%s: %v\n%s", name, err, buf.Bytes())
+        }
+        if err := checkRendered(feed.BytesWithItems([]Item{}), []string{}); err != nil {
+            t.Errorf("%s, no items: %v", name, err)
+        }
+        // none of that changes the feed
+        if !bytes.Equal(wrapper, feed.Wrapper()) {
+            t.Errorf("%s: rendering changed the wrapper", name)
+        }
+    }
+}
+
+func TestConcurrentRender(t *testing.T) {
+    for name, feed := range renderFeeds(t, 10) {
+        var wg sync.WaitGroup
+        for i := 0; i < 10; i++ {
+            wg.Add(1)
+            go func(start int) {
+                defer wg.Done()
+                guids := []string{}
+                for j := start; j < 10; j++ {
+                    guids = append(guids, strconv.Itoa(10 - j))
+                }
+                var buf bytes.Buffer
+                err := feed.WriteWithItems(&buf, feed.Items(start, 10))
+                if err == nil {
+                    err = checkRendered(buf.Bytes(), guids)
+                }
+                if err != nil {
+                    t.Errorf("%s from %d: %v", name, start, err)
+                }
+            }(i)
+        }
+        wg.Wait()
+    }
+}
+
+type failingWriter struct {
+    left int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+    if len(p) > w.left {
+        return w.left, errors.New("out of room")
+    }
+    w.left -= len(p)
+    return len(p), nil
+}
+
+func TestRenderErrors(t *testing.T) {
+    for name, feed := range renderFeeds(t, 3) {
+        full := len(feed.BytesWithItems(feed.Items(0, 3)))
+        for _, room := range []int{0, 10, full - 1} {
+            err := feed.WriteWithItems(&failingWriter{room}, feed.Items(0, 3))
+            if err == nil {
+                t.Errorf("%s: writing %d bytes into %d should fail", name, full,
+                         room)
+            }
+        }
+    }
+
+    // a JSON item has no XML to put in an RSS feed
+    feeds := renderFeeds(t, 1)
+    items := []Item{feeds["rss"].Item(0), feeds["json"].Item(0)}
+    var buf bytes.Buffer
+    if err := feeds["rss"].WriteWithItems(&buf, items); err == nil {
+        t.Error("shouldn't be able to put a JSON item in an RSS feed")
+    }
+    if text := feeds["rss"].BytesWithItems(items); text != nil {
+        t.Errorf("expected nil, got %s", text)
+    }
+}
@@ -2,23 +2,25 @@ package rssrerun
 
 import (
     "errors"
+    "io"
+    "sort"
+    "strconv"
     "time"
     "github.com/jbowtie/gokogiri"
     "github.com/jbowtie/gokogiri/xml"
 )
 
-var (
-    dateTypes = []string {time.RFC822, time.RFC822Z,
-    time.RFC1123, time.RFC1123Z}
-)
-
 //  Pretty much what it says on the tin: the XML representation of a feed.
 type Feed interface {
     //  Return the document with only one item/entry tag. It is an empty tag and
     // used as a placeholder to be populated with items/entries later.
     Wrapper() []byte
-    // Return the doc with the placeholder replaced with `items`
+    //  Return the doc with the placeholder replaced with `items`, or nil if it
+    // can't be rendered (see `WriteWithItems()` for why).
     BytesWithItems(items []Item) []byte
+    //  Write the doc with the placeholder replaced with `items` to `w`, as it
+    // goes. It's safe to render a feed from any number of goroutines at once.
+    WriteWithItems(w io.Writer, items []Item) error
     // Accessor for the `Item`s parsed from the doc.
     // `Item`s are stored in the order listed in the doc. I guess this doesn't
     // necessarily need to be chronological, but we should hope it's most
@@ -27,59 +29,221 @@ type Feed interface {
     LenItems() int
     Item(idx int) Item
     //  Return the most recent `n` `item`s, that would be replayed before `t`.
-    // Errors on no items. (TODO could probably just return a `nil` array)
+    // What happens once the rerun runs out of items is up to the `DateSource`'s
+    // `End`.
     ShiftedAt(n int, t time.Time) ([]Item, error)
+    // The basics about the feed itself, whatever format it's in
+    Info() FeedInfo
 
     // Some private methods to make my life easier
     appendItems(items []Item)
     allItems() []Item
 }
 
+//  What a feed is, as opposed to what's in it. These are named for RSS, like
+// `Item`s are. `PodcastGuid` is the Podcasting 2.0 `podcast:guid`, which
+// identifies the podcast itself (it's not per-item, whatever the name says).
+type FeedInfo struct {
+    Title, Link, Description string
+    PodcastGuid string
+}
+
 //  The method to shift a feed is the same whether RSS or Atom, so the work is
 // abstracted out to this function. The different implementations of feeds just
 // call here.
 func univShiftedAt(n int, t time.Time, f Feed, d *DateSource) ([]Item, error) {
-    // if item N is after time t, we want items (N-n-1 .. N-1) and then shift
-    ndays := d.DatesInRange(d.StartDate, t)
-    // n is the number of items we want
-    // ndays is the number of rerun episodes between start and t
-    // (ndays - n) < 0 means they're asking for more than have rerun yet.
-    //   that's ok, we need to give them fewer though
-    // (ndays - n) > 0 means we need to skip the first (ndays - n) reruns
-    // once they're skipped, one-for-one item and .NextDate()
-    nskip := ndays - n
-    if nskip < 0 {
-        nskip = 0
-    }
-    nItems := f.LenItems()
-    if nskip > nItems {
-        return nil, errors.New("too old")
-    }
-    d.lastDate = d.StartDate.AddDate(0, 0, -1)
-    d.SkipForward(nskip)
+    if d.Speed > 0 {
+        return cadenceShiftedAt(n, t, f, d)
+    }
+    //  Episode k of the rerun is the k'th oldest item (or, looping, k mod the
+    // number of items), out on `d.DateAt(k)`. "Oldest" is by episode number if
+    // `d.ByEpisode` says so, and only counts what `d.Filter` lets in (see
+    // `rerunOrder()`). Find how many episodes are out by
+    // `t`, and we want the last `n` of them.
+    order := rerunOrder(f, d)
+    nItems := len(order)
+    nOut := d.DatesInRange(d.StartDate, t)
+    switch d.End {
+    case EndStop:
+        // stay on the final window once we've run out
+        if nOut > nItems {
+            nOut = nItems
+        }
+    case EndLoop:
+        if nItems == 0 {
+            nOut = 0
+        }
+    case EndLive:
+        if nOut > nItems {
+            nOut = nItems
+        }
+        //  Items can't be out before they were first published, and the rerun
+        // waits at the first one that isn't. That's not a search: rerun order
+        // isn't always date order (by episode, a re-upload is much newer than
+        // the ones around it), so go through them all in one trip to the feed.
+        all := f.Items(0, f.LenItems())
+        for k := 0; k < nOut; k++ {
+            pub, err := all[order[k]].PubDate()
+            if err != nil {
+                return nil, err
+            }
+            if !pub.Before(t) {
+                nOut = k
+                break
+            }
+        }
+    default:
+        return nil, errors.New("unknown end policy " + d.End.String())
+    }
+
+    first := nOut - n
+    if first < 0 {
+        first = 0
+    }
+    ret := make([]Item, nOut - first)
+    for k := first; k < nOut; k++ {
+        // work on a copy, so the feed itself keeps its dates
+        it := f.Item(order[k % nItems]).Clone()
+        date, err := d.DateAt(k)
+        if err != nil {
+            return nil, err
+        }
+        if lap := k / nItems; lap > 0 {
+            //  a replay of an item from an earlier lap, which needs a new guid
+            // for podcatchers to pick it up again
+            guid, err := it.Guid()
+            if err != nil {
+                //  Not even a title or link to make one up from, so go by where
+                // it is in the feed, counting from the oldest so it stays put
+                // as the feed grows.
+                guid = "item-" + strconv.Itoa(f.LenItems() - order[k % nItems] - 1)
+            }
+            if err = it.SetGuid(guid + "#loop-" + strconv.Itoa(lap)); err != nil {
+                return nil, err
+            }
+        }
+        if d.End == EndLive {
+            pub, err := it.PubDate()
+            if err != nil {
+                return nil, err
+            }
+            if pub.After(date) {
+                date = pub
+            }
+        }
+        if err = markRerun(it, d); err != nil {
+            return nil, err
+        }
+        it.SetPubDate(date)
+        // most recent first
+        ret[nOut - k - 1] = it
+    }
+    return ret, nil
+}
+
+//  Like `univShiftedAt()`, but keeping the original spacing between items (see
+// `DateSource.Speed`). This relies on the feed's pubDates being in order, and
+// only ever returns items that have already come out by `t`.
+func cadenceShiftedAt(n int, t time.Time, f Feed, d *DateSource) ([]Item, error) {
+    if d.End == EndLoop {
+        return nil, errors.New("can't loop a rerun in original cadence mode")
+    }
+    if d.ByEpisode {
+        //  the cadence comes from the pubDates, so they'd better be good enough
+        // to order by as well
+        return nil, errors.New("can't order by episode in original cadence mode")
+    }
+    order := rerunOrder(f, d)
+    nItems := len(order)
+    if nItems == 0 {
+        return []Item{}, nil
+    }
+    //  If there's a `Burst`, replay as if the feed started with the last item
+    // of the burst, and the ones before it all come out with it.
+    oldest := 0
+    if d.Burst > 1 {
+        oldest = d.Burst - 1
+        if oldest >= nItems {
+            oldest = nItems - 1
+        }
+    }
+    origin, err := f.Item(order[oldest]).PubDate()
+    if err != nil {
+        return nil, err
+    }
+    cutoff := d.CadenceCutoff(origin, t)
+    if cutoff.Before(origin) {
+        // the rerun hasn't started yet, burst or not
+        return []Item{}, nil
+    }
+    if d.End == EndLive && t.Before(cutoff) {
+        // caught up, so only what's actually been published
+        cutoff = t
+    }
+    var searchErr error
+    // how many items, counting from the oldest, are out by `t`?
+    nOut := sort.Search(nItems, func(i int) bool {
+        pub, err := f.Item(order[i]).PubDate()
+        if err != nil {
+            searchErr = err
+            return true
+        }
+        return pub.After(cutoff)
+    })
+    if searchErr != nil {
+        return nil, searchErr
+    }
 
     nret := n
-    if nret + nskip > nItems {
-        // we were asked for more items than are left after skipping ahead. The
-        // only time I see this happening is if `nskip == 0`, so I'm not sure
-        // why `nskip` is involved here. I would guess I hit an edge case at
-        // some point?
-        nret = nItems - nskip
+    if nret > nOut {
+        nret = nOut
     }
     ret := make([]Item, nret)
-    // TODO should I be making copies of `Item`s here? It seems weird to change
-    // their pubDates without making a copy.
     for i := 0; i < nret; i++ {
-        // last -1 needed because its[len(its) - x] is (x - 1)'th from the back
-        ret[nret - i - 1] = f.Item(nItems - (nskip + i) - 1)
-        nd, _ := d.NextDate()
-        ret[nret - i - 1].SetPubDate(nd)
+        // most recent first, so ret[0] is the (nOut - 1)th oldest
+        it := f.Item(order[nOut - i - 1]).Clone()
+        pub, err := it.PubDate()
+        if err != nil {
+            return nil, err
+        }
+        date := d.CadenceDate(origin, pub)
+        if d.End == EndLive && pub.After(date) {
+            date = pub
+        }
+        if err = markRerun(it, d); err != nil {
+            return nil, err
+        }
+        it.SetPubDate(date)
+        ret[i] = it
     }
     return ret, nil
 }
 
+//  Before `it` gets a new pubdate, keep track of the original one (see
+// `DateSource.NoteOriginal`). If it's already a rerun, the first date is kept.
+func markRerun(it Item, d *DateSource) error {
+    orig, err := it.OriginalPubDate()
+    if err != nil {
+        orig, err = it.PubDate()
+        if err != nil {
+            // nothing to keep track of
+            return nil
+        }
+        if err = it.SetOriginalPubDate(orig); err != nil {
+            return err
+        }
+    }
+    if d.NoteOriginal {
+        return it.AddNote("Originally published on " +
+                          orig.Format("January 2, 2006") + ".\n\n")
+    }
+    return nil
+}
+
 type RssFeed struct {
     root xml.Node
+    // the wrapper, split where the items go (see `splitWrapper()`)
+    parts [][]byte
     itemNodes []xml.Node
     items []Item
     d *DateSource
@@ -89,6 +253,15 @@ func (f *RssFeed) ShiftedAt(n int, t time.Time) ([]Item, error) {
     return univShiftedAt(n, t, f, f.d)
 }
 
+func (f *RssFeed) Info() FeedInfo {
+    return FeedInfo{
+        tryContent(f.root, "channel/title"),
+        tryContent(f.root, "channel/link"),
+        tryContent(f.root, "channel/description"),
+        tryContent(f.root, "channel/" + podcastXpath("guid")),
+    }
+}
+
 func (f *RssFeed) Items(start, end int) []Item {
     return f.items[start:end]
 }
@@ -138,14 +311,21 @@ func newRssRaw(doc xml.Document) (*RssFeed, error) {
     if err != nil {
         return nil, err
     }
+    // insert a placeholder
+    placeholder := doc.CreateElementNode("item")
     if len(f.itemNodes) > 0 {
-        // insert a placeholder
-        f.itemNodes[0].InsertBefore(doc.CreateElementNode("item"))
+        f.itemNodes[0].InsertBefore(placeholder)
+    } else {
+        channels[0].AddChild(placeholder)
     }
     // remove all the `item`s. Don't worry, we saved them in `f.itemNodes`.
     for _, item := range f.itemNodes {
         item.Unlink()
     }
+    f.parts, err = splitWrapper(f.root, []xml.Node{placeholder})
+    if err != nil {
+        return nil, err
+    }
     return f, nil
 }
 
@@ -154,19 +334,16 @@ func (f *RssFeed) Wrapper() []byte {
 }
 
 func (f *RssFeed) BytesWithItems(items []Item) []byte {
-    its := make([]xml.Node, len(items))
-    for i, item := range items {
-        its[i] = item.Node()
-    }
-    return f.bytesWithNodes(its)
+    return bytesWithItems(f, items)
 }
 
-func (f *RssFeed) bytesWithNodes(nodes []xml.Node) []byte {
-    return insertForRender(f.root, nodes, "channel/item")
+func (f *RssFeed) WriteWithItems(w io.Writer, items []Item) error {
+    return writeSplit(w, f.parts, itemWriter(items))
 }
 
 type AtomFeed struct {
     root xml.Node
+    parts [][]byte
     entries []xml.Node
     items []Item
     d *DateSource
@@ -179,6 +356,25 @@ func (a *AtomFeed) makeItems() {
     }
 }
 
+func (a *AtomFeed) Info() FeedInfo {
+    link := ""
+    links, err := a.root.Search(xpath("link"))
+    if err == nil {
+        for _, tag := range links {
+            if rel := tag.Attr("rel"); rel == "" || rel == "alternate" {
+                link = tag.Attr("href")
+                break
+            }
+        }
+    }
+    return FeedInfo{
+        tryContent(a.root, xpath("title")),
+        link,
+        tryContent(a.root, xpath("subtitle")),
+        tryContent(a.root, podcastXpath("guid")),
+    }
+}
+
 func (a *AtomFeed) Items(start, end int) []Item {
     return a.items[start:end]
 }
@@ -203,16 +399,12 @@ func (a *AtomFeed) ShiftedAt(n int, t time.Time) ([]Item, error) {
     return univShiftedAt(n, t, a, a.d)
 }
 
-func (f *AtomFeed) BytesWithItems(items []Item) []byte {
-    its := make([]xml.Node, len(items))
-    for i, item := range items {
-        its[i] = item.Node()
-    }
-    return f.bytesWithNodes(its)
+func (a *AtomFeed) BytesWithItems(items []Item) []byte {
+    return bytesWithItems(a, items)
 }
 
-func (a *AtomFeed) bytesWithNodes(nodes []xml.Node) []byte {
-    return insertForRender(a.root, nodes, "//*[local-name()='entry']")
+func (a *AtomFeed) WriteWithItems(w io.Writer, items []Item) error {
+    return writeSplit(w, a.parts, itemWriter(items))
 }
 
 func (a *AtomFeed) Wrapper() []byte {
@@ -232,9 +424,9 @@ func newAtomRaw(doc xml.Document)  (*AtomFeed, error) {
         return nil, err
     }
     // as in the RSS case, create a placeholder and remove all the `entry` tags
+    placeholder := doc.CreateElementNode("entry")
     if len(a.entries) > 0 {
         first := a.entries[0]
-        placeholder := doc.CreateElementNode("entry")
         namespace := first.Namespace()
         for _, ns := range first.DeclaredNamespaces() {
             if ns.Uri == namespace {
@@ -242,16 +434,39 @@ func newAtomRaw(doc xml.Document)  (*AtomFeed, error) {
             }
         }
         first.InsertBefore(placeholder)
+    } else {
+        //  no entries to go by, but they go in the feed's namespace (whatever
+        // it calls it)
+        doc.Root().AddChild(placeholder)
+        placeholder.SetNamespace("", doc.Root().Namespace())
     }
     for _, entry := range a.entries {
         entry.Unlink()
     }
-
+    a.parts, err = splitWrapper(a.root, []xml.Node{placeholder})
+    if err != nil {
+        return nil, err
+    }
     return a, nil
 }
 
-// Make a best guess at parsing a document as an RSS or Atom feed.
+//  Make a best guess at parsing a document as an RSS, Atom, or JSON feed. If
+// it's not in UTF-8, it's transcoded first (see `ToUTF8()`), going by its own
+// XML declaration. Use `ToUTF8()` first to go by an HTTP header instead.
 func NewFeed(t []byte, d *DateSource) (Feed, error) {
+    t, err := ToUTF8(t, "")
+    if err != nil {
+        return nil, err
+    }
+    if looksLikeJSON(t) {
+        jf, err := newJSONFeedRaw(t)
+        if err != nil {
+            return nil, errors.New("Couldn't parse feed as JSON Feed: \"" +
+                                   err.Error() + "\"")
+        }
+        jf.d = d
+        return Feed(jf), nil
+    }
     doc, err := gokogiri.ParseXml(t)
     if err != nil {
         return nil, err
@@ -268,33 +483,13 @@ func NewFeed(t []byte, d *DateSource) (Feed, error) {
         atom.d = d
         return Feed(atom), nil
     }
-    return nil, errors.New("Couldn't parse feed as RSS: \"" + rssErr.Error() +
-                           "\", nor as ATOM: \"" + atomErr.Error() + "\"")
-}
-
-// Here is where the magic of re-populating a feed from the placeholder happens
-func insertForRender(parent xml.Node, children []xml.Node, where string) []byte {
-    placeholder, err := parent.Search(where)
-    if err != nil {
-        // weird, the placeholder isn't there
-        return parent.ToBuffer(nil)
-    }
-    lastchild := placeholder[0]
-    for _, child := range children {
-        if err = lastchild.AddNextSibling(child); err != nil {
-            return nil
-        }
-        lastchild = child
-    }
-    placeholder[0].Unlink()
-    // prepare a copy to return
-    retval := parent.ToBuffer(nil)
-    // go back to the original feed, with place holder
-    if err = children[0].AddPreviousSibling(placeholder); err != nil {
-        return nil
+    rdf, rdfErr := newRdfRaw(doc)
+    if rdfErr == nil {
+        rdf.makeItems()
+        rdf.d = d
+        return Feed(rdf), nil
     }
-    for _, child := range children {
-        child.Unlink()
-    }
-    return retval
+    return nil, errors.New("Couldn't parse feed as RSS: \"" + rssErr.Error() +
+                           "\", nor as ATOM: \"" + atomErr.Error() +
+                           "\", nor as RSS 1.0: \"" + rdfErr.Error() + "\"")
 }
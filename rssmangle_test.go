@@ -2,6 +2,7 @@ package rssrerun
 
 import (
     "strconv"
+    "strings"
     "testing"
     "time"
 
@@ -9,6 +10,32 @@ import (
     "github.com/jbowtie/gokogiri"
 )
 
+func TestAddNoteHtml(t *testing.T) {
+    note := "Originally published on April 12, 2015.\n\n"
+    for src, want := range map[string]string{
+        "<item><description>plain &amp; simple</description></item>":
+            note + "plain & simple",
+        "<item><description>&lt;b&gt;bold&lt;/b&gt; &amp;amp; brash</description></item>":
+            "<p>Originally published on April 12, 2015.</p><b>bold</b> &amp; brash",
+        "<entry><content type=\"html\">&lt;i&gt;hi&lt;/i&gt;</content></entry>":
+            "<p>Originally published on April 12, 2015.</p><i>hi</i>",
+        "<entry><summary type=\"html\">&lt;i&gt;hi&lt;/i&gt;</summary></entry>":
+            "<p>Originally published on April 12, 2015.</p><i>hi</i>",
+        "<entry><content>1 &lt; 2</content></entry>": note + "1 < 2",
+    } {
+        it, err := MkItem([]byte(src))
+        if err != nil {
+            t.Fatal(err)
+        }
+        if err = it.AddNote(note); err != nil {
+            t.Fatal(err)
+        }
+        if got := it.Render().Description; got != want {
+            t.Errorf("%s: expected %q, got %q", src, want, got)
+        }
+    }
+}
+
 func TestCreateRssItem(t *testing.T) {
     rsstxt := "<item><title>Actual rss item</title>"
     rsstxt += "<pubDate>" + testhelp.StartDate().Format(time.RFC822) + "</pubDate>"
@@ -42,6 +69,28 @@ func TestCreateRssItem(t *testing.T) {
     }
 }
 
+func TestCloneItem(t *testing.T) {
+    rsstxt := "<item><title>Actual rss item</title>"
+    rsstxt += "<pubDate>" + testhelp.StartDate().Format(time.RFC822) + "</pubDate>"
+    rsstxt += "<guid>32</guid></item>"
+    it, err := MkItem([]byte(rsstxt))
+    if err != nil {
+        t.Fatal(err)
+    }
+    clone := it.Clone()
+    clone.SetPubDate(testhelp.StartDate().AddDate(1, 0, 0))
+    clone.SetGuid("33")
+    if pd, _ := it.PubDate(); !pd.Equal(testhelp.StartDate()) {
+        t.Errorf("changing the clone changed the original's date to %v", pd)
+    }
+    if g, _ := it.Guid(); g != "32" {
+        t.Errorf("changing the clone changed the original's guid to %s", g)
+    }
+    if g, _ := clone.Guid(); g != "33" {
+        t.Errorf("expected the clone's guid to be 33, got %s", g)
+    }
+}
+
 func TestRssHandleCDATA(t *testing.T) {
     rss := testhelp.CreateAndPopulateRSS(2, testhelp.StartDate())
     breakText := "<item><title>pre-CDATA</title><description><![CDATA["
@@ -120,6 +169,55 @@ func testTimeShift(t *testing.T, tf testhelp.TestFeed,
     }
 }
 
+func TestRssShiftLeavesFeed(t *testing.T) {
+    testShiftLeavesFeed(t, testhelp.CreateAndPopulateRSS(10, testhelp.StartDate()),
+                        NewFeed)
+}
+
+func TestAtomShiftLeavesFeed(t *testing.T) {
+    testShiftLeavesFeed(t, testhelp.CreateAndPopulateATOM(10, testhelp.StartDate()),
+                        NewFeed)
+}
+
+func testShiftLeavesFeed(t *testing.T, tf testhelp.TestFeed,
+                         newFeed func([]byte, *DateSource)(Feed, error)) {
+    sched := []time.Weekday{time.Sunday, time.Tuesday}
+    rerun := NewDateSource(testhelp.StartDate().AddDate(0, 2, 0), sched)
+    feed, err := newFeed(tf.Bytes(), rerun)
+    if err != nil {
+        t.Fatal(err)
+    }
+    before := make([]time.Time, feed.LenItems())
+    for i := range before {
+        before[i], _ = feed.Item(i).PubDate()
+    }
+
+    when := testhelp.StartDate().AddDate(0, 0, 80)
+    first, err := feed.ShiftedAt(5, when)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for i := range before {
+        if pd, _ := feed.Item(i).PubDate(); !pd.Equal(before[i]) {
+            t.Errorf("item %d changed from %v to %v", i, before[i], pd)
+        }
+    }
+    // and so asking again gets the same answer
+    again, err := feed.ShiftedAt(5, when)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(again) != len(first) {
+        t.Fatalf("expected %d items again, got %d", len(first), len(again))
+    }
+    for i := range first {
+        if first[i].String() != again[i].String() {
+            t.Errorf("item %d came out different the second time: %s vs %s",
+                     i, first[i].String(), again[i].String())
+        }
+    }
+}
+
 func TestRssLatestFive(t *testing.T) {
     rss := testhelp.CreateAndPopulateRSS(100, testhelp.StartDate().AddDate(-3, 0, 0))
     testLatestFive(t, rss)
@@ -162,15 +260,8 @@ func testLatestFive(t *testing.T, tf testhelp.TestFeed) {
         prev = itdate
     }
 
-    // TODO I don't like reaching in to the object like this
-    var future time.Time
-    if rssfeed, ok := feed.(*RssFeed); ok {
-        future, err = rssfeed.d.NextDate()
-    } else if atomfeed, ok := feed.(*AtomFeed); ok {
-        future, err = atomfeed.d.NextDate()
-    } else {
-        t.Fatal("feed object that is neither RSS nor Atom")
-    }
+    // the next date after what was returned should still be in the future
+    future, err := rerun.DateAt(rerun.DatesInRange(rerun.StartDate, now))
     if err != nil {
         t.Fatal(err)
     }
@@ -275,3 +366,387 @@ func TestAtomFrontMatter(t *testing.T) {
         t.Fatalf("should have 1 and only 1 <entry> tag. found %d", len(its))
     }
 }
+
+func TestRssOriginalCadence(t *testing.T) {
+    testOriginalCadence(t, testhelp.CreateAndPopulateRSS(10, testhelp.StartDate()))
+}
+
+func TestAtomOriginalCadence(t *testing.T) {
+    testOriginalCadence(t, testhelp.CreateAndPopulateATOM(10, testhelp.StartDate()))
+}
+
+func testOriginalCadence(t *testing.T, tf testhelp.TestFeed) {
+    // a weekly feed, played back twice as fast
+    rerun := NewScheduledDateSource(testhelp.StartDate().AddDate(1, 0, 0), nil)
+    rerun.Speed = 2
+    feed, err := NewFeed(tf.Bytes(), rerun)
+    if err != nil {
+        t.Fatal(err)
+    }
+    start := rerun.StartDate
+    // out at 0, 3.5, 7 days, but not 10.5
+    items, err := feed.ShiftedAt(5, start.AddDate(0, 0, 10))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(items) != 3 {
+        t.Fatalf("expected 3 items, got %d", len(items))
+    }
+    for i, it := range items {
+        pd, err := it.PubDate()
+        if err != nil {
+            t.Fatal(err)
+        }
+        expected := start.Add(time.Duration(2 - i) * 84 * time.Hour)
+        if !pd.Equal(expected) {
+            t.Errorf("item %d should be at %v, got %v", i, expected, pd)
+        }
+        if g, _ := it.Guid(); g != strconv.Itoa(3 - i) {
+            t.Errorf("item %d should be guid %d, got %s", i, 3 - i, g)
+        }
+    }
+
+    // and the window only has the latest ones
+    items, err = feed.ShiftedAt(2, start.AddDate(0, 0, 30))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(items) != 2 {
+        t.Fatalf("expected 2 items, got %d", len(items))
+    }
+    if g, _ := items[0].Guid(); g != "9" {
+        t.Errorf("expected the latest item to be guid 9, got %s", g)
+    }
+}
+
+func TestCadenceKeepsGaps(t *testing.T) {
+    rss := testhelp.CreateAndPopulateRSS(0, testhelp.StartDate())
+    // a burst of two, a long gap, and one more
+    for i, offset := range []int{40, 3, 2} {
+        pubdate := testhelp.StartDate().AddDate(0, 0, offset).Format(time.RFC822)
+        rss.AddPost("<item><title>post</title><pubDate>" + pubdate +
+                    "</pubDate><guid>" + strconv.Itoa(3 - i) + "</guid></item>")
+    }
+    rerun := NewScheduledDateSource(testhelp.StartDate().AddDate(1, 0, 0), nil)
+    rerun.Speed = 1
+    feed, err := NewFeed(rss.Bytes(), rerun)
+    if err != nil {
+        t.Fatal(err)
+    }
+    items, err := feed.ShiftedAt(5, rerun.StartDate.AddDate(0, 0, 37))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(items) != 2 {
+        t.Fatalf("expected 2 items, got %d", len(items))
+    }
+    first, _ := items[1].PubDate()
+    second, _ := items[0].PubDate()
+    if second.Sub(first) != 24 * time.Hour {
+        t.Errorf("expected items a day apart, got %v", second.Sub(first))
+    }
+}
+
+func TestRssBurst(t *testing.T) {
+    testBurst(t, testhelp.CreateAndPopulateRSS(10, testhelp.StartDate()))
+}
+
+func TestAtomBurst(t *testing.T) {
+    testBurst(t, testhelp.CreateAndPopulateATOM(10, testhelp.StartDate()))
+}
+
+func testBurst(t *testing.T, tf testhelp.TestFeed) {
+    start := testhelp.StartDate().AddDate(1, 0, 0)
+    rerun := NewDateSource(start, []time.Weekday{time.Monday})
+    rerun.Burst = 3
+    feed, err := NewFeed(tf.Bytes(), rerun)
+    if err != nil {
+        t.Fatal(err)
+    }
+    items, err := feed.ShiftedAt(5, rerun.StartDate.Add(time.Minute))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(items) != 3 {
+        t.Fatalf("expected the first 3 items right away, got %d", len(items))
+    }
+    for i, it := range items {
+        if g, _ := it.Guid(); g != strconv.Itoa(3 - i) {
+            t.Errorf("item %d should be guid %d, got %s", i, 3 - i, g)
+        }
+        if pd, _ := it.PubDate(); !pd.Equal(rerun.StartDate) {
+            t.Errorf("item %d should be out at %v, got %v", i,
+                     rerun.StartDate, pd)
+        }
+    }
+}
+
+func TestCadenceBurst(t *testing.T) {
+    rerun := NewScheduledDateSource(testhelp.StartDate().AddDate(1, 0, 0), nil)
+    rerun.Speed = 1
+    rerun.Burst = 3
+    feed, err := NewFeed(testhelp.CreateAndPopulateRSS(10,
+                                                     testhelp.StartDate()).Bytes(),
+                         rerun)
+    if err != nil {
+        t.Fatal(err)
+    }
+    // the burst right away, then the 4th item a week later
+    items, err := feed.ShiftedAt(5, rerun.StartDate.AddDate(0, 0, 7))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(items) != 4 {
+        t.Fatalf("expected 4 items, got %d", len(items))
+    }
+    for i, it := range items[1:] {
+        if pd, _ := it.PubDate(); !pd.Equal(rerun.StartDate) {
+            t.Errorf("item %d should be out at %v, got %v", i + 1,
+                     rerun.StartDate, pd)
+        }
+    }
+    if pd, _ := items[0].PubDate(); !pd.Equal(rerun.StartDate.AddDate(0, 0, 7)) {
+        t.Errorf("expected the 4th item a week in, got %v", pd)
+    }
+}
+
+func TestEndStop(t *testing.T) {
+    rerun := NewDateSource(testhelp.StartDate(), []time.Weekday{time.Sunday})
+    feed, err := NewFeed(testhelp.CreateAndPopulateRSS(10,
+                                                     testhelp.StartDate()).Bytes(),
+                         rerun)
+    if err != nil {
+        t.Fatal(err)
+    }
+    // long after the rerun is over, we stay on the last items
+    items, err := feed.ShiftedAt(5, testhelp.StartDate().AddDate(2, 0, 0))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(items) != 5 {
+        t.Fatalf("expected 5 items, got %d", len(items))
+    }
+    for i, it := range items {
+        if g, _ := it.Guid(); g != strconv.Itoa(10 - i) {
+            t.Errorf("item %d should be guid %d, got %s", i, 10 - i, g)
+        }
+        expected, _ := rerun.DateAt(9 - i)
+        if pd, _ := it.PubDate(); !pd.Equal(expected) {
+            t.Errorf("item %d should be at %v, got %v", i, expected, pd)
+        }
+    }
+}
+
+func TestRssEndLoop(t *testing.T) {
+    testEndLoop(t, testhelp.CreateAndPopulateRSS(3, testhelp.StartDate()))
+}
+
+func TestAtomEndLoop(t *testing.T) {
+    testEndLoop(t, testhelp.CreateAndPopulateATOM(3, testhelp.StartDate()))
+}
+
+func testEndLoop(t *testing.T, tf testhelp.TestFeed) {
+    rerun := NewScheduledDateSource(testhelp.StartDate(), DayInterval(1))
+    rerun.End = EndLoop
+    feed, err := NewFeed(tf.Bytes(), rerun)
+    if err != nil {
+        t.Fatal(err)
+    }
+    // 8 episodes out, so we're on the third time through
+    items, err := feed.ShiftedAt(5, rerun.StartDate.AddDate(0, 0, 7).Add(time.Hour))
+    if err != nil {
+        t.Fatal(err)
+    }
+    expected := []string{"2#loop-2", "1#loop-2", "3#loop-1", "2#loop-1",
+                         "1#loop-1"}
+    if len(items) != len(expected) {
+        t.Fatalf("expected %d items, got %d", len(expected), len(items))
+    }
+    for i, it := range items {
+        if g, _ := it.Guid(); g != expected[i] {
+            t.Errorf("item %d should be guid %s, got %s", i, expected[i], g)
+        }
+        date, _ := rerun.DateAt(7 - i)
+        if pd, _ := it.PubDate(); !pd.Equal(date) {
+            t.Errorf("item %d should be at %v, got %v", i, date, pd)
+        }
+    }
+    // the items in the feed itself keep their guids
+    if g, _ := feed.Item(2).Guid(); g != "1" {
+        t.Errorf("looping changed the original guid to %s", g)
+    }
+}
+
+func TestEndLive(t *testing.T) {
+    // weekly, but replayed daily from two weeks in, so it catches up
+    rerun := NewScheduledDateSource(testhelp.StartDate().AddDate(0, 0, 14),
+                                    DayInterval(1))
+    rerun.End = EndLive
+    feed, err := NewFeed(testhelp.CreateAndPopulateRSS(10,
+                                                     testhelp.StartDate()).Bytes(),
+                         rerun)
+    if err != nil {
+        t.Fatal(err)
+    }
+    // the first 3 are on schedule, then the 4th and 5th wait to be published
+    items, err := feed.ShiftedAt(10, testhelp.StartDate().AddDate(0, 0, 30))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(items) != 5 {
+        t.Fatalf("expected 5 items, got %d", len(items))
+    }
+    for i, it := range items {
+        if g, _ := it.Guid(); g != strconv.Itoa(5 - i) {
+            t.Errorf("item %d should be guid %d, got %s", i, 5 - i, g)
+        }
+        expected, _ := rerun.DateAt(4 - i)
+        if i < 2 {
+            expected = testhelp.StartDate().AddDate(0, 0, 7 * (4 - i))
+        }
+        if pd, _ := it.PubDate(); !pd.Equal(expected) {
+            t.Errorf("item %d should be at %v, got %v", i, expected, pd)
+        }
+    }
+}
+
+func TestRssEndLoopNoGuid(t *testing.T) {
+    rss := testhelp.CreateAndPopulateRSS(0, testhelp.StartDate())
+    rss.AddPost("<item><guid>a</guid><pubDate>" +
+                testhelp.StartDate().AddDate(0, 0, 7).Format(time.RFC822) +
+                "</pubDate></item>")
+    rss.AddPost("<item><pubDate>" +
+                testhelp.StartDate().Format(time.RFC822) + "</pubDate></item>")
+    testEndLoopNoGuid(t, rss)
+}
+
+func TestAtomEndLoopNoGuid(t *testing.T) {
+    atom := testhelp.CreateAndPopulateATOM(0, testhelp.StartDate())
+    atom.AddPost("<id>a</id><published>" +
+                 testhelp.StartDate().AddDate(0, 0, 7).Format(time.RFC3339) +
+                 "</published>")
+    atom.AddPost("<published>" + testhelp.StartDate().Format(time.RFC3339) +
+                 "</published>")
+    testEndLoopNoGuid(t, atom)
+}
+
+//  Looping needs a new guid for each replay, even for an item that has nothing
+// to make one up from.
+func testEndLoopNoGuid(t *testing.T, tf testhelp.TestFeed) {
+    rerun := NewScheduledDateSource(testhelp.StartDate(), DayInterval(1))
+    rerun.End = EndLoop
+    feed, err := NewFeed(tf.Bytes(), rerun)
+    if err != nil {
+        t.Fatal(err)
+    }
+    // 4 episodes out, so both items are on their second time through
+    items, err := feed.ShiftedAt(2, rerun.StartDate.AddDate(0, 0, 3).Add(time.Hour))
+    if err != nil {
+        t.Fatal(err)
+    }
+    // going by where it is in the feed, counting from the oldest
+    expected := []string{"a#loop-1", "item-0#loop-1"}
+    if len(items) != len(expected) {
+        t.Fatalf("expected %d items, got %d", len(expected), len(items))
+    }
+    for i, it := range items {
+        if g, _ := it.Guid(); g != expected[i] {
+            t.Errorf("item %d should be guid %s, got %s", i, expected[i], g)
+        }
+    }
+}
+
+func TestEndLiveOutOfOrder(t *testing.T) {
+    //  Newest first, except that "2" was re-uploaded long after the others, so
+    // the rerun has to wait for it before going on to "3".
+    rss := testhelp.CreateAndPopulateRSS(0, testhelp.StartDate())
+    for _, post := range []struct{
+        guid string
+        day int
+    }{{"5", 3}, {"4", 2}, {"3", 1}, {"2", 20}, {"1", 0}} {
+        rss.AddPost("<item><guid>" + post.guid + "</guid><pubDate>" +
+                    testhelp.StartDate().AddDate(0, 0, post.day).Format(time.RFC822) +
+                    "</pubDate></item>")
+    }
+    rerun := NewScheduledDateSource(testhelp.StartDate(), DayInterval(1))
+    rerun.End = EndLive
+    feed, err := NewFeed(rss.Bytes(), rerun)
+    if err != nil {
+        t.Fatal(err)
+    }
+    items, err := feed.ShiftedAt(10, testhelp.StartDate().AddDate(0, 0, 10))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if g, _ := items[0].Guid(); len(items) != 1 || g != "1" {
+        t.Errorf("expected to be waiting after 1, got %d items", len(items))
+    }
+
+    // and once it's out, the rest follow
+    items, err = feed.ShiftedAt(10, testhelp.StartDate().AddDate(0, 0, 21))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(items) != 5 {
+        t.Fatalf("expected 5 items, got %d", len(items))
+    }
+    for i, it := range items {
+        if g, _ := it.Guid(); g != strconv.Itoa(5 - i) {
+            t.Errorf("item %d should be guid %d, got %s", i, 5 - i, g)
+        }
+    }
+    if pd, _ := items[3].PubDate(); !pd.Equal(testhelp.StartDate().AddDate(0, 0, 20)) {
+        t.Errorf("2 shouldn't be out before it was published, got %v", pd)
+    }
+}
+
+func TestRssOriginalPubDate(t *testing.T) {
+    testOriginalPubDate(t, testhelp.CreateAndPopulateRSS(10, testhelp.StartDate()))
+}
+
+func TestAtomOriginalPubDate(t *testing.T) {
+    testOriginalPubDate(t, testhelp.CreateAndPopulateATOM(10, testhelp.StartDate()))
+}
+
+func testOriginalPubDate(t *testing.T, tf testhelp.TestFeed) {
+    rerun := NewDateSource(testhelp.StartDate().AddDate(1, 0, 0),
+                           []time.Weekday{time.Sunday})
+    feed, err := NewFeed(tf.Bytes(), rerun)
+    if err != nil {
+        t.Fatal(err)
+    }
+    when := rerun.StartDate.AddDate(0, 1, 0)
+    items, err := feed.ShiftedAt(3, when)
+    if err != nil {
+        t.Fatal(err)
+    }
+    nOut := rerun.DatesInRange(rerun.StartDate, when)
+    for i, it := range items {
+        orig, _ := feed.Item(feed.LenItems() - nOut + i).PubDate()
+        if got, err := it.OriginalPubDate(); err != nil || !got.Equal(orig) {
+            t.Errorf("item %d should have originally been %v, got %v (%v)",
+                     i, orig, got, err)
+        }
+        if strings.HasPrefix(it.Render().Description, "Originally") {
+            t.Errorf("item %d has a note when it shouldn't", i)
+        }
+    }
+    if !strings.Contains(string(feed.BytesWithItems(items)),
+                         "rerun:originalPubDate") {
+        t.Error("no <rerun:originalPubDate> tag in the output")
+    }
+
+    rerun.NoteOriginal = true
+    items, err = feed.ShiftedAt(3, when)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for i, it := range items {
+        orig, _ := it.OriginalPubDate()
+        note := "Originally published on " + orig.Format("January 2, 2006")
+        if !strings.HasPrefix(it.Render().Description, note) {
+            t.Errorf("item %d description should start with \"%s\": %s", i,
+                     note, it.Render().Description)
+        }
+    }
+}
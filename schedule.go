@@ -0,0 +1,589 @@
+package rssrerun
+
+import (
+    "errors"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+//  A `Schedule` decides which days a rerun publishes on. Days are handled as
+// midnight UTC `time.Time`s (which is what `DateSource` works in), and `start`
+// is the first day of the rerun so that schedules like "every third day" have
+// something to count from.
+//
+//  Every `Schedule` can be written out in a compact string form with
+// `String()`, and read back in with `ParseSchedule()`. This is what gets
+// passed around in urls.
+type Schedule interface {
+    // is `day` a publishing day, for a rerun that started on `start`?
+    Contains(start, day time.Time) bool
+    // compact string form, as understood by `ParseSchedule()`
+    String() string
+}
+
+var dayAbbrevs = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+//  The original kind of schedule: publish on every one of these days of the
+// week (eg, Monday-Wednesday-Friday).
+type Weekdays []time.Weekday
+
+func (w Weekdays) Contains(start, day time.Time) bool {
+    weekday := day.Weekday()
+    for _, wd := range w {
+        if wd == weekday {
+            return true
+        }
+    }
+    return false
+}
+
+func (w Weekdays) countDays(start, from, to time.Time) int {
+    // every week, from any week
+    return WeekInterval{1, w}.countDays(start, from, to)
+}
+
+func (w Weekdays) String() string {
+    ret := ""
+    for _, wd := range w {
+        ret += strconv.Itoa(int(wd))
+    }
+    return ret
+}
+
+// Publish every `n` days, counting from the start of the rerun.
+type DayInterval int
+
+func (n DayInterval) Contains(start, day time.Time) bool {
+    if n <= 0 {
+        return false
+    }
+    ndays := daysBetween(start, day)
+    return ndays >= 0 && ndays % int(n) == 0
+}
+
+func (n DayInterval) countDays(start, from, to time.Time) int {
+    if n <= 0 {
+        return 0
+    }
+    return (ceilDiv(daysBetween(start, to), int(n)) -
+            ceilDiv(daysBetween(start, from), int(n)))
+}
+
+func (n DayInterval) String() string {
+    return "days:" + strconv.Itoa(int(n))
+}
+
+//  Publish on `Days` of the week, but only every `Weeks` weeks (eg, every
+// other Tuesday). The week the rerun starts in is the first week published,
+// and weeks start on Sunday.
+type WeekInterval struct {
+    Weeks int
+    Days Weekdays
+}
+
+func (w WeekInterval) Contains(start, day time.Time) bool {
+    if w.Weeks <= 0 || !w.Days.Contains(start, day) {
+        return false
+    }
+    nweeks := daysBetween(startOfWeek(start), startOfWeek(day)) / 7
+    return nweeks >= 0 && nweeks % w.Weeks == 0
+}
+
+func (w WeekInterval) countDays(start, from, to time.Time) int {
+    if w.Weeks <= 0 {
+        return 0
+    }
+    //  Each day of the week on the schedule lands every `cycle` days, offset by
+    // its weekday from the Sunday of the first week.
+    anchor := startOfWeek(start)
+    a, b := daysBetween(anchor, from), daysBetween(anchor, to)
+    cycle := 7 * w.Weeks
+    var seen [7]bool
+    n := 0
+    for _, wd := range w.Days {
+        if seen[wd] {
+            continue
+        }
+        seen[wd] = true
+        n += ceilDiv(b - int(wd), cycle) - ceilDiv(a - int(wd), cycle)
+    }
+    return n
+}
+
+func (w WeekInterval) String() string {
+    return "weeks:" + strconv.Itoa(w.Weeks) + ":" + w.Days.String()
+}
+
+//  The `N`th `Day` of the month (eg, the 3rd Friday). Negative `N` counts
+// back from the end of the month, so -1 is the last `Day` of the month.
+type NthWeekday struct {
+    N int
+    Day time.Weekday
+}
+
+func (nw NthWeekday) matches(day time.Time) bool {
+    if day.Weekday() != nw.Day {
+        return false
+    }
+    if nw.N > 0 {
+        return (day.Day() - 1) / 7 + 1 == nw.N
+    }
+    return (daysInMonth(day.Year(), day.Month()) - day.Day()) / 7 + 1 == -nw.N
+}
+
+// day of the month that this lands on, or 0 if there isn't one
+func (nw NthWeekday) dayIn(year int, month time.Month) int {
+    ndays := daysInMonth(year, month)
+    if nw.N > 0 {
+        first := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC).Weekday()
+        day := 1 + (int(nw.Day) - int(first) + 7) % 7 + 7 * (nw.N - 1)
+        if day > ndays {
+            return 0
+        }
+        return day
+    }
+    last := time.Date(year, month, ndays, 0, 0, 0, 0, time.UTC).Weekday()
+    day := ndays - (int(last) - int(nw.Day) + 7) % 7 - 7 * (-nw.N - 1)
+    if day < 1 {
+        return 0
+    }
+    return day
+}
+
+func (nw NthWeekday) String() string {
+    return strconv.Itoa(nw.N) + dayAbbrevs[nw.Day]
+}
+
+// Publish on any of a set of `NthWeekday`s.
+type NthWeekdays []NthWeekday
+
+func (n NthWeekdays) Contains(start, day time.Time) bool {
+    for _, nw := range n {
+        if nw.matches(day) {
+            return true
+        }
+    }
+    return false
+}
+
+func (n NthWeekdays) countDays(start, from, to time.Time) int {
+    return countByMonth(n, start, from, to)
+}
+
+func (n NthWeekdays) countInMonth(year int, month time.Month) int {
+    // "5th Monday" and "last Monday" can be the same day
+    var seen [32]bool
+    count := 0
+    for _, nw := range n {
+        if day := nw.dayIn(year, month); day > 0 && !seen[day] {
+            seen[day] = true
+            count++
+        }
+    }
+    return count
+}
+
+func (n NthWeekdays) String() string {
+    strs := make([]string, len(n))
+    for i, nw := range n {
+        strs[i] = nw.String()
+    }
+    return "nth:" + strings.Join(strs, ",")
+}
+
+//  A cron-like schedule, using only the date fields of a crontab line: day of
+// month, month, and day of week (eg, "1,15 * *" is the 1st and 15th of every
+// month). Each field can be `*`, a number, a range `a-b`, a step `*/n` or
+// `a-b/n`, or a comma-separated list of those. As with cron, if both day of
+// month and day of week are restricted then a day matching either will do.
+type Cron struct {
+    expr string
+    dom [32]bool
+    month [13]bool
+    dow [7]bool
+    domStar, dowStar bool
+    // how many days of the month are set in `dom`, up to and including the key
+    domUpTo [32]int
+}
+
+func ParseCron(expr string) (*Cron, error) {
+    fields := strings.Fields(expr)
+    if len(fields) != 3 {
+        return nil, errors.New("cron schedule needs 3 fields " +
+                               "(day of month, month, day of week)")
+    }
+    c := &Cron{expr: strings.Join(fields, " ")}
+    var err error
+    if c.domStar, err = cronField(fields[0], 1, 31, c.dom[:]); err != nil {
+        return nil, err
+    }
+    if _, err = cronField(fields[1], 1, 12, c.month[:]); err != nil {
+        return nil, err
+    }
+    var dow [8]bool
+    if c.dowStar, err = cronField(fields[2], 0, 7, dow[:]); err != nil {
+        return nil, err
+    }
+    // cron lets 7 mean Sunday too
+    copy(c.dow[:], dow[:7])
+    c.dow[0] = c.dow[0] || dow[7]
+    for d := 1; d <= 31; d++ {
+        c.domUpTo[d] = c.domUpTo[d - 1]
+        if c.dom[d] {
+            c.domUpTo[d]++
+        }
+    }
+
+    //  make sure this can ever happen, otherwise we'd search forever for the
+    // next date (eg, "30 2 *")
+    if c.dowStar {
+        possible := false
+        for m := time.January; m <= time.December && !possible; m++ {
+            // 2000 is a leap year, so February gets its 29th
+            for d := 1; d <= daysInMonth(2000, m); d++ {
+                if c.month[m] && c.dom[d] {
+                    possible = true
+                    break
+                }
+            }
+        }
+        if !possible {
+            return nil, errors.New("cron schedule \"" + expr +
+                                   "\" never matches a date")
+        }
+    }
+    return c, nil
+}
+
+//  Parse one field of a cron expression into `set`, which is indexed by the
+// value. Returns whether the field was a bare `*`.
+func cronField(field string, min, max int, set []bool) (bool, error) {
+    bad := errors.New("invalid cron field \"" + field + "\"")
+    for _, part := range strings.Split(field, ",") {
+        step := 1
+        if i := strings.Index(part, "/"); i >= 0 {
+            var err error
+            step, err = strconv.Atoi(part[i + 1:])
+            if err != nil || step <= 0 {
+                return false, bad
+            }
+            part = part[:i]
+        }
+        lo, hi := min, max
+        if part != "*" {
+            bounds := strings.SplitN(part, "-", 2)
+            var err error
+            if lo, err = strconv.Atoi(bounds[0]); err != nil {
+                return false, bad
+            }
+            hi = lo
+            if len(bounds) == 2 {
+                if hi, err = strconv.Atoi(bounds[1]); err != nil {
+                    return false, bad
+                }
+            }
+        }
+        if lo < min || hi > max || lo > hi {
+            return false, bad
+        }
+        for v := lo; v <= hi; v += step {
+            set[v] = true
+        }
+    }
+    return field == "*", nil
+}
+
+func (c *Cron) Contains(start, day time.Time) bool {
+    if !c.month[day.Month()] {
+        return false
+    }
+    domMatch := c.dom[day.Day()]
+    dowMatch := c.dow[day.Weekday()]
+    if c.domStar || c.dowStar {
+        return domMatch && dowMatch
+    }
+    return domMatch || dowMatch
+}
+
+func (c *Cron) countDays(start, from, to time.Time) int {
+    return countByMonth(c, start, from, to)
+}
+
+func (c *Cron) countInMonth(year int, month time.Month) int {
+    if !c.month[month] {
+        return 0
+    }
+    ndays := daysInMonth(year, month)
+    first := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC).Weekday()
+    // how many days in the month match on day of the week
+    nDow := 0
+    for wd := 0; wd < 7; wd++ {
+        if c.dow[wd] {
+            nDow += weekdaysInMonth(time.Weekday(wd), first, ndays)
+        }
+    }
+    switch {
+    case c.domStar && c.dowStar:
+        return ndays
+    case c.domStar:
+        return nDow
+    case c.dowStar:
+        return c.domUpTo[ndays]
+    }
+    // either one will do, just don't count the days matching both twice
+    both := 0
+    for d := 1; d <= ndays; d++ {
+        if c.dom[d] && c.dow[(int(first) + d - 1) % 7] {
+            both++
+        }
+    }
+    return c.domUpTo[ndays] + nDow - both
+}
+
+func (c *Cron) String() string {
+    return "cron:" + c.expr
+}
+
+//  A `Schedule` with some spans of days taken out. The spans are [from, to) in
+// midnight UTC days, sorted and not overlapping.
+type blackedOut struct {
+    sched Schedule
+    spans [][2]time.Time
+}
+
+func (b *blackedOut) Contains(start, day time.Time) bool {
+    for _, span := range b.spans {
+        if !day.Before(span[0]) && day.Before(span[1]) {
+            return false
+        }
+    }
+    return b.sched.Contains(start, day)
+}
+
+func (b *blackedOut) countDays(start, from, to time.Time) int {
+    n := countDays(b.sched, start, from, to)
+    for _, span := range b.spans {
+        lo, hi := span[0], span[1]
+        if lo.Before(from) {
+            lo = from
+        }
+        if hi.After(to) {
+            hi = to
+        }
+        if lo.Before(hi) {
+            n -= countDays(b.sched, start, lo, hi)
+        }
+    }
+    return n
+}
+
+//  The schedule, then the blackouts in the form `ParseBlackouts()` reads, eg
+// "135 except 20150601-20150614,20150704".
+func (b *blackedOut) String() string {
+    days := make([]string, len(b.spans))
+    for i, span := range b.spans {
+        from, to := span[0], span[1].AddDate(0, 0, -1)
+        days[i] = from.Format("20060102")
+        if to.After(from) {
+            days[i] += "-" + to.Format("20060102")
+        }
+    }
+    return b.sched.String() + " except " + strings.Join(days, ",")
+}
+
+//  Read in the compact form of a `Schedule`. The forms are:
+//   "135"              days of the week, 0 is Sunday (`Weekdays`)
+//   "days:3"           every 3 days (`DayInterval`)
+//   "weeks:2:2"        every 2nd week, on Tuesdays (`WeekInterval`)
+//   "nth:1mon,-1fri"   1st Monday and last Friday of the month (`NthWeekdays`)
+//   "cron:1,15 * *"    cron-style day of month, month, day of week (`Cron`)
+func ParseSchedule(s string) (Schedule, error) {
+    s = strings.TrimSpace(s)
+    kind, arg := "", s
+    if i := strings.Index(s, ":"); i >= 0 {
+        kind, arg = s[:i], s[i + 1:]
+    }
+    switch kind {
+    case "":
+        return parseWeekdays(arg)
+    case "days":
+        n, err := strconv.Atoi(arg)
+        if err != nil || n <= 0 {
+            return nil, errors.New("invalid day interval \"" + arg + "\"")
+        }
+        return DayInterval(n), nil
+    case "weeks":
+        parts := strings.SplitN(arg, ":", 2)
+        n, err := strconv.Atoi(parts[0])
+        if err != nil || n <= 0 || len(parts) != 2 {
+            return nil, errors.New("invalid week interval \"" + arg + "\"")
+        }
+        days, err := parseWeekdays(parts[1])
+        if err != nil {
+            return nil, err
+        }
+        return WeekInterval{n, days}, nil
+    case "nth":
+        return parseNthWeekdays(arg)
+    case "cron":
+        return ParseCron(arg)
+    }
+    return nil, errors.New("unknown schedule type \"" + kind + "\"")
+}
+
+func parseWeekdays(s string) (Weekdays, error) {
+    if len(s) == 0 {
+        return nil, errors.New("need at least one day in a schedule")
+    }
+    var seen [7]bool
+    ret := Weekdays{}
+    for _, c := range s {
+        if c < '0' || c > '6' {
+            return nil, errors.New("invalid day of the week \"" +
+                                   string(c) + "\"")
+        }
+        if !seen[c - '0'] {
+            seen[c - '0'] = true
+            ret = append(ret, time.Weekday(c - '0'))
+        }
+    }
+    sort.Slice(ret, func(i, j int) bool { return ret[i] < ret[j] })
+    return ret, nil
+}
+
+func parseNthWeekdays(s string) (NthWeekdays, error) {
+    ret := NthWeekdays{}
+    for _, part := range strings.Split(s, ",") {
+        bad := errors.New("invalid nth weekday \"" + part + "\"")
+        if len(part) < 4 {
+            return nil, bad
+        }
+        n, err := strconv.Atoi(part[:len(part) - 3])
+        if err != nil || n == 0 || n > 5 || n < -5 {
+            return nil, bad
+        }
+        day := -1
+        for i, abbrev := range dayAbbrevs {
+            if strings.ToLower(part[len(part) - 3:]) == abbrev {
+                day = i
+            }
+        }
+        if day < 0 {
+            return nil, bad
+        }
+        ret = append(ret, NthWeekday{n, time.Weekday(day)})
+    }
+    return ret, nil
+}
+
+//  Schedules that can count their days arithmetically, instead of checking
+// each day one at a time. `start` <= `from` always, and all three are midnight
+// UTC. Counts the days `from` <= day < `to`.
+type countingSchedule interface {
+    countDays(start, from, to time.Time) int
+}
+
+//  Schedules that are the same from month to month, and can count how many
+// days in a given month are on the schedule.
+type monthlySchedule interface {
+    Schedule
+    countInMonth(year int, month time.Month) int
+}
+
+//  How many days on the schedule from `from` up to, but not including, `to`?
+// Uses the schedule's own arithmetic if it has some, otherwise goes day by day.
+func countDays(sched Schedule, start, from, to time.Time) int {
+    if cs, ok := sched.(countingSchedule); ok {
+        return cs.countDays(start, from, to)
+    }
+    n := 0
+    for day := from; day.Before(to); day = day.AddDate(0, 0, 1) {
+        if sched.Contains(start, day) {
+            n++
+        }
+    }
+    return n
+}
+
+// Count whole months at a time, and only go day by day in partial months.
+func countByMonth(sched monthlySchedule, start, from, to time.Time) int {
+    n := 0
+    day := from
+    for day.Before(to) {
+        monthStart := time.Date(day.Year(), day.Month(), 1,
+                                0, 0, 0, 0, time.UTC)
+        next := monthStart.AddDate(0, 1, 0)
+        if day.Equal(monthStart) && !next.After(to) {
+            n += sched.countInMonth(day.Year(), day.Month())
+            day = next
+            continue
+        }
+        for ; day.Before(next) && day.Before(to); day = day.AddDate(0, 0, 1) {
+            if sched.Contains(start, day) {
+                n++
+            }
+        }
+    }
+    return n
+}
+
+//  Find the `k`th (counting from 0) day on the schedule. This searches using
+// `countDays()`, so it's only as quick as the schedule is at counting.
+func nthDay(sched Schedule, start time.Time, k int) (time.Time, error) {
+    if k < 0 {
+        return start, errors.New("no dates before the start of a schedule")
+    }
+    // find a number of days that holds enough dates...
+    lo, hi := 0, 1
+    for countDays(sched, start, start, start.AddDate(0, 0, hi)) <= k {
+        if hi > (k + 1) * maxScheduleGap {
+            return start, errors.New("found no dates on schedule")
+        }
+        lo, hi = hi, hi * 2
+    }
+    // ...then narrow down to the first day that does
+    for hi - lo > 1 {
+        mid := (lo + hi) / 2
+        if countDays(sched, start, start, start.AddDate(0, 0, mid)) <= k {
+            lo = mid
+        } else {
+            hi = mid
+        }
+    }
+    return start.AddDate(0, 0, hi - 1), nil
+}
+
+// how many days from `from` to `to`? Both should be midnight UTC.
+func daysBetween(from, to time.Time) int {
+    return int((to.Unix() - from.Unix()) / (24 * 60 * 60))
+}
+
+// smallest integer >= a/b, for b > 0
+func ceilDiv(a, b int) int {
+    q := a / b
+    if a % b != 0 && a > 0 {
+        q++
+    }
+    return q
+}
+
+//  How many times does `wd` show up in a month of `ndays` days, where the 1st
+// is on `first`?
+func weekdaysInMonth(wd, first time.Weekday, ndays int) int {
+    offset := (int(wd) - int(first) + 7) % 7
+    if offset >= ndays {
+        return 0
+    }
+    return (ndays - 1 - offset) / 7 + 1
+}
+
+func startOfWeek(day time.Time) time.Time {
+    return day.AddDate(0, 0, -int(day.Weekday()))
+}
+
+func daysInMonth(year int, month time.Month) int {
+    // day 0 of the next month is the last day of this one
+    return time.Date(year, month + 1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
@@ -0,0 +1,142 @@
+package rssrerun
+
+import (
+    "testing"
+    "time"
+)
+
+func nextDates(t *testing.T, dsrc *DateSource, n int) []time.Time {
+    ret := make([]time.Time, n)
+    for i := 0; i < n; i++ {
+        when, err := dsrc.NextDate()
+        if err != nil {
+            t.Fatal(err)
+        }
+        ret[i] = when
+    }
+    return ret
+}
+
+func TestParseScheduleRoundTrip(t *testing.T) {
+    for _, s := range []string{"024", "days:3", "weeks:2:2", "nth:1mon,-1fri",
+                               "cron:1,15 * *", "cron:*/10 1-6 1-5"} {
+        sched, err := ParseSchedule(s)
+        if err != nil {
+            t.Fatalf("%s: %v", s, err)
+        }
+        if got := sched.String(); got != s {
+            t.Errorf("expected %s, got %s", s, got)
+        }
+    }
+}
+
+func TestParseScheduleInvalid(t *testing.T) {
+    for _, s := range []string{"", "7", "0a", "days:0", "days:x", "weeks:2",
+                               "weeks:0:2", "nth:6mon", "nth:0tue",
+                               "nth:1foo", "cron:1 *", "cron:32 * *",
+                               "cron:30 2 *", "foo:123"} {
+        if _, err := ParseSchedule(s); err == nil {
+            t.Errorf("expected an error parsing \"%s\"", s)
+        }
+    }
+}
+
+func TestParseLegacyWeekdays(t *testing.T) {
+    sched, err := ParseSchedule("20")
+    if err != nil {
+        t.Fatal(err)
+    }
+    dsrc := NewScheduledDateSource(StartDate(), sched)
+    for _, when := range nextDates(t, dsrc, 20) {
+        if when.Weekday() != time.Sunday && when.Weekday() != time.Tuesday {
+            t.Errorf("%v is not on schedule", when)
+        }
+    }
+}
+
+func TestDayInterval(t *testing.T) {
+    dsrc := NewScheduledDateSource(StartDate(), DayInterval(3))
+    for i, when := range nextDates(t, dsrc, 20) {
+        if cmpDays(when, StartDate().AddDate(0, 0, 3 * i)) != 0 {
+            t.Errorf("date %d should be %v, got %v", i,
+                     StartDate().AddDate(0, 0, 3 * i), when)
+        }
+    }
+}
+
+func TestEveryOtherTuesday(t *testing.T) {
+    dsrc := NewScheduledDateSource(StartDate(),
+                                   WeekInterval{2, Weekdays{time.Tuesday}})
+    // StartDate() is a Thursday, so we've already missed that week's Tuesday
+    first := time.Date(2015, time.March, 31, 0, 0, 0, 0, time.UTC)
+    for i, when := range nextDates(t, dsrc, 20) {
+        if cmpDays(when, first.AddDate(0, 0, 14 * i)) != 0 {
+            t.Errorf("date %d should be %v, got %v", i,
+                     first.AddDate(0, 0, 14 * i), when)
+        }
+    }
+}
+
+func TestNthWeekdays(t *testing.T) {
+    sched, err := ParseSchedule("nth:1mon,-1fri")
+    if err != nil {
+        t.Fatal(err)
+    }
+    dsrc := NewScheduledDateSource(StartDate(), sched)
+    expected := []time.Time{
+        time.Date(2015, time.March, 27, 0, 0, 0, 0, time.UTC),
+        time.Date(2015, time.April, 6, 0, 0, 0, 0, time.UTC),
+        time.Date(2015, time.April, 24, 0, 0, 0, 0, time.UTC),
+        time.Date(2015, time.May, 4, 0, 0, 0, 0, time.UTC),
+        time.Date(2015, time.May, 29, 0, 0, 0, 0, time.UTC),
+    }
+    for i, when := range nextDates(t, dsrc, len(expected)) {
+        if cmpDays(when, expected[i]) != 0 {
+            t.Errorf("date %d should be %v, got %v", i, expected[i], when)
+        }
+    }
+}
+
+func TestCronFirstAndFifteenth(t *testing.T) {
+    sched, err := ParseSchedule("cron:1,15 * *")
+    if err != nil {
+        t.Fatal(err)
+    }
+    dsrc := NewScheduledDateSource(StartDate(), sched)
+    for i, when := range nextDates(t, dsrc, 24) {
+        if when.Day() != 1 && when.Day() != 15 {
+            t.Errorf("date %d (%v) is not the 1st or 15th", i, when)
+        }
+        if i == 0 && cmpDays(when, time.Date(2015, time.April, 1,
+                                             0, 0, 0, 0, time.UTC)) != 0 {
+            t.Errorf("expected to start on April 1st, got %v", when)
+        }
+    }
+}
+
+func TestCronDomOrDow(t *testing.T) {
+    // like cron, restricting both day of month and day of week means either
+    sched, err := ParseCron("13 * 5")
+    if err != nil {
+        t.Fatal(err)
+    }
+    dsrc := NewScheduledDateSource(StartDate(), sched)
+    for _, when := range nextDates(t, dsrc, 30) {
+        if when.Day() != 13 && when.Weekday() != time.Friday {
+            t.Errorf("%v is neither the 13th nor a Friday", when)
+        }
+    }
+}
+
+func TestCronSundaySeven(t *testing.T) {
+    sched, err := ParseCron("* * 7")
+    if err != nil {
+        t.Fatal(err)
+    }
+    dsrc := NewScheduledDateSource(StartDate(), sched)
+    for _, when := range nextDates(t, dsrc, 10) {
+        if when.Weekday() != time.Sunday {
+            t.Errorf("%v is not a Sunday", when)
+        }
+    }
+}
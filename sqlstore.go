@@ -0,0 +1,296 @@
+package rssrerun
+
+import (
+    "database/sql"
+    "errors"
+
+    _ "github.com/mattn/go-sqlite3"
+)
+
+//  A `Store` in an SQLite database, for when the `jsonStore` gets to be too
+// many files. Each item is a row, so getting a range of them is one query, and
+// updates happen in a transaction so a feed is never left half-updated.
+/*
+feeds: a row per feed, and how many items it has
+items: the items, numbered from 0 (oldest) for each feed
+guids: which guids each feed has, so we only store an item once
+meta: the general-purpose metadata (see `Store.GetInfo()`)
+*/
+
+const sqlSchema = `
+CREATE TABLE IF NOT EXISTS feeds (
+    id INTEGER PRIMARY KEY,
+    url TEXT NOT NULL UNIQUE,
+    count INTEGER NOT NULL DEFAULT 0
+);
+CREATE TABLE IF NOT EXISTS items (
+    feed INTEGER NOT NULL REFERENCES feeds(id),
+    seq INTEGER NOT NULL,
+    body TEXT NOT NULL,
+    PRIMARY KEY (feed, seq)
+);
+CREATE TABLE IF NOT EXISTS guids (
+    feed INTEGER NOT NULL REFERENCES feeds(id),
+    guid TEXT NOT NULL,
+    PRIMARY KEY (feed, guid)
+);
+CREATE TABLE IF NOT EXISTS meta (
+    feed INTEGER NOT NULL REFERENCES feeds(id),
+    key TEXT NOT NULL,
+    val TEXT NOT NULL,
+    PRIMARY KEY (feed, key)
+);
+`
+
+type sqlStore struct {
+    db *sql.DB
+    // function to canonicalize a url
+    canon func(string) (string, error)
+}
+
+//  Open (or create) the SQLite database at `path` as a `Store`. Like
+// `NewJSONStore()`, urls are canonicalized by following them.
+func NewSQLiteStore(path string) (*sqlStore, error) {
+    return NewSQLiteStoreWith(path, nil, 0)
+}
+
+//  Like `NewSQLiteStore()`, but canonicalizing urls with `canon`, caching the
+// last `cacheSize` of them, the same as `JSONStoreOptions` do.
+func NewSQLiteStoreWith(path string, canon func(string) (string, error),
+                        cacheSize int) (*sqlStore, error) {
+    db, err := sql.Open("sqlite3", path + "?_busy_timeout=5000&_foreign_keys=1")
+    if err != nil {
+        return nil, err
+    }
+    //  SQLite only has the one writer anyway, and this way we never find the
+    // database locked by our own other connection.
+    db.SetMaxOpenConns(1)
+    if _, err = db.Exec(sqlSchema); err != nil {
+        db.Close()
+        return nil, err
+    }
+    return &sqlStore{db, cachingCanon(canon, cacheSize)}, nil
+}
+
+func (s *sqlStore) Close() error {
+    return s.db.Close()
+}
+
+// either the database itself, or a transaction in it
+type sqlQueryer interface {
+    QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// the row id and item count for `url`, which has already been canonicalized
+func (s *sqlStore) feedRow(q sqlQueryer, url string) (int64, int, error) {
+    var id int64
+    var count int
+    row := q.QueryRow("SELECT id, count FROM feeds WHERE url = ?", url)
+    err := row.Scan(&id, &count)
+    if err == sql.ErrNoRows {
+        return 0, 0, errors.New("couldn't find url")
+    }
+    return id, count, err
+}
+
+func (s *sqlStore) CreateIndex(url string) (Index, error) {
+    url, err := s.canon(url)
+    if err != nil {
+        return Index{}, err
+    }
+    if s.Contains(url) {
+        return Index{}, errors.New("Index already exists")
+    }
+    if _, err = s.db.Exec("INSERT INTO feeds (url) VALUES (?)", url); err != nil {
+        return Index{}, err
+    }
+    return Index{Url: url}, nil
+}
+
+func (s *sqlStore) Get(url string, start int, end int) ([]Item, error) {
+    if start < 0 || end <= start {
+        return nil, errors.New("invalid range")
+    }
+    url, err := s.canon(url)
+    if err != nil {
+        return nil, err
+    }
+    return s.getRange(url, start, end)
+}
+
+func (s *sqlStore) getRange(url string, start int, end int) ([]Item, error) {
+    rows, err := s.db.Query(`SELECT items.body FROM items
+                             JOIN feeds ON items.feed = feeds.id
+                             WHERE feeds.url = ? AND items.seq >= ?
+                                   AND items.seq < ?
+                             ORDER BY items.seq`, url, start, end)
+    if err != nil {
+        return nil, err
+    }
+    ret := make([]Item, 0, end - start)
+    for rows.Next() {
+        var body string
+        if err = rows.Scan(&body); err != nil {
+            rows.Close()
+            return nil, err
+        }
+        item, err := MkItem([]byte(body))
+        if err != nil {
+            rows.Close()
+            return nil, err
+        }
+        ret = append(ret, item)
+    }
+    rows.Close()
+    if err = rows.Err(); err != nil {
+        return nil, err
+    }
+    if len(ret) != end - start {
+        // either it's past the end, or there's no such feed
+        if _, _, err = s.feedRow(s.db, url); err != nil {
+            return nil, err
+        }
+        return nil, errors.New("invalid range")
+    }
+    return ret, nil
+}
+
+func (s *sqlStore) NumItems(url string) int {
+    url, err := s.canon(url)
+    if err != nil {
+        return 0
+    }
+    _, count, err := s.feedRow(s.db, url)
+    if err != nil {
+        return 0
+    }
+    return count
+}
+
+func (s *sqlStore) Update(url string, items []Item) error {
+    // items must be passed in oldest first
+    url, err := s.canon(url)
+    if err != nil {
+        return err
+    }
+    tx, err := s.db.Begin()
+    if err != nil {
+        return err
+    }
+    // only does anything if we don't get to the `Commit()`
+    defer tx.Rollback()
+
+    id, count, err := s.feedRow(tx, url)
+    if err != nil {
+        return err
+    }
+    for _, it := range items {
+        guid, err := it.Guid()
+        if err != nil {
+            return err
+        }
+        res, err := tx.Exec(`INSERT OR IGNORE INTO guids (feed, guid)
+                             VALUES (?, ?)`, id, guid)
+        if err != nil {
+            return err
+        }
+        if n, err := res.RowsAffected(); err != nil {
+            return err
+        } else if n == 0 {
+            // already have it
+            continue
+        }
+        _, err = tx.Exec("INSERT INTO items (feed, seq, body) VALUES (?, ?, ?)",
+                         id, count, it.String())
+        if err != nil {
+            return err
+        }
+        count++
+    }
+    _, err = tx.Exec("UPDATE feeds SET count = ? WHERE id = ?", count, id)
+    if err != nil {
+        return err
+    }
+    return tx.Commit()
+}
+
+func (s *sqlStore) GetInfo(url string, key string) (string, error) {
+    url, err := s.canon(url)
+    if err != nil {
+        return "", err
+    }
+    id, _, err := s.feedRow(s.db, url)
+    if err != nil {
+        return "", err
+    }
+    val := ""
+    err = s.db.QueryRow("SELECT val FROM meta WHERE feed = ? AND key = ?",
+                        id, key).Scan(&val)
+    if err == sql.ErrNoRows {
+        return "", nil
+    }
+    return val, err
+}
+
+func (s *sqlStore) SetInfo(url string, key string, val string) error {
+    url, err := s.canon(url)
+    if err != nil {
+        return err
+    }
+    id, _, err := s.feedRow(s.db, url)
+    if err != nil {
+        return err
+    }
+    _, err = s.db.Exec(`INSERT OR REPLACE INTO meta (feed, key, val)
+                        VALUES (?, ?, ?)`, id, key, val)
+    return err
+}
+
+func (s *sqlStore) FeedFor(url string, ds *DateSource) (Feed, error) {
+    url, err := s.canon(url)
+    if err != nil {
+        return nil, err
+    }
+    _, count, err := s.feedRow(s.db, url)
+    if err != nil {
+        return nil, err
+    }
+    wrap, err := s.GetInfo(url, "wrapper")
+    if err != nil {
+        return nil, err
+    }
+    feed, err := NewFeed([]byte(wrap), nil)
+    if err != nil {
+        return nil, err
+    }
+    get := func(start, end int) ([]Item, error) {
+        return s.getRange(url, start, end)
+    }
+    return &StoredFeed{feed, ds, count, get}, nil
+}
+
+func (s *sqlStore) Contains(url string) bool {
+    url, err := s.canon(url)
+    if err != nil {
+        return false
+    }
+    _, _, err = s.feedRow(s.db, url)
+    return err == nil
+}
+
+func (s *sqlStore) List() []string {
+    rows, err := s.db.Query("SELECT url FROM feeds ORDER BY id")
+    if err != nil {
+        return nil
+    }
+    defer rows.Close()
+    retval := []string{}
+    for rows.Next() {
+        var url string
+        if err = rows.Scan(&url); err != nil {
+            return nil
+        }
+        retval = append(retval, url)
+    }
+    return retval
+}
@@ -0,0 +1,27 @@
+package rssrerun
+
+import (
+    "os"
+    "testing"
+)
+
+var lastSQLStore *sqlStore
+
+func emptySQLiteStore() Store {
+    if lastSQLStore != nil {
+        lastSQLStore.Close()
+    }
+    _ = os.Remove(TDir + "/store.db")
+    s, err := NewSQLiteStoreWith(TDir + "/store.db", CanonIdentity, 0)
+    if err != nil {
+        panic(err.Error())
+    }
+    lastSQLStore = s
+    return Store(s)
+}
+
+func TestSQLiteStore(t *testing.T) {
+    runStoreSuite(t, emptySQLiteStore)
+    lastSQLStore.Close()
+    lastSQLStore = nil
+}
@@ -125,6 +125,167 @@ func (a *ATOM) Items() []string {
     return a.items
 }
 
+
+type JSON struct {
+    items []string
+}
+
+func CreateAndPopulateJSON(n int, d time.Time) *JSON {
+    if n < 0 {
+        return nil
+    }
+    ret := new(JSON)
+    for i := n; i >= 1; i-- {
+        pubdate := d.AddDate(0, 0, 7 * (i - 1)).Format(time.RFC3339)
+        postText := "{\"id\": \"" + strconv.Itoa(i) + "\", "
+        postText += "\"title\": \"post number " + strconv.Itoa(i) + "\", "
+        postText += "\"date_published\": \"" + pubdate + "\", "
+        postText += "\"url\": \"url://foo.bar/json/" + strconv.Itoa(i) + "\", "
+        postText += "\"content_text\": \"originally published " + pubdate
+        postText += "\"}"
+        ret.AddPost(postText)
+    }
+    return ret
+}
+
+func (j *JSON) AddPost(s string) {
+    j.items = append(j.items, s)
+}
+
+func (j *JSON) Text() string {
+    retval := "{\"version\": \"https://jsonfeed.org/version/1.1\",\n"
+    retval += "\"title\": \"foo\",\n"
+    retval += "\"home_page_url\": \"http://example.com\",\n"
+    retval += "\"description\": \"Foobity foo bar.\",\n"
+    retval += "\"items\": [\n" + strings.Join(j.items, ",\n") + "]}\n"
+    return retval
+}
+
+func (j *JSON) Bytes() []byte {
+    return []byte(j.Text())
+}
+
+func (j *JSON) Items() []string {
+    return j.items
+}
+
+type RDF struct {
+    items []string
+}
+
+func CreateAndPopulateRDF(n int, d time.Time) *RDF {
+    if n < 0 {
+        return nil
+    }
+    ret := new(RDF)
+    for i := n; i >= 1; i-- {
+        pubdate := d.AddDate(0, 0, 7 * (i - 1)).Format(time.RFC3339)
+        // just like the Atom ids, should really be a URI
+        postText := "<item rdf:about=\"" + strconv.Itoa(i) + "\">"
+        postText += "<title>post number " + strconv.Itoa(i) + "</title>"
+        postText += "<link>url://foo.bar/rdf/" + strconv.Itoa(i) + "</link>"
+        postText += "<dc:date>" + pubdate + "</dc:date>"
+        postText += "<description>originally published " + pubdate
+        postText += "</description></item>"
+        ret.AddPost(postText)
+    }
+    return ret
+}
+
+func (r *RDF) AddPost(s string) {
+    r.items = append(r.items, s)
+}
+
+func (r *RDF) Text() string {
+    retval := "<?xml version=\"1.0\" encoding=\"utf-8\"?>\n"
+    retval += "<rdf:RDF xmlns:rdf=\"http://www.w3.org/1999/02/22-rdf-syntax-ns#\"\n"
+    retval += " xmlns=\"http://purl.org/rss/1.0/\"\n"
+    retval += " xmlns:dc=\"http://purl.org/dc/elements/1.1/\">\n"
+    retval += "<channel rdf:about=\"http://example.com\"><title>foo</title>\n"
+    retval += "<link>http://example.com</link>\n"
+    retval += "<description>Foobity foo bar.</description>\n"
+    retval += "<items><rdf:Seq>\n"
+    for _, item := range r.items {
+        // the channel lists every item by its rdf:about
+        if about := strings.SplitN(item, "rdf:about=\"", 2); len(about) == 2 {
+            resource := strings.SplitN(about[1], "\"", 2)[0]
+            retval += "<rdf:li rdf:resource=\"" + resource + "\"/>\n"
+        }
+    }
+    retval += "</rdf:Seq></items></channel>\n"
+    retval += strings.Join(r.items, "\n")
+    retval += "</rdf:RDF>\n"
+    return retval
+}
+
+func (r *RDF) Bytes() []byte {
+    return []byte(r.Text())
+}
+
+func (r *RDF) Items() []string {
+    return r.items
+}
+
 func StartDate() time.Time {
     return time.Date(2015, 4, 12, 1, 0, 0, 0, time.UTC)
 }
+
+//  An RSS feed in ISO-8859-1, for checking that feeds not in UTF-8 come
+// through. The titles have accents in them, so `Text()` is the feed as it
+// should read, and `Bytes()` is that in Latin-1 (which a Go string can't be).
+type Latin1RSS struct {
+    items []string
+}
+
+func CreateLatin1RSS(n int, d time.Time) *Latin1RSS {
+    if n < 0 {
+        return nil
+    }
+    ret := new(Latin1RSS)
+    for i := n; i >= 1; i-- {
+        pubdate := d.AddDate(0, 0, 7*(i - 1)).Format(time.RFC822)
+        postText := "<item><title>café numéro " + strconv.Itoa(i) + "</title>"
+        postText += "<pubDate>" + pubdate + "</pubDate>"
+        postText += "<guid>" + strconv.Itoa(i) + "</guid>"
+        postText += "<link>url://foo.bar/rss/" + strconv.Itoa(i) + "</link>"
+        postText += "<description>publié à l'origine le " + pubdate
+        postText += "</description></item>"
+        ret.AddPost(postText)
+    }
+    return ret
+}
+
+func (r *Latin1RSS) AddPost(s string) {
+    r.items = append(r.items, s)
+}
+
+func (r *Latin1RSS) Text() string {
+    retval := "<?xml version=\"1.0\" encoding=\"ISO-8859-1\"?>\n"
+    retval += "<rss version=\"2.0\"><channel><title>Café Fußball</title>\n"
+    retval += "<link>http://example.com</link>\n"
+    retval += "<description>Ça, c'est très bien.</description>\n"
+    retval += strings.Join(r.items, "\n")
+    retval += "</channel></rss>\n"
+    return retval
+}
+
+func (r *Latin1RSS) Bytes() []byte {
+    return Latin1(r.Text())
+}
+
+func (r *Latin1RSS) Items() []string {
+    return r.items
+}
+
+//  `s` in ISO-8859-1. Anything that isn't in Latin-1 becomes a "?", so keep it
+// to Western European.
+func Latin1(s string) []byte {
+    ret := make([]byte, 0, len(s))
+    for _, r := range s {
+        if r > 0xff {
+            r = '?'
+        }
+        ret = append(ret, byte(r))
+    }
+    return ret
+}
@@ -117,10 +117,10 @@ func LimitedBody(url string, maxBytes int) (*http.Response, error) {
 // canonicalize an `url` by following any redirects until we get data
 func CanonicalUrl(url string) (string, error) {
     data, err := Get(url)
-    data.Body.Close()
     if err != nil {
         return "", err
     }
+    data.Body.Close()
     if data.StatusCode >= 400 {
         return "", errors.New(data.Status)
     }